@@ -0,0 +1,277 @@
+package gofalcon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/m-mizutani/gofalcon/fql"
+	"github.com/pkg/errors"
+)
+
+// AggregationType is type of aggregation of "aggregates" endpoints.
+type AggregationType string
+
+// Types of aggregation
+const (
+	AggregationTerms         AggregationType = "terms"
+	AggregationDateHistogram AggregationType = "date_histogram"
+	AggregationDateRange     AggregationType = "date_range"
+	AggregationRange         AggregationType = "range"
+	AggregationCardinality   AggregationType = "cardinality"
+)
+
+// Aggregation is a query of "aggregates" endpoints. Name, Type and Field are required.
+type Aggregation struct {
+	// Name identifies result of the aggregation in output
+	Name  string
+	Type  AggregationType
+	Field string
+
+	Filter string
+	// FilterExpr is FQL filter built by fql package. It's joined with Filter by AND if both are set.
+	FilterExpr fql.Expr
+	Q          string
+
+	// Size is maximum number of buckets of terms aggregation
+	Size int
+	// Sort is order of buckets, e.g. "count|desc"
+	Sort        string
+	MinDocCount *int
+	// Missing is a value used for documents that do not have Field
+	Missing string
+
+	// Interval is required for date_histogram: year, month, week, day, hour or minute
+	Interval string
+	TimeZone string
+
+	// Ranges is required for range aggregation
+	Ranges []RangeSpec
+	// DateRanges is required for date_range aggregation
+	DateRanges []DateRangeSpec
+
+	// SubAggregates are applied to each bucket of the aggregation
+	SubAggregates []Aggregation
+}
+
+// RangeSpec is a bucket of range aggregation. Documents in [From, To) are counted. Bounds can be fractional, e.g. for score.
+type RangeSpec struct {
+	From float64 `json:"From"`
+	To   float64 `json:"To"`
+}
+
+// DateRangeSpec is a bucket of date_range aggregation. From and To are date string (e.g. RFC3339) or date math (e.g. "now-1d").
+type DateRangeSpec struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type aggregationRequest struct {
+	Name          string               `json:"name"`
+	Type          AggregationType      `json:"type"`
+	Field         string               `json:"field"`
+	Filter        string               `json:"filter,omitempty"`
+	Q             string               `json:"q,omitempty"`
+	Size          int                  `json:"size,omitempty"`
+	Sort          string               `json:"sort,omitempty"`
+	MinDocCount   *int                 `json:"min_doc_count,omitempty"`
+	Missing       string               `json:"missing,omitempty"`
+	Interval      string               `json:"interval,omitempty"`
+	TimeZone      string               `json:"time_zone,omitempty"`
+	Ranges        []RangeSpec          `json:"ranges,omitempty"`
+	DateRanges    []DateRangeSpec      `json:"date_ranges,omitempty"`
+	SubAggregates []aggregationRequest `json:"sub_aggregates,omitempty"`
+}
+
+// Validate checks required fields of x and its sub aggregations. Names of sub aggregations must be unique in each level because results are looked up by name.
+func (x *Aggregation) Validate() error {
+	if x.Name == "" {
+		return errors.New("Name is required for aggregation")
+	}
+	if x.Field == "" {
+		return errors.Errorf("Field is required for aggregation %s", x.Name)
+	}
+
+	switch x.Type {
+	case AggregationTerms, AggregationCardinality:
+	case AggregationDateHistogram:
+		if x.Interval == "" {
+			return errors.Errorf("Interval is required for date_histogram aggregation %s", x.Name)
+		}
+	case AggregationRange:
+		if len(x.Ranges) == 0 {
+			return errors.Errorf("Ranges is required for range aggregation %s", x.Name)
+		}
+	case AggregationDateRange:
+		if len(x.DateRanges) == 0 {
+			return errors.Errorf("DateRanges is required for date_range aggregation %s", x.Name)
+		}
+	default:
+		return errors.Errorf("Invalid type of aggregation %s: %q", x.Name, x.Type)
+	}
+
+	names := map[string]bool{}
+	for i := range x.SubAggregates {
+		if err := x.SubAggregates[i].Validate(); err != nil {
+			return errors.Wrapf(err, "Invalid sub aggregation of %s", x.Name)
+		}
+		if names[x.SubAggregates[i].Name] {
+			return errors.Errorf("Duplicated name of sub aggregation of %s: %s", x.Name, x.SubAggregates[i].Name)
+		}
+		names[x.SubAggregates[i].Name] = true
+	}
+	return nil
+}
+
+func (x *Aggregation) request() aggregationRequest {
+	req := aggregationRequest{
+		Name:        x.Name,
+		Type:        x.Type,
+		Field:       x.Field,
+		Filter:      joinFilters(x.Filter, exprString(x.FilterExpr)),
+		Q:           x.Q,
+		Size:        x.Size,
+		Sort:        x.Sort,
+		MinDocCount: x.MinDocCount,
+		Missing:     x.Missing,
+		Interval:    x.Interval,
+		TimeZone:    x.TimeZone,
+		Ranges:      x.Ranges,
+		DateRanges:  x.DateRanges,
+	}
+	for i := range x.SubAggregates {
+		req.SubAggregates = append(req.SubAggregates, x.SubAggregates[i].request())
+	}
+	return req
+}
+
+// AggregationResult is result of an Aggregation.
+type AggregationResult struct {
+	Name             string              `json:"name"`
+	Buckets          []AggregationBucket `json:"buckets"`
+	SumOtherDocCount int64               `json:"sum_other_doc_count"`
+}
+
+// Cardinality returns number of distinct values counted by cardinality aggregation.
+func (x *AggregationResult) Cardinality() int64 {
+	if len(x.Buckets) == 0 {
+		return 0
+	}
+	if b := x.Buckets[0]; b.Count > 0 {
+		return b.Count
+	}
+	return int64(x.Buckets[0].Value)
+}
+
+// AggregationBucket is a bucket of AggregationResult. Which fields are set depends on type of aggregation.
+type AggregationBucket struct {
+	Count int64 `json:"count"`
+	// Label is term of terms aggregation
+	Label interface{} `json:"label"`
+	// KeyAsString is start time of date_histogram bucket
+	KeyAsString string `json:"key_as_string"`
+
+	// From and To are boundaries of range bucket
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+	// StringFrom and StringTo are boundaries of date_range bucket
+	StringFrom string `json:"string_from"`
+	StringTo   string `json:"string_to"`
+
+	Value         float64 `json:"value"`
+	ValueAsString string  `json:"value_as_string"`
+
+	SubAggregates []AggregationResult `json:"sub_aggregates"`
+}
+
+// Key returns term of terms bucket. KeyAsString is returned if Label is not set.
+func (x *AggregationBucket) Key() string {
+	switch v := x.Label.(type) {
+	case nil:
+		return x.KeyAsString
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Time returns start time of date_histogram bucket.
+func (x *AggregationBucket) Time() (time.Time, error) {
+	if x.KeyAsString != "" {
+		t, err := time.Parse(time.RFC3339Nano, x.KeyAsString)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "Fail to parse key_as_string of bucket: %s", x.KeyAsString)
+		}
+		return t, nil
+	}
+	if ms, ok := x.Label.(float64); ok {
+		return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Time{}, errors.New("Bucket has no time key")
+}
+
+// SubAggregate returns result of sub aggregation by name, or nil if not found.
+func (x *AggregationBucket) SubAggregate(name string) *AggregationResult {
+	return findAggregation(x.SubAggregates, name)
+}
+
+// AggregatesInput is arguments of "aggregates" endpoints. Results are returned in same order with Aggregations.
+type AggregatesInput struct {
+	Aggregations []Aggregation
+}
+
+// AggregatesOutput is response of "aggregates" endpoints.
+type AggregatesOutput struct {
+	BaseResponse
+	Resources []AggregationResult `json:"resources"`
+}
+
+// Result returns result of aggregation by name, or nil if not found.
+func (x *AggregatesOutput) Result(name string) *AggregationResult {
+	return findAggregation(x.Resources, name)
+}
+
+func findAggregation(results []AggregationResult, name string) *AggregationResult {
+	for i := range results {
+		if results[i].Name == name {
+			return &results[i]
+		}
+	}
+	return nil
+}
+
+// marshalAggregations validates aggregations and encodes them to request body of "aggregates" endpoints.
+func marshalAggregations(aggregations []Aggregation) ([]byte, error) {
+	if len(aggregations) == 0 {
+		return nil, errors.New("At least one aggregation is required")
+	}
+
+	names := map[string]bool{}
+	reqs := make([]aggregationRequest, len(aggregations))
+	for i := range aggregations {
+		if err := aggregations[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[aggregations[i].Name] {
+			return nil, errors.Errorf("Duplicated name of aggregation: %s", aggregations[i].Name)
+		}
+		names[aggregations[i].Name] = true
+		reqs[i] = aggregations[i].request()
+	}
+
+	raw, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to marshal aggregations")
+	}
+	return raw, nil
+}
+
+func aggregationNames(aggregations []Aggregation) string {
+	names := make([]string, len(aggregations))
+	for i := range aggregations {
+		names[i] = aggregations[i].Name
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,219 @@
+package gofalcon_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/m-mizutani/gofalcon/fql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectionAggregates(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	server.AddDetections(
+		gofalcon.DetectionResources{
+			DetectionID:  "ldt:1",
+			MaxSeverity:  90,
+			Status:       "new",
+			LastBehavior: day.Add(time.Hour),
+			Behaviors:    []gofalcon.DetectionBehavior{{Tactic: "Execution"}, {Tactic: "Persistence"}},
+			Device:       gofalcon.DeviceResource{DeviceID: "aid1"},
+		},
+		gofalcon.DetectionResources{
+			DetectionID:  "ldt:2",
+			MaxSeverity:  50,
+			Status:       "new",
+			LastBehavior: day.Add(time.Hour * 2),
+			Behaviors:    []gofalcon.DetectionBehavior{{Tactic: "Execution"}},
+			Device:       gofalcon.DeviceResource{DeviceID: "aid1"},
+		},
+		gofalcon.DetectionResources{
+			DetectionID:  "ldt:3",
+			MaxSeverity:  30,
+			Status:       "closed",
+			LastBehavior: day.Add(time.Hour * 25),
+			Behaviors:    []gofalcon.DetectionBehavior{{Tactic: "Discovery"}},
+			Device:       gofalcon.DeviceResource{DeviceID: "aid2"},
+		},
+	)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	output, err := client.Detection.Aggregates(&gofalcon.AggregatesInput{
+		Aggregations: []gofalcon.Aggregation{
+			{
+				Name:  "by_status",
+				Type:  gofalcon.AggregationTerms,
+				Field: "status",
+				SubAggregates: []gofalcon.Aggregation{
+					{Name: "tactic", Type: gofalcon.AggregationTerms, Field: "behaviors.tactic"},
+				},
+			},
+			{
+				Name:   "severity",
+				Type:   gofalcon.AggregationRange,
+				Field:  "max_severity",
+				Ranges: []gofalcon.RangeSpec{{From: 0, To: 50}, {From: 50, To: 101}},
+			},
+			{
+				Name:     "daily",
+				Type:     gofalcon.AggregationDateHistogram,
+				Field:    "last_behavior",
+				Interval: "day",
+			},
+			{
+				Name:       "recent",
+				Type:       gofalcon.AggregationDateRange,
+				Field:      "last_behavior",
+				DateRanges: []gofalcon.DateRangeSpec{{From: "2021-03-01T12:00:00Z"}},
+			},
+			{
+				Name:  "hosts",
+				Type:  gofalcon.AggregationCardinality,
+				Field: "device.device_id",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(output.Resources))
+
+	t.Run("terms with sub aggregation", func(t *testing.T) {
+		result := output.Result("by_status")
+		require.NotNil(t, result)
+		require.Equal(t, 2, len(result.Buckets))
+		assert.Equal(t, "new", result.Buckets[0].Key())
+		assert.Equal(t, int64(2), result.Buckets[0].Count)
+		assert.Equal(t, "closed", result.Buckets[1].Key())
+
+		tactic := result.Buckets[0].SubAggregate("tactic")
+		require.NotNil(t, tactic)
+		require.Equal(t, 2, len(tactic.Buckets))
+		assert.Equal(t, "Execution", tactic.Buckets[0].Key())
+		assert.Equal(t, int64(2), tactic.Buckets[0].Count)
+		assert.Equal(t, "Persistence", tactic.Buckets[1].Key())
+		assert.Nil(t, result.Buckets[0].SubAggregate("unknown"))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		result := output.Result("severity")
+		require.NotNil(t, result)
+		require.Equal(t, 2, len(result.Buckets))
+		assert.Equal(t, int64(1), result.Buckets[0].Count)
+		assert.Equal(t, int64(2), result.Buckets[1].Count)
+		assert.Equal(t, 50.0, result.Buckets[1].From)
+	})
+
+	t.Run("date histogram", func(t *testing.T) {
+		result := output.Result("daily")
+		require.NotNil(t, result)
+		require.Equal(t, 2, len(result.Buckets))
+		ts, err := result.Buckets[0].Time()
+		require.NoError(t, err)
+		assert.True(t, day.Equal(ts))
+		assert.Equal(t, int64(2), result.Buckets[0].Count)
+		ts, err = result.Buckets[1].Time()
+		require.NoError(t, err)
+		assert.True(t, day.AddDate(0, 0, 1).Equal(ts))
+	})
+
+	t.Run("date range", func(t *testing.T) {
+		result := output.Result("recent")
+		require.NotNil(t, result)
+		require.Equal(t, 1, len(result.Buckets))
+		assert.Equal(t, int64(1), result.Buckets[0].Count)
+		assert.Equal(t, "2021-03-01T12:00:00Z", result.Buckets[0].StringFrom)
+	})
+
+	t.Run("cardinality", func(t *testing.T) {
+		result := output.Result("hosts")
+		require.NotNil(t, result)
+		assert.Equal(t, int64(2), result.Cardinality())
+	})
+
+	assert.Nil(t, output.Result("unknown"))
+}
+
+func TestDetectionAggregatesRequest(t *testing.T) {
+	var body []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(raw, &body)
+		w.Write([]byte(`{"resources":[{"name":"severity","buckets":[{"label":50,"count":3}]}]}`))
+	}))
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	output, err := client.Detection.Aggregates(&gofalcon.AggregatesInput{
+		Aggregations: []gofalcon.Aggregation{
+			{
+				Name:       "severity",
+				Type:       gofalcon.AggregationRange,
+				Field:      "max_severity",
+				Filter:     "status:'new'",
+				FilterExpr: fql.Ge("max_severity", 50),
+				Ranges:     []gofalcon.RangeSpec{{From: 50, To: 99.5}},
+				SubAggregates: []gofalcon.Aggregation{
+					{Name: "tactic", Type: gofalcon.AggregationTerms, Field: "behaviors.tactic", Size: 5},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "50", output.Resources[0].Buckets[0].Key())
+
+	require.Equal(t, 1, len(body))
+	assert.Equal(t, "range", body[0]["type"])
+	assert.Equal(t, "(status:'new')+(max_severity:>=50)", body[0]["filter"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"From": 50.0, "To": 99.5}}, body[0]["ranges"])
+	subs := body[0]["sub_aggregates"].([]interface{})
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, 5.0, subs[0].(map[string]interface{})["size"])
+}
+
+func TestDetectionAggregatesValidation(t *testing.T) {
+	client := gofalcon.NewClient()
+	client.Endpoint = "http://127.0.0.1:0"
+
+	invalid := map[string][]gofalcon.Aggregation{
+		"no aggregation": nil,
+		"no name":        {{Type: gofalcon.AggregationTerms, Field: "status"}},
+		"no field":       {{Name: "a", Type: gofalcon.AggregationTerms}},
+		"unknown type":   {{Name: "a", Type: "histogram", Field: "status"}},
+		"no interval":    {{Name: "a", Type: gofalcon.AggregationDateHistogram, Field: "last_behavior"}},
+		"no ranges":      {{Name: "a", Type: gofalcon.AggregationRange, Field: "max_severity"}},
+		"no date ranges": {{Name: "a", Type: gofalcon.AggregationDateRange, Field: "last_behavior"}},
+		"duplicated name": {
+			{Name: "a", Type: gofalcon.AggregationTerms, Field: "status"},
+			{Name: "a", Type: gofalcon.AggregationCardinality, Field: "status"},
+		},
+		"duplicated sub aggregation name": {
+			{Name: "a", Type: gofalcon.AggregationTerms, Field: "status", SubAggregates: []gofalcon.Aggregation{
+				{Name: "b", Type: gofalcon.AggregationTerms, Field: "tactic"},
+				{Name: "b", Type: gofalcon.AggregationCardinality, Field: "device.device_id"},
+			}},
+		},
+		"invalid sub aggregation": {
+			{Name: "a", Type: gofalcon.AggregationTerms, Field: "status", SubAggregates: []gofalcon.Aggregation{
+				{Name: "b", Type: gofalcon.AggregationTerms},
+			}},
+		},
+	}
+
+	for title, aggregations := range invalid {
+		_, err := client.Detection.Aggregates(&gofalcon.AggregatesInput{Aggregations: aggregations})
+		assert.Error(t, err, title)
+	}
+}
@@ -0,0 +1,391 @@
+// Package cassette records HTTP traffic of gofalcon.Client to a file and replays it in tests. Recorder is http.RoundTripper and injected by gofalcon.WithTransport, then typed APIs and event stream work in both modes.
+//
+//	rec, err := cassette.New("testdata/detects.json", cassette.ModeReplay)
+//	client := gofalcon.NewClient(gofalcon.WithTransport(rec))
+//
+// Authorization header, tokens, secrets and CIDs are scrubbed before saving the cassette.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Mode of Recorder
+type Mode int
+
+const (
+	// ModeReplay returns recorded responses and never sends request to server.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to server and records them. Call Save to write the cassette.
+	ModeRecord
+)
+
+// Redacted replaces scrubbed values.
+const Redacted = "REDACTED"
+
+// ErrInteractionNotFound is returned in ModeReplay if no recorded interaction matches the request.
+var ErrInteractionNotFound = errors.New("No interaction matches the request")
+
+// DefaultScrubKeys are keys of JSON fields, form values and query parameters scrubbed by default.
+var DefaultScrubKeys = []string{
+	"access_token",
+	"client_id",
+	"client_secret",
+	"token",
+	"cid",
+	"customerIDString",
+	"member_cid",
+}
+
+// Option configures Recorder
+type Option func(x *Recorder)
+
+// WithTransport sets transport to send requests in ModeRecord. Default is http.DefaultTransport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(x *Recorder) {
+		x.transport = transport
+	}
+}
+
+// WithScrubKeys adds keys of JSON fields, form values and query parameters to be scrubbed.
+func WithScrubKeys(keys ...string) Option {
+	return func(x *Recorder) {
+		for _, key := range keys {
+			x.scrubKeys[key] = true
+		}
+	}
+}
+
+// WithScrubValues adds literal values (e.g. your CID or hostname) to be replaced by Redacted anywhere in URL and body.
+func WithScrubValues(values ...string) Option {
+	return func(x *Recorder) {
+		x.scrubValues = append(x.scrubValues, values...)
+	}
+}
+
+// Interaction is a pair of recorded request and response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is scrubbed request. It's matched with request in ModeReplay.
+type RecordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is scrubbed response.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+type cassetteFile struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Recorder is http.RoundTripper that records or replays interactions.
+type Recorder struct {
+	path        string
+	mode        Mode
+	transport   http.RoundTripper
+	scrubKeys   map[string]bool
+	scrubValues []string
+
+	mutex        sync.Mutex
+	interactions []*Interaction
+	used         map[*Interaction]bool
+	bodies       map[*Interaction]*bytes.Buffer // response bodies being recorded
+}
+
+// New creates Recorder. In ModeReplay, the cassette of path is loaded.
+func New(path string, mode Mode, options ...Option) (*Recorder, error) {
+	x := &Recorder{
+		path:      path,
+		mode:      mode,
+		transport: http.DefaultTransport,
+		scrubKeys: make(map[string]bool),
+		used:      make(map[*Interaction]bool),
+		bodies:    make(map[*Interaction]*bytes.Buffer),
+	}
+	for _, key := range DefaultScrubKeys {
+		x.scrubKeys[key] = true
+	}
+	for _, opt := range options {
+		opt(x)
+	}
+
+	if mode == ModeReplay {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Fail to read cassette: %s", path)
+		}
+		var file cassetteFile
+		if err := json.Unmarshal(raw, &file); err != nil {
+			return nil, errors.Wrapf(err, "Fail to parse cassette: %s", path)
+		}
+		x.interactions = file.Interactions
+	}
+
+	return x, nil
+}
+
+// Interactions returns recorded or loaded interactions.
+func (x *Recorder) Interactions() []Interaction {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	var interactions []Interaction
+	for _, i := range x.interactions {
+		interactions = append(interactions, x.snapshot(i))
+	}
+	return interactions
+}
+
+// snapshot returns copy of i including response body recorded so far. It must be called with lock.
+func (x *Recorder) snapshot(i *Interaction) Interaction {
+	copied := *i
+	if buf, ok := x.bodies[i]; ok {
+		copied.Response.Body = x.scrubBody(buf.String(), i.Response.Header.Get("Content-Type"))
+	}
+	return copied
+}
+
+// Save writes recorded interactions to the cassette. Response body being read (e.g. event stream) is saved as far as it has been read.
+func (x *Recorder) Save() error {
+	if x.mode != ModeRecord {
+		return nil
+	}
+
+	file := cassetteFile{}
+	for _, i := range x.Interactions() {
+		copied := i
+		file.Interactions = append(file.Interactions, &copied)
+	}
+
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Fail to marshal cassette")
+	}
+	if dir := filepath.Dir(x.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "Fail to create directory of cassette: %s", dir)
+		}
+	}
+	if err := ioutil.WriteFile(x.path, raw, 0644); err != nil {
+		return errors.Wrapf(err, "Fail to write cassette: %s", x.path)
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (x *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := x.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if x.mode == ModeReplay {
+		return x.replay(req, recorded)
+	}
+	return x.record(req, recorded)
+}
+
+func (x *Recorder) recordRequest(req *http.Request) (RecordedRequest, error) {
+	recorded := RecordedRequest{
+		Method: req.Method,
+		Path:   x.scrubString(req.URL.Path),
+		Query:  x.scrubQuery(req.URL.Query()),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		raw, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return recorded, errors.Wrap(err, "Fail to read request body")
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(raw))
+		recorded.Body = x.scrubBody(string(raw), req.Header.Get("Content-Type"))
+	}
+
+	return recorded, nil
+}
+
+func (x *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	// Unused interaction is preferred. If all matched interactions have been used, the last one is returned again (e.g. token refresh).
+	var matched *Interaction
+	for _, i := range x.interactions {
+		if i.Request != recorded {
+			continue
+		}
+		matched = i
+		if !x.used[i] {
+			break
+		}
+	}
+	if matched == nil {
+		return nil, errors.Wrapf(ErrInteractionNotFound, "%s %s?%s", recorded.Method, recorded.Path, recorded.Query)
+	}
+	x.used[matched] = true
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", matched.Response.StatusCode, http.StatusText(matched.Response.StatusCode)),
+		StatusCode:    matched.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        matched.Response.Header.Clone(),
+		Body:          ioutil.NopCloser(strings.NewReader(matched.Response.Body)),
+		ContentLength: int64(len(matched.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (x *Recorder) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	resp, err := x.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := resp.Header.Clone()
+	for _, name := range []string{"Authorization", "Set-Cookie"} {
+		header.Del(name)
+	}
+
+	i := &Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     header,
+		},
+	}
+	buf := &bytes.Buffer{}
+
+	x.mutex.Lock()
+	x.interactions = append(x.interactions, i)
+	x.bodies[i] = buf
+	x.mutex.Unlock()
+
+	// Body is recorded while being read to support event stream that never ends
+	resp.Body = &recordingBody{ReadCloser: resp.Body, buf: buf, mutex: &x.mutex}
+	return resp, nil
+}
+
+type recordingBody struct {
+	io.ReadCloser
+	buf   *bytes.Buffer
+	mutex *sync.Mutex
+}
+
+func (x *recordingBody) Read(p []byte) (int, error) {
+	n, err := x.ReadCloser.Read(p)
+	if n > 0 {
+		x.mutex.Lock()
+		x.buf.Write(p[:n])
+		x.mutex.Unlock()
+	}
+	return n, err
+}
+
+func (x *Recorder) scrubString(s string) string {
+	for _, v := range x.scrubValues {
+		if v != "" {
+			s = strings.Replace(s, v, Redacted, -1)
+		}
+	}
+	return s
+}
+
+// scrubQuery scrubs values of query and encodes it with sorted keys.
+func (x *Recorder) scrubQuery(qs url.Values) string {
+	if len(qs) == 0 {
+		return ""
+	}
+	scrubbed := url.Values{}
+	for key, values := range qs {
+		for _, v := range values {
+			if x.scrubKeys[key] {
+				v = Redacted
+			}
+			scrubbed.Add(key, x.scrubString(v))
+		}
+	}
+	return scrubbed.Encode() // Encode sorts by key
+}
+
+func (x *Recorder) scrubBody(body, contentType string) string {
+	if body == "" {
+		return body
+	}
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if qs, err := url.ParseQuery(body); err == nil {
+			return x.scrubQuery(qs)
+		}
+	}
+
+	// JSON body, or JSON lines of event stream
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		// UseNumber keeps large numbers (e.g. timestamp in milliseconds) as they are
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			lines = append(lines, x.scrubString(line))
+			continue
+		}
+		raw, err := json.Marshal(x.scrubJSON(v))
+		if err != nil {
+			lines = append(lines, x.scrubString(line))
+			continue
+		}
+		lines = append(lines, string(raw))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (x *Recorder) scrubJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key := range t {
+			if _, isString := t[key].(string); isString && x.scrubKeys[key] {
+				t[key] = Redacted
+			} else {
+				t[key] = x.scrubJSON(t[key])
+			}
+		}
+		return t
+
+	case []interface{}:
+		for idx := range t {
+			t[idx] = x.scrubJSON(t[idx])
+		}
+		return t
+
+	case string:
+		return x.scrubString(t)
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,137 @@
+package cassette_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/cassette"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type scenarioResult struct {
+	detects []string
+	events  []int
+}
+
+// runScenario calls typed APIs and event stream with client.
+func runScenario(t *testing.T, client *gofalcon.Client) scenarioResult {
+	var result scenarioResult
+
+	query, err := client.Detection.QueriesDetects(&gofalcon.QueriesDetectsInput{Limit: gofalcon.Int(2)})
+	require.NoError(t, err)
+	summaries, err := client.Detection.EntitySummaries(&gofalcon.EntitySummariesInput{ID: query.Resources})
+	require.NoError(t, err)
+	for _, d := range summaries.Resources {
+		result.detects = append(result.detects, d.DetectionID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		AppID: gofalcon.String("cassette"), // AppID must be fixed to match the requests
+	})
+	for q := range ch {
+		require.NoError(t, q.Error)
+		_, ok := q.Payload.(*gofalcon.DetectionSummaryEvent)
+		assert.True(t, ok)
+		result.events = append(result.events, q.Meta.Offset)
+		if len(result.events) == 3 {
+			break
+		}
+	}
+
+	return result
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "testdata", "scenario.json")
+
+	server := falcontest.NewServer()
+	server.SeedDetections(2)
+	server.SeedEvents(0, 3)
+
+	// Record
+	rec, err := cassette.New(path, cassette.ModeRecord)
+	require.NoError(t, err)
+	client := gofalcon.NewClient(gofalcon.WithTransport(rec))
+	client.Endpoint = server.URL
+	require.NoError(t, client.EnableOAuth2(server.ClientID(), server.Secret()))
+
+	recorded := runScenario(t, client)
+	require.NoError(t, rec.Save())
+	server.Close()
+
+	assert.Equal(t, 2, len(recorded.detects))
+	assert.Equal(t, []int{0, 1, 2}, recorded.events)
+
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), recorded.detects[0])
+	assert.Contains(t, string(raw), cassette.Redacted)
+	for _, secret := range []string{server.ClientID(), server.Secret(), falcontest.DefaultCID, "token-", "session-"} {
+		assert.NotContains(t, string(raw), secret)
+	}
+
+	// Replay without server and with other credentials
+	player, err := cassette.New(path, cassette.ModeReplay)
+	require.NoError(t, err)
+	replayClient := gofalcon.NewClient(gofalcon.WithTransport(player))
+	replayClient.Endpoint = server.URL
+	require.NoError(t, replayClient.EnableOAuth2("other-id", "other-secret"))
+
+	replayed := runScenario(t, replayClient)
+	assert.Equal(t, recorded, replayed)
+
+	t.Run("unknown request", func(t *testing.T) {
+		_, err := replayClient.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, cassette.ErrInteractionNotFound))
+	})
+
+	t.Run("body is matched", func(t *testing.T) {
+		_, err := replayClient.Detection.EntitySummaries(&gofalcon.EntitySummariesInput{ID: []string{"ldt:unknown"}})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, cassette.ErrInteractionNotFound))
+	})
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	_, err := cassette.New(filepath.Join("testdata", "not-found.json"), cassette.ModeReplay)
+	assert.Error(t, err)
+}
+
+func TestScrubValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "devices.json")
+
+	server := falcontest.NewServer()
+	defer server.Close()
+	server.AddDevices(gofalcon.DeviceResource{DeviceID: "aid1", Hostname: "secret-host.example.com"})
+
+	rec, err := cassette.New(path, cassette.ModeRecord, cassette.WithScrubValues("secret-host.example.com"))
+	require.NoError(t, err)
+	client, err := server.NewClient(gofalcon.WithTransport(rec), gofalcon.WithTimeout(time.Second))
+	require.NoError(t, err)
+
+	output, err := client.Device.EntityDevices(&gofalcon.EntityDevicesInput{ID: []string{"aid1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "secret-host.example.com", output.Resources[0].Hostname)
+	require.NoError(t, rec.Save())
+
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "secret-host.example.com")
+}
@@ -0,0 +1,248 @@
+package gofalcon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CheckpointStore saves offset of event stream per AppID and partition. EventStream loads the offset at start and resumes from the next event.
+type CheckpointStore interface {
+	// Load returns last committed offset. found is false if no offset is committed yet.
+	Load(ctx context.Context, appID string, partition int) (offset int, found bool, err error)
+	// Save commits offset of the event that has been processed.
+	Save(ctx context.Context, appID string, partition int, offset int) error
+}
+
+func checkpointKey(appID string, partition int) string {
+	return fmt.Sprintf("%s/%d", appID, partition)
+}
+
+// MemoryCheckpointStore is CheckpointStore in memory. It's useful to resume the stream in same process and for test.
+type MemoryCheckpointStore struct {
+	mutex   sync.Mutex
+	offsets map[string]int
+}
+
+// NewMemoryCheckpointStore is constructor of MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{offsets: make(map[string]int)}
+}
+
+// Load returns offset in memory.
+func (x *MemoryCheckpointStore) Load(ctx context.Context, appID string, partition int) (int, bool, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	offset, ok := x.offsets[checkpointKey(appID, partition)]
+	return offset, ok, nil
+}
+
+// Save stores offset in memory.
+func (x *MemoryCheckpointStore) Save(ctx context.Context, appID string, partition int, offset int) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.offsets[checkpointKey(appID, partition)] = offset
+	return nil
+}
+
+// DefaultCheckpointFlushInterval is default value of FileCheckpointStore.FlushInterval
+const DefaultCheckpointFlushInterval = time.Second
+
+// FileCheckpointStore is CheckpointStore with a JSON file. Offsets are kept in memory and written to the file FlushInterval after Save, then offsets saved in the interval are written together. The file is replaced atomically by rename and synced to disk. Put it on persistent volume to resume the stream after restart, and call Close before exit to write offsets saved last.
+type FileCheckpointStore struct {
+	// FlushInterval is delay to write saved offsets to the file. Save writes the file immediately if it's 0.
+	FlushInterval time.Duration
+
+	path     string
+	mutex    sync.Mutex
+	offsets  map[string]int
+	dirty    bool
+	timer    *time.Timer
+	flushErr error
+}
+
+// NewFileCheckpointStore is constructor of FileCheckpointStore. The file is created at first flush.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{
+		FlushInterval: DefaultCheckpointFlushInterval,
+		path:          path,
+	}
+}
+
+// load reads the file into memory at first call. Caller must hold x.mutex.
+func (x *FileCheckpointStore) load() error {
+	if x.offsets != nil {
+		return nil
+	}
+
+	offsets := make(map[string]int)
+	raw, err := ioutil.ReadFile(x.path)
+	if os.IsNotExist(err) {
+		x.offsets = offsets
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Fail to read checkpoint file: %s", x.path)
+	}
+
+	if err := json.Unmarshal(raw, &offsets); err != nil {
+		return errors.Wrapf(err, "Fail to parse checkpoint file: %s", x.path)
+	}
+	x.offsets = offsets
+	return nil
+}
+
+// write writes offsets in memory to the file if they are changed. Caller must hold x.mutex.
+func (x *FileCheckpointStore) write() error {
+	if !x.dirty {
+		return nil
+	}
+
+	raw, err := json.Marshal(x.offsets)
+	if err != nil {
+		return errors.Wrap(err, "Fail to marshal checkpoint")
+	}
+	if err := writeFileAtomic(x.path, raw); err != nil {
+		return err
+	}
+	x.dirty = false
+	return nil
+}
+
+// Load returns offset in the file, or offset saved but not written yet.
+func (x *FileCheckpointStore) Load(ctx context.Context, appID string, partition int) (int, bool, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if err := x.load(); err != nil {
+		return 0, false, err
+	}
+	offset, ok := x.offsets[checkpointKey(appID, partition)]
+	return offset, ok, nil
+}
+
+// Save stores offset in memory and schedules writing the file after FlushInterval. It returns error of the last scheduled write if it failed, and the offset is written again by next flush.
+func (x *FileCheckpointStore) Save(ctx context.Context, appID string, partition int, offset int) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if err := x.load(); err != nil {
+		return err
+	}
+	x.offsets[checkpointKey(appID, partition)] = offset
+	x.dirty = true
+
+	if x.FlushInterval <= 0 {
+		return x.write()
+	}
+
+	if x.timer == nil {
+		x.timer = time.AfterFunc(x.FlushInterval, x.scheduledFlush)
+	}
+	err := x.flushErr
+	x.flushErr = nil
+	return err
+}
+
+func (x *FileCheckpointStore) scheduledFlush() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.timer = nil
+	x.flushErr = x.write()
+}
+
+// Flush writes saved offsets to the file immediately.
+func (x *FileCheckpointStore) Flush() error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.timer != nil {
+		x.timer.Stop()
+		x.timer = nil
+	}
+	x.flushErr = nil
+	return x.write()
+}
+
+// Close writes saved offsets to the file. FileCheckpointStore can be used after Close, but Close should be called again to write offsets saved later.
+func (x *FileCheckpointStore) Close() error {
+	return x.Flush()
+}
+
+// writeFileAtomic writes raw to temp file in same directory and renames it to path. Then readers never see partially written file. The file and the directory are synced to keep the content after crash of OS.
+func writeFileAtomic(path string, raw []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "Fail to create temp file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "Fail to write temp file: %s", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "Fail to sync temp file: %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "Fail to close temp file: %s", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrapf(err, "Fail to replace file: %s", path)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes directory entry of renamed file. Windows does not support fsync of directory and it's skipped.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to open directory: %s", dir)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return errors.Wrapf(err, "Fail to sync directory: %s", dir)
+	}
+	return nil
+}
+
+// checkpointer commits offsets of a partition to CheckpointStore. Offset is committed only if it's newer than committed one.
+type checkpointer struct {
+	store     CheckpointStore
+	appID     string
+	partition int
+
+	mutex     sync.Mutex
+	committed int
+}
+
+func (x *checkpointer) commit(ctx context.Context, offset int) error {
+	if x == nil || x.store == nil {
+		return nil
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if offset <= x.committed {
+		return nil
+	}
+
+	if err := x.store.Save(ctx, x.appID, x.partition, offset); err != nil {
+		return err
+	}
+	x.committed = offset
+	return nil
+}
@@ -0,0 +1,87 @@
+package gofalcon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCheckpointStore(t *testing.T, store gofalcon.CheckpointStore) {
+	ctx := context.Background()
+
+	_, found, err := store.Load(ctx, "app1", 0)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, store.Save(ctx, "app1", 0, 120))
+	require.NoError(t, store.Save(ctx, "app1", 1, 30))
+	require.NoError(t, store.Save(ctx, "app2", 0, 5))
+
+	offset, found, err := store.Load(ctx, "app1", 0)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 120, offset)
+
+	offset, found, err = store.Load(ctx, "app1", 1)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 30, offset)
+}
+
+func TestMemoryCheckpointStore(t *testing.T) {
+	testCheckpointStore(t, gofalcon.NewMemoryCheckpointStore())
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofalcon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint.json")
+	store := gofalcon.NewFileCheckpointStore(path)
+	testCheckpointStore(t, store)
+	require.NoError(t, store.Close())
+
+	// Reopen
+	offset, found, err := gofalcon.NewFileCheckpointStore(path).Load(context.Background(), "app2", 0)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 5, offset)
+
+	t.Run("Saved offsets are written together after FlushInterval", func(t *testing.T) {
+		path := filepath.Join(dir, "flush.json")
+		store := gofalcon.NewFileCheckpointStore(path)
+		store.FlushInterval = time.Millisecond * 100
+		ctx := context.Background()
+
+		for i := 1; i <= 10; i++ {
+			require.NoError(t, store.Save(ctx, "app1", 0, i))
+		}
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+
+		require.True(t, assert.Eventually(t, func() bool {
+			offset, found, err := gofalcon.NewFileCheckpointStore(path).Load(ctx, "app1", 0)
+			return err == nil && found && offset == 10
+		}, time.Second*5, time.Millisecond*10))
+	})
+
+	t.Run("Save writes file immediately without FlushInterval", func(t *testing.T) {
+		path := filepath.Join(dir, "immediate.json")
+		store := gofalcon.NewFileCheckpointStore(path)
+		store.FlushInterval = 0
+		require.NoError(t, store.Save(context.Background(), "app1", 0, 3))
+
+		offset, found, err := gofalcon.NewFileCheckpointStore(path).Load(context.Background(), "app1", 0)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, 3, offset)
+	})
+}
@@ -1,19 +1,26 @@
 package gofalcon
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/m-mizutani/gofalcon/fql"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Logger is exported to be controllable from external.
+// Logger is exported to be controllable from external. It's used by Client created without WithLogger.
+//
+// Deprecated: Logger is shared by all Client. Use WithLogger to set logger of each Client.
 var Logger = logrus.New()
 
 func init() {
@@ -22,60 +29,156 @@ func init() {
 
 // Client is Falcon API client
 type Client struct {
-	User        string
-	Token       string
-	Endpoint    string
+	User     string
+	Token    string
+	Endpoint string
+	ClientID string
+	Secret   string
+	// OAuth2Token and OAuth2Type are used as static token if TokenSource is not set.
+	//
+	// Deprecated: They are set by SetOAuth2Token and EnableOAuth2 for compatibility, but not updated when TokenSource refreshes token. Client accesses them with lock of TokenSource, but direct access from other goroutines is not synchronized. Use SetTokenSource or TokenSource instead.
 	OAuth2Token string
 	OAuth2Type  string
-	ClientID    string
-	Secret      string
+	// MemberCID is used to get OAuth2 token of child tenant in EnableOAuth2.
+	MemberCID string
 
 	Device    *DeviceAPI
 	OAuth2    *OAuth2API
 	Detection *DetectionAPI
 	Sensor    *SensorAPI
+	MSSP      *MSSPAPI
+
+	// Options
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    time.Duration
+	userAgent  string
+	proxy      func(*http.Request) (*url.URL, error)
+	tlsConfig  *tls.Config
+	middleware middlewareChain
+	log        logger
+	metrics    Metrics
+	tracer     Tracer
+
+	retryPolicy RetryPolicy
+	cloud       Cloud
+	configErr   error
+	tokenSource TokenSource
+	tokenMutex  sync.RWMutex
+
+	apiHTTPClient    *http.Client
+	streamHTTPClient *http.Client
+
+	rateLimit      RateLimit
+	rateLimitMutex sync.Mutex
+
+	discoveredCloud Cloud
+	endpointMutex   sync.RWMutex
 }
 
 // NewClient is constructor of Client
-func NewClient() *Client {
+func NewClient(options ...Option) *Client {
 	client := Client{
-		Endpoint: "https://api.crowdstrike.com",
+		Endpoint:    cloudEndpoints[CloudUS1],
+		retryPolicy: DefaultRetryPolicy,
+		log:         defaultLogger(),
+		metrics:     NopMetrics{},
+		tracer:      nopTracer{},
 	}
+	for _, opt := range options {
+		opt(&client)
+	}
+	client.setupHTTPClient()
+
 	client.Device = &DeviceAPI{client: &client}
 	client.OAuth2 = &OAuth2API{client: &client}
 	client.Detection = &DetectionAPI{client: &client}
 	client.Sensor = &SensorAPI{client: &client}
+	client.MSSP = &MSSPAPI{client: &client}
 
 	return &client
 }
 
+// Err returns error of invalid option given to NewClient, such as unsupported cloud of WithCloud. API requests of the client fail with the error if it's not nil.
+func (x *Client) Err() error {
+	return x.configErr
+}
+
 // EnableOAuth2 retrieves OAuth2 token and set it to the client
 func (x *Client) EnableOAuth2(clientID, secret string) error {
+	return x.EnableOAuth2WithContext(context.Background(), clientID, secret)
+}
+
+// EnableOAuth2WithContext is same with EnableOAuth2, but the token request can be cancelled by ctx.
+func (x *Client) EnableOAuth2WithContext(ctx context.Context, clientID, secret string) error {
 	x.ClientID = clientID
 	x.Secret = secret
 
-	return x.refreshOAuth2Token()
-}
-
-func (x *Client) refreshOAuth2Token() error {
-	resp, err := x.OAuth2.Token(&TokenInput{
-		ClientID:     &x.ClientID,
-		ClientSecret: &x.Secret,
-	})
+	ts := NewOAuth2TokenSource(x.OAuth2, clientID, secret)
+	ts.MemberCID = x.MemberCID
+	token, err := ts.Token(ctx)
 	if err != nil {
-		return errors.Wrap(err, "Fail to OAuth2 authentication")
+		return err
 	}
 
-	x.SetOAuth2Token(resp.AccessToken, resp.TokenType)
+	x.setTokenSource(ts, token.Token, token.TokenType)
 	return nil
 }
 
 // SetOAuth2Token sets OAuth2Token already generated
 func (x *Client) SetOAuth2Token(token, tokenType string) {
+	x.setTokenSource(StaticTokenSource(token, tokenType), token, tokenType)
+}
+
+// SetTokenSource replaces TokenSource of the client. It's safe to call while other goroutines send requests.
+func (x *Client) SetTokenSource(ts TokenSource) {
+	x.tokenMutex.Lock()
+	defer x.tokenMutex.Unlock()
+	x.tokenSource = ts
+}
+
+// setTokenSource replaces TokenSource and deprecated OAuth2Token and OAuth2Type together.
+func (x *Client) setTokenSource(ts TokenSource, token, tokenType string) {
+	x.tokenMutex.Lock()
+	defer x.tokenMutex.Unlock()
+	x.tokenSource = ts
 	x.OAuth2Token = token
 	x.OAuth2Type = tokenType
 }
 
+// TokenSource returns current TokenSource of the client. It returns nil if no TokenSource is set.
+func (x *Client) TokenSource() TokenSource {
+	x.tokenMutex.RLock()
+	defer x.tokenMutex.RUnlock()
+	return x.tokenSource
+}
+
+// requestTokenSource returns TokenSource to authorize API request. OAuth2Token and OAuth2Type assigned directly are used if no TokenSource is set.
+func (x *Client) requestTokenSource() TokenSource {
+	x.tokenMutex.RLock()
+	defer x.tokenMutex.RUnlock()
+	if x.tokenSource != nil {
+		return x.tokenSource
+	}
+	if x.OAuth2Token != "" && x.OAuth2Type != "" {
+		return StaticTokenSource(x.OAuth2Token, x.OAuth2Type)
+	}
+	return nil
+}
+
+// invalidateToken notifies TokenSource that token was rejected. It returns false if the TokenSource can not provide another token.
+func (x *Client) invalidateToken(token *AccessToken) bool {
+	if token == nil {
+		return false
+	}
+	invalidator, ok := x.TokenSource().(TokenInvalidator)
+	if !ok {
+		return false
+	}
+	invalidator.Invalidate(token)
+	return true
+}
+
 // SetUserToken sets user and token for authorization
 func (x *Client) SetUserToken(user, token string) {
 	x.User = user
@@ -94,6 +197,13 @@ type Request struct {
 	QueryString url.Values
 	Body        io.Reader
 	Headers     []httpHeader
+	// Idempotent allows retry on HTTP 5xx for a request other than GET and HEAD. Set it to POST request that only reads data, e.g. "entities/summaries/GET".
+	Idempotent bool
+
+	// noAuth disables authorization header. It's used to retrieve token itself.
+	noAuth bool
+	// onResponse is called with HTTP response to refer headers.
+	onResponse func(resp *http.Response)
 }
 
 // Response is generic falcon API response
@@ -111,6 +221,42 @@ type Pagenation struct {
 	Limit  int `json:"limit"`
 	Offset int `json:"offset"`
 	Total  int `json:"total"`
+	// After is continuation token of after-token based pagination. It's set from "after" field, or "offset" field if the offset is string (e.g. devices/queries/devices-scroll/v1).
+	After     string `json:"after"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// UnmarshalJSON accepts both of numeric offset and string offset (continuation token).
+func (x *Pagenation) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Limit     int             `json:"limit"`
+		Offset    json.RawMessage `json:"offset"`
+		Total     int             `json:"total"`
+		After     string          `json:"after"`
+		ExpiresAt int64           `json:"expires_at"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*x = Pagenation{
+		Limit:     raw.Limit,
+		Total:     raw.Total,
+		After:     raw.After,
+		ExpiresAt: raw.ExpiresAt,
+	}
+
+	if len(raw.Offset) > 0 && raw.Offset[0] == '"' {
+		if err := json.Unmarshal(raw.Offset, &x.After); err != nil {
+			return err
+		}
+	} else if len(raw.Offset) > 0 && string(raw.Offset) != "null" {
+		if err := json.Unmarshal(raw.Offset, &x.Offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type MetaData struct {
@@ -128,56 +274,125 @@ type ServerError struct {
 
 // SendRequest sends any request to API endpoint and set results to v. This function retry the request if OAuth2 token is expired.
 func (x *Client) SendRequest(req Request, resp interface{}) error {
-	if err := x.sendHTTPRequest(req, resp); err != nil {
-		if _, ok := err.(*authError); !ok {
-			return err // General error
+	return x.SendRequestWithContext(context.Background(), req, resp)
+}
+
+// SendRequestWithContext is same with SendRequest, but the request is bound to ctx. Cancellation or deadline of ctx aborts the HTTP request.
+//
+// The request is also retried according to RetryPolicy of the client if API responds HTTP 429, or 5xx and transient transport error (timeout, unexpected EOF and connection reset) for GET, HEAD and Idempotent request. If X-RateLimit-Remaining was 0 in the last response, the request waits until X-RateLimit-RetryAfter.
+func (x *Client) SendRequestWithContext(ctx context.Context, req Request, resp interface{}) error {
+	ctx, span := x.tracer.Start(ctx, SpanNameRequest, SpanAttributes{
+		SpanKeyMethod: req.Method,
+		SpanKeyPath:   requestPath(req.Path),
+	})
+	err := x.sendRequest(ctx, req, resp, span)
+	span.End(err)
+	return err
+}
+
+func (x *Client) sendRequest(ctx context.Context, req Request, resp interface{}, span Span) error {
+	if x.configErr != nil {
+		return x.configErr
+	}
+
+	// Body is buffered to be rewound for each attempt
+	var body []byte
+	if req.Body != nil {
+		raw, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return errors.Wrap(err, "Fail to read request body")
 		}
+		body = raw
+	}
 
-		if err := x.refreshOAuth2Token(); err != nil {
-			return err // Can not refresh token
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		if err := sleepWithContext(ctx, x.rateLimitWait()); err != nil {
+			return err
 		}
 
-		// Retry
-		return x.sendHTTPRequest(req, resp)
-	}
+		if body != nil {
+			req.Body = bytes.NewReader(body)
+		}
 
-	return nil
-}
+		span.SetAttributes(SpanAttributes{SpanKeyAttempts: attempt})
+		token, err := x.sendHTTPRequest(ctx, req, resp, span)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err // Cancelled by caller, not by transient failure
+		}
 
-type authError struct {
-	err error
+		switch {
+		case IsAuthError(err):
+			if refreshed || !x.invalidateToken(token) {
+				return err // Can not refresh token
+			}
+			refreshed = true
+			attempt-- // Token refresh does not consume retry attempts
+
+		case req.retryable(err):
+			if attempt >= x.retryPolicy.MaxAttempts {
+				return err
+			}
+
+			wait := x.retryPolicy.backoff(attempt)
+			if rlWait := x.rateLimitWait(); rlWait > wait {
+				wait = rlWait
+			}
+			x.log.Warn("Retry API request", LogFields{
+				LogKeyPath:    req.Path,
+				LogKeyAttempt: attempt,
+				LogKeyWait:    wait,
+				LogKeyError:   err,
+			})
+
+			if err := sleepWithContext(ctx, wait); err != nil {
+				return err
+			}
+
+		default:
+			return err // General error
+		}
+	}
 }
 
-func (x *authError) Error() string {
-	return x.err.Error()
+// requestPath returns path of Request with leading slash.
+func requestPath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
 }
 
-func (x *Client) sendHTTPRequest(req Request, resp interface{}) error {
-	client := &http.Client{}
-	endpoint := x.Endpoint
+// sendHTTPRequest sends req and returns AccessToken used for the request with error. Status code and meta data of the response are set to span.
+func (x *Client) sendHTTPRequest(ctx context.Context, req Request, resp interface{}, span Span) (*AccessToken, error) {
+	endpoint := x.endpoint()
 	if strings.HasSuffix(endpoint, "/") {
 		endpoint = endpoint[:len(endpoint)-1]
 	}
-	path := req.Path
-	if !strings.HasPrefix(path, "/") {
-		path = "/" + path
-	}
+	path := requestPath(req.Path)
 
 	url := endpoint + path
 	if len(req.QueryString) > 0 {
 		url = url + "?" + req.QueryString.Encode()
 	}
 
-	httpReq, err := http.NewRequest(req.Method, url, req.Body)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, url, req.Body)
 	if err != nil {
-		return errors.Wrap(err, "fail to create a graylog http request")
+		return nil, errors.Wrap(err, "fail to create a graylog http request")
 	}
 
-	switch {
-	case x.OAuth2Token != "" && x.OAuth2Type != "":
-		auth := x.OAuth2Type + " " + x.OAuth2Token
-		httpReq.Header.Add("authorization", auth)
-	case x.User != "" && x.Token != "":
+	var token *AccessToken
+	if ts := x.requestTokenSource(); ts != nil && !req.noAuth {
+		token, err = ts.Token(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Fail to get access token")
+		}
+		httpReq.Header.Add("authorization", token.TokenType+" "+token.Token)
+	} else if x.User != "" && x.Token != "" && !req.noAuth {
 		httpReq.SetBasicAuth(x.User, x.Token)
 	}
 
@@ -187,45 +402,85 @@ func (x *Client) sendHTTPRequest(req Request, resp interface{}) error {
 	}
 
 	httpReq.Header.Add("accept", "application/json")
+	if x.userAgent != "" {
+		httpReq.Header.Set("user-agent", x.userAgent)
+	}
 	for _, hdr := range req.Headers {
 		httpReq.Header.Set(hdr.Name, hdr.Value)
 	}
+	injectTraceContext(x.tracer, ctx, httpReq)
 
-	httpResp, err := client.Do(httpReq)
+	httpReq, err = x.middleware.before(httpReq)
 	if err != nil {
-		return errors.Wrap(err, "fail to send request to server")
+		return token, errors.Wrap(err, "Fail to apply middleware to request")
+	}
+
+	start := time.Now()
+	httpResp, err := x.apiHTTPClient.Do(httpReq)
+	if err != nil {
+		err = errors.Wrap(err, "fail to send request to server")
+		x.metrics.ObserveRequest(httpReq.Method, path, 0, time.Since(start))
+		x.middleware.onError(httpReq, err, time.Since(start))
+		return token, err
 	}
 
 	defer httpResp.Body.Close()
 	rawData, err := ioutil.ReadAll(httpResp.Body)
+	elapsed := time.Since(start)
+	x.metrics.ObserveRequest(httpReq.Method, path, httpResp.StatusCode, elapsed)
 	if err != nil {
-		return errors.Wrap(err, "Fail to read httpResponse from server")
+		err = errors.Wrap(err, "Fail to read httpResponse from server")
+		x.middleware.onError(httpReq, err, elapsed)
+		return token, err
+	}
+	x.updateRateLimit(httpResp.Header)
+	if req.onResponse != nil {
+		req.onResponse(httpResp)
 	}
 
-	// Error handling
-	if httpResp.StatusCode == 403 {
-		return &authError{err: fmt.Errorf("Authentication Error (HTTP 403): %s", string(rawData))}
-	} else if httpResp.StatusCode >= 400 {
-		return fmt.Errorf("Fail HTTP request %d: %s", httpResp.StatusCode, string(rawData))
+	// Buffered body is given to middleware
+	httpResp.Body = ioutil.NopCloser(bytes.NewReader(rawData))
+	x.middleware.after(httpReq, httpResp, elapsed)
+
+	meta, err := parseResponse(httpReq, httpResp.StatusCode, path, rawData, resp)
+	span.SetAttributes(responseAttributes(httpResp.StatusCode, meta))
+	if err != nil {
+		x.middleware.onError(httpReq, err, elapsed)
+		return token, err
 	}
 
+	return token, nil
+}
+
+// parseResponse converts error response to *APIError, or unmarshals rawData to resp. It returns meta data of the response if rawData is JSON.
+func parseResponse(httpReq *http.Request, statusCode int, path string, rawData []byte, resp interface{}) (*MetaData, error) {
 	var base BaseResponse
-	if err := json.Unmarshal(rawData, &base); err != nil {
-		return errors.Wrapf(err, "Fail to parse base reponse of Falcon: %v", string(rawData))
+	parseErr := json.Unmarshal(rawData, &base)
+	var meta *MetaData
+	if parseErr == nil {
+		meta = &base.Meta
 	}
-	if len(base.Errors) > 0 {
-		var messages []string
-		for _, e := range base.Errors {
-			messages = append(messages, fmt.Sprintf("%d: %s", e.Code, e.Message))
+
+	if statusCode >= 400 || (parseErr == nil && len(base.Errors) > 0) {
+		// Error response may not be JSON, then Errors and TraceID are empty
+		return meta, &APIError{
+			StatusCode: statusCode,
+			Method:     httpReq.Method,
+			Path:       path,
+			Errors:     base.Errors,
+			TraceID:    base.Meta.TraceID,
+			Body:       rawData,
 		}
-		return fmt.Errorf("Fail to request: %s", strings.Join(messages, ", "))
+	}
+	if parseErr != nil {
+		return nil, errors.Wrapf(parseErr, "Fail to parse base reponse of Falcon: %v", string(rawData))
 	}
 
 	if err := json.Unmarshal(rawData, resp); err != nil {
-		return errors.Wrapf(err, "Fail to parse reponse of Falcon: %v", string(rawData))
+		return meta, errors.Wrapf(err, "Fail to parse reponse of Falcon: %v", string(rawData))
 	}
 
-	return nil
+	return meta, nil
 }
 
 // Int converts int to pointer
@@ -249,3 +504,30 @@ func StringValue(v *string) string {
 	}
 	return *v
 }
+
+// joinFilters joins FQL filters by AND. Empty filters are ignored.
+func joinFilters(filters ...string) string {
+	var parts []string
+	for _, filter := range filters {
+		if filter != "" {
+			parts = append(parts, filter)
+		}
+	}
+
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, "+")
+}
+
+func exprString(expr fql.Expr) string {
+	if expr == nil {
+		return ""
+	}
+	return expr.String()
+}
+
+// Bool converts bool to pointer
+func Bool(v bool) *bool { return &v }
@@ -1,11 +1,18 @@
 package gofalcon_test
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,6 +38,18 @@ func init() {
 		verbose:  (os.Getenv("FALCON_TEST_VERBOSE") != ""),
 	}
 
+	// Tests run with mock server if credentials of Falcon API are not given
+	if cfg.clientID == "" {
+		server := falcontest.NewServer()
+		server.SeedDetections(10)
+		server.SeedDevices(10)
+		server.SeedEvents(0, 10)
+
+		gofalcon.SetCloudEndpoint(gofalcon.CloudUS1, server.URL)
+		cfg.clientID = server.ClientID()
+		cfg.secret = server.Secret()
+	}
+
 	commonClient = gofalcon.NewClient()
 	err := commonClient.EnableOAuth2(cfg.clientID, cfg.secret)
 	if err != nil {
@@ -77,3 +96,231 @@ func TestBasicRequest(t *testing.T) {
 		assert.Greater(t, len(resp.Resources), 0)
 	})
 }
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientOptions(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(`{"resources":["xxx"]}`))
+	}))
+	defer server.Close()
+
+	t.Run("User-Agent is set", func(t *testing.T) {
+		client := gofalcon.NewClient(gofalcon.WithUserAgent("my-agent/1.0"))
+		client.Endpoint = server.URL
+
+		var resp gofalcon.Response
+		require.NoError(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+		assert.Equal(t, "my-agent/1.0", userAgent)
+		assert.Equal(t, 1, len(resp.Resources))
+	})
+
+	t.Run("Injected transport is used", func(t *testing.T) {
+		called := 0
+		transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			called++
+			return http.DefaultTransport.RoundTrip(req)
+		})
+		client := gofalcon.NewClient(gofalcon.WithTransport(transport))
+		client.Endpoint = server.URL
+
+		var resp gofalcon.Response
+		require.NoError(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+		assert.Equal(t, 1, called)
+	})
+
+	t.Run("Timeout aborts API request", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond * 200)
+			w.Write([]byte(`{}`))
+		}))
+		defer slow.Close()
+
+		client := gofalcon.NewClient(
+			gofalcon.WithTimeout(time.Millisecond*10),
+			gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond}),
+		)
+		client.Endpoint = slow.URL
+
+		var resp gofalcon.Response
+		assert.Error(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+	})
+}
+
+func TestClientRetry(t *testing.T) {
+	t.Run("Retry throttled request with same body", func(t *testing.T) {
+		var bodies []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(raw))
+
+			w.Header().Set("X-RateLimit-Limit", "6000")
+			w.Header().Set("X-RateLimit-RetryAfter", fmt.Sprintf("%d", time.Now().Unix()))
+			if len(bodies) < 3 {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("X-RateLimit-Remaining", "5999")
+			w.Write([]byte(`{"resources":[]}`))
+		}))
+		defer server.Close()
+
+		client := gofalcon.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{
+			MaxAttempts: 3,
+			MinBackoff:  time.Millisecond,
+			MaxBackoff:  time.Millisecond * 10,
+		}))
+		client.Endpoint = server.URL
+
+		var resp gofalcon.Response
+		require.NoError(t, client.SendRequest(gofalcon.Request{
+			Method: "POST",
+			Path:   "detects/entities/summaries/GET/v1",
+			Body:   strings.NewReader(`{"ids":["a"]}`),
+		}, &resp))
+
+		require.Equal(t, 3, len(bodies))
+		for _, body := range bodies {
+			assert.Equal(t, `{"ids":["a"]}`, body)
+		}
+
+		rl := client.RateLimit()
+		assert.Equal(t, 6000, rl.Limit)
+		assert.Equal(t, 5999, rl.Remaining)
+	})
+
+	t.Run("Give up after MaxAttempts", func(t *testing.T) {
+		called := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := gofalcon.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{
+			MaxAttempts: 2,
+			MinBackoff:  time.Millisecond,
+		}))
+		client.Endpoint = server.URL
+
+		var resp gofalcon.Response
+		assert.Error(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+		assert.Equal(t, 2, called)
+	})
+
+	t.Run("Do not retry server error of non-idempotent request", func(t *testing.T) {
+		called := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		client := gofalcon.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{
+			MaxAttempts: 3,
+			MinBackoff:  time.Millisecond,
+		}))
+		client.Endpoint = server.URL
+
+		var resp gofalcon.Response
+		assert.Error(t, client.SendRequest(gofalcon.Request{
+			Method: "PATCH",
+			Path:   "detects/entities/detects/v2",
+			Body:   strings.NewReader(`{"ids":["a"]}`),
+		}, &resp))
+		assert.Equal(t, 1, called)
+
+		called = 0
+		assert.Error(t, client.SendRequest(gofalcon.Request{
+			Method:     "POST",
+			Path:       "detects/entities/summaries/GET/v1",
+			Body:       strings.NewReader(`{"ids":["a"]}`),
+			Idempotent: true,
+		}, &resp))
+		assert.Equal(t, 3, called)
+	})
+}
+
+func TestClientRetryTransportError(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	client, err := server.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+	}))
+	require.NoError(t, err)
+
+	countRequests := func(method string) int {
+		n := 0
+		for _, req := range server.Requests() {
+			if req.Method == method && req.Path == "/detects/entities/detects/v2" {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("idempotent request is retried", func(t *testing.T) {
+		server.DropConnections("/devices/", 2)
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+	})
+
+	t.Run("non-idempotent request is not retried", func(t *testing.T) {
+		server.DropConnections("/detects/entities/detects/v2", 1)
+		var resp gofalcon.Response
+		err := client.SendRequest(gofalcon.Request{
+			Method: "PATCH",
+			Path:   "detects/entities/detects/v2",
+			Body:   strings.NewReader(`{"ids":["a"],"show_in_ui":false}`),
+		}, &resp)
+		require.Error(t, err)
+		assert.Equal(t, 1, countRequests("PATCH"))
+	})
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Run("Grow without MaxBackoff", func(t *testing.T) {
+		policy := gofalcon.RetryPolicy{MinBackoff: time.Millisecond}
+		assert.GreaterOrEqual(t, int64(policy.Backoff(5)), int64(time.Millisecond*8))
+		assert.LessOrEqual(t, int64(policy.Backoff(5)), int64(time.Millisecond*16))
+	})
+
+	t.Run("Capped by MaxBackoff", func(t *testing.T) {
+		policy := gofalcon.RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 4}
+		assert.LessOrEqual(t, int64(policy.Backoff(10)), int64(time.Millisecond*4))
+	})
+}
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"meta":{"trace_id":"trace-xxx"},"errors":[{"code":404,"message":"not found"}]}`))
+	}))
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	_, err := client.Device.EntityDevices(&gofalcon.EntityDevicesInput{ID: []string{"xxx"}})
+	require.Error(t, err)
+	assert.True(t, gofalcon.IsNotFound(err))
+	assert.False(t, gofalcon.IsRateLimited(err))
+	assert.False(t, gofalcon.IsAuthError(err))
+
+	apiErr := gofalcon.AsAPIError(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "trace-xxx", apiErr.TraceID)
+	assert.Equal(t, "/devices/entities/devices/v1", apiErr.Path)
+	require.Equal(t, 1, len(apiErr.Errors))
+	assert.Equal(t, "not found", apiErr.Errors[0].Message)
+	assert.False(t, apiErr.Retryable())
+}
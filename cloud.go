@@ -0,0 +1,112 @@
+package gofalcon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cloud is a region of Falcon platform.
+type Cloud string
+
+const (
+	// CloudUS1 is US-1 (api.crowdstrike.com). It's default.
+	CloudUS1 Cloud = "us-1"
+	// CloudUS2 is US-2 (api.us-2.crowdstrike.com)
+	CloudUS2 Cloud = "us-2"
+	// CloudEU1 is EU-1 (api.eu-1.crowdstrike.com)
+	CloudEU1 Cloud = "eu-1"
+	// CloudUSGov1 is US-GOV-1 (api.laggar.gcw.crowdstrike.com)
+	CloudUSGov1 Cloud = "us-gov-1"
+	// CloudAutoDiscover starts with US-1 and switches to the region that is indicated by X-Cs-Region header of OAuth2 token response.
+	CloudAutoDiscover Cloud = "autodiscover"
+)
+
+var cloudEndpoints = map[Cloud]string{
+	CloudUS1:    "https://api.crowdstrike.com",
+	CloudUS2:    "https://api.us-2.crowdstrike.com",
+	CloudEU1:    "https://api.eu-1.crowdstrike.com",
+	CloudUSGov1: "https://api.laggar.gcw.crowdstrike.com",
+}
+
+// ParseCloud converts region name such as "us-1", "EU-1" and "autodiscover" to Cloud.
+func ParseCloud(s string) (Cloud, error) {
+	cloud := Cloud(strings.ToLower(strings.TrimSpace(s)))
+	if cloud == CloudAutoDiscover {
+		return cloud, nil
+	}
+	if _, ok := cloudEndpoints[cloud]; !ok {
+		return "", fmt.Errorf("Unsupported Falcon cloud: %s", s)
+	}
+	return cloud, nil
+}
+
+// Endpoint returns base URL of API in the region. CloudAutoDiscover returns US-1 endpoint as starting point.
+func (x Cloud) Endpoint() (string, error) {
+	if x == CloudAutoDiscover {
+		return cloudEndpoints[CloudUS1], nil
+	}
+	endpoint, ok := cloudEndpoints[x]
+	if !ok {
+		return "", fmt.Errorf("Unsupported Falcon cloud: %s", x)
+	}
+	return endpoint, nil
+}
+
+// WithCloud sets region of Falcon platform. If cloud is unsupported, Client.Err returns the error and all API requests of the client fail with it. Use ParseCloud to validate region name before creating client.
+func WithCloud(cloud Cloud) Option {
+	return func(client *Client) {
+		endpoint, err := cloud.Endpoint()
+		if err != nil {
+			client.configErr = err
+			return
+		}
+		client.cloud = cloud
+		client.Endpoint = endpoint
+	}
+}
+
+// Cloud returns region of the client. If CloudAutoDiscover is set and the region is not discovered yet, it returns CloudAutoDiscover.
+func (x *Client) Cloud() Cloud {
+	x.endpointMutex.RLock()
+	defer x.endpointMutex.RUnlock()
+	if x.discoveredCloud != "" {
+		return x.discoveredCloud
+	}
+	if x.cloud == "" {
+		return CloudUS1
+	}
+	return x.cloud
+}
+
+func (x *Client) endpoint() string {
+	x.endpointMutex.RLock()
+	defer x.endpointMutex.RUnlock()
+	return x.Endpoint
+}
+
+// discoverCloud switches endpoint to the region indicated by X-Cs-Region header if CloudAutoDiscover is enabled.
+func (x *Client) discoverCloud(region string) {
+	if x.cloud != CloudAutoDiscover || region == "" {
+		return
+	}
+
+	cloud, err := ParseCloud(region)
+	if err != nil || cloud == CloudAutoDiscover {
+		x.log.Warn("Unknown region is indicated by Falcon API", LogFields{"region": region})
+		return
+	}
+	endpoint, _ := cloud.Endpoint()
+
+	x.endpointMutex.Lock()
+	defer x.endpointMutex.Unlock()
+	if x.discoveredCloud == cloud {
+		return
+	}
+	x.discoveredCloud = cloud
+	x.Endpoint = endpoint
+
+	x.log.Info("Discovered Falcon cloud", LogFields{
+		"cloud":    cloud,
+		"endpoint": endpoint,
+	})
+}
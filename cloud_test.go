@@ -0,0 +1,77 @@
+package gofalcon_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCloud(t *testing.T) {
+	cloud, err := gofalcon.ParseCloud("EU-1")
+	require.NoError(t, err)
+	assert.Equal(t, gofalcon.CloudEU1, cloud)
+
+	endpoint, err := cloud.Endpoint()
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.eu-1.crowdstrike.com", endpoint)
+
+	_, err = gofalcon.ParseCloud("xx-9")
+	assert.Error(t, err)
+}
+
+func TestCloudAutoDiscover(t *testing.T) {
+	eu1Called := 0
+	eu1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eu1Called++
+		w.Write([]byte(`{"resources":["xxx"]}`))
+	}))
+	defer eu1.Close()
+
+	us1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Cs-Region", "eu-1")
+		w.Write([]byte(`{"access_token":"xxx","token_type":"bearer","expires_in":1799}`))
+	}))
+	defer us1.Close()
+
+	defer gofalcon.SetCloudEndpoint(gofalcon.CloudUS1, us1.URL)()
+	defer gofalcon.SetCloudEndpoint(gofalcon.CloudEU1, eu1.URL)()
+
+	client := gofalcon.NewClient(gofalcon.WithCloud(gofalcon.CloudAutoDiscover))
+	assert.Equal(t, gofalcon.CloudAutoDiscover, client.Cloud())
+	require.NoError(t, client.EnableOAuth2("id", "secret"))
+	assert.Equal(t, gofalcon.CloudEU1, client.Cloud())
+
+	_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, eu1Called)
+}
+
+func TestUnsupportedCloud(t *testing.T) {
+	called := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Write([]byte(`{"resources":["xxx"]}`))
+	}))
+	defer server.Close()
+
+	defer gofalcon.SetCloudEndpoint(gofalcon.CloudUS1, server.URL)()
+
+	client := gofalcon.NewClient(gofalcon.WithCloud("xx-9"))
+	assert.Error(t, client.Err())
+
+	_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+	assert.True(t, errors.Is(err, client.Err()))
+	assert.Error(t, client.EnableOAuth2("id", "secret"))
+	assert.Equal(t, 0, called)
+
+	assert.NoError(t, gofalcon.NewClient(gofalcon.WithCloud(gofalcon.CloudEU1)).Err())
+}
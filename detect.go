@@ -2,13 +2,14 @@ package gofalcon
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"time"
 
+	"github.com/m-mizutani/gofalcon/fql"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 type DetectionAPI struct {
@@ -21,6 +22,9 @@ type QueriesDetectsInput struct {
 	Sort   *string
 	Filter *string
 	Q      *string
+
+	// FilterExpr is FQL filter built by fql package. It's joined with Filter by AND if both are set.
+	FilterExpr fql.Expr
 }
 
 type QueriesDetectsOutput struct {
@@ -30,6 +34,11 @@ type QueriesDetectsOutput struct {
 
 // QueriesDetects retrieves IDs of detection
 func (x *DetectionAPI) QueriesDetects(input *QueriesDetectsInput) (*QueriesDetectsOutput, error) {
+	return x.QueriesDetectsWithContext(context.Background(), input)
+}
+
+// QueriesDetectsWithContext is same with QueriesDetects, but the request is bound to ctx.
+func (x *DetectionAPI) QueriesDetectsWithContext(ctx context.Context, input *QueriesDetectsInput) (*QueriesDetectsOutput, error) {
 	qs := url.Values{}
 	if input.Offset != nil {
 		qs.Add("offset", fmt.Sprintf("%d", *input.Offset))
@@ -40,8 +49,8 @@ func (x *DetectionAPI) QueriesDetects(input *QueriesDetectsInput) (*QueriesDetec
 	if input.Sort != nil {
 		qs.Add("sort", *input.Sort)
 	}
-	if input.Filter != nil {
-		qs.Add("filter", *input.Filter)
+	if filter := joinFilters(StringValue(input.Filter), exprString(input.FilterExpr)); filter != "" {
+		qs.Add("filter", filter)
 	}
 	if input.Q != nil {
 		qs.Add("q", *input.Q)
@@ -54,19 +63,37 @@ func (x *DetectionAPI) QueriesDetects(input *QueriesDetectsInput) (*QueriesDetec
 	}
 
 	var output QueriesDetectsOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to query detections")
 	}
 
-	Logger.WithFields(logrus.Fields{
-		"qs":       qs.Encode(),
-		"meta":     output.Meta,
-		"returned": len(output.Resources),
-	}).Debug("Done QueriesDetects")
+	x.client.log.Debug("Done QueriesDetects", LogFields{
+		"qs":          qs.Encode(),
+		LogKeyTraceID: output.Meta.TraceID,
+		LogKeyPath:    req.Path,
+		"returned":    len(output.Resources),
+	})
 
 	return &output, nil
 }
 
+// QueriesDetectsPaginator returns Paginator to retrieve all detection IDs matched with input. Offset and Limit of input are ignored, use Paginator.Limit instead.
+func (x *DetectionAPI) QueriesDetectsPaginator(input *QueriesDetectsInput) *Paginator {
+	return NewPaginator(OffsetPagination, func(ctx context.Context, page Page) ([]string, MetaData, error) {
+		pageInput := *input
+		pageInput.Offset = Int(page.Offset)
+		if page.Limit > 0 {
+			pageInput.Limit = Int(page.Limit)
+		}
+
+		output, err := x.QueriesDetectsWithContext(ctx, &pageInput)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+		return output.Resources, output.Meta, nil
+	})
+}
+
 type EntitySummariesInput struct {
 	ID []string `json:"ids"`
 }
@@ -133,6 +160,7 @@ type DetectionResources struct {
 	Behaviors        []DetectionBehavior `json:"behaviors"`
 	Cid              string              `json:"cid"`
 	CreatedTimestamp time.Time           `json:"created_timestamp"`
+	DateUpdated      time.Time           `json:"date_updated"`
 	DetectionID      string              `json:"detection_id"`
 	Device           DeviceResource      `json:"device"`
 	EmailSent        bool                `json:"email_sent"`
@@ -159,28 +187,311 @@ type DetectionResources struct {
 
 // EntitySummaries retrieves summaries of detection
 func (x *DetectionAPI) EntitySummaries(input *EntitySummariesInput) (*EntitySummariesOutput, error) {
+	return x.EntitySummariesWithContext(context.Background(), input)
+}
+
+// EntitySummariesWithContext is same with EntitySummaries, but the request is bound to ctx.
+func (x *DetectionAPI) EntitySummariesWithContext(ctx context.Context, input *EntitySummariesInput) (*EntitySummariesOutput, error) {
 	raw, err := json.Marshal(input)
 	if err != nil {
 		return nil, errors.Wrap(err, "Fail to marshal EntitySummaries input")
 	}
 
 	req := Request{
-		Method:  "POST",
-		Path:    "detects/entities/summaries/GET/v1",
-		Body:    bytes.NewReader(raw),
-		Headers: []httpHeader{{"Content-Type", "application/json"}},
+		Method:     "POST",
+		Path:       "detects/entities/summaries/GET/v1",
+		Body:       bytes.NewReader(raw),
+		Headers:    []httpHeader{{"Content-Type", "application/json"}},
+		Idempotent: true,
 	}
 
 	var output EntitySummariesOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to query detections")
 	}
 
-	Logger.WithFields(logrus.Fields{
-		"input":    input,
-		"meta":     output.Meta,
-		"returned": len(output.Resources),
-	}).Debug("Done EntitySummaries")
+	x.client.log.Debug("Done EntitySummaries", LogFields{
+		"input":       input,
+		LogKeyTraceID: output.Meta.TraceID,
+		LogKeyPath:    req.Path,
+		"returned":    len(output.Resources),
+	})
+
+	return &output, nil
+}
+
+// DetectionSummaryQueue is issued from FetchSummaries. If error is occurred, Detection must be nil.
+type DetectionSummaryQueue struct {
+	Error     error
+	Detection *DetectionResources
+}
+
+// FetchSummaries retrieves summaries of all detections walked by p (e.g. QueriesDetectsPaginator). IDs are split into batches (EntitySummariesMaxIDs by default) and fetched concurrently. The channel is closed after all summaries are issued or an error is issued.
+func (x *DetectionAPI) FetchSummaries(ctx context.Context, p *Paginator, options *HydrateOptions) chan *DetectionSummaryQueue {
+	ch := make(chan *DetectionSummaryQueue, EntitySummariesMaxIDs)
+	opt := HydrateOptions{BatchSize: EntitySummariesMaxIDs}
+	if options != nil {
+		opt.Workers = options.Workers
+		if options.BatchSize > 0 && options.BatchSize < EntitySummariesMaxIDs {
+			opt.BatchSize = options.BatchSize
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		err := Hydrate(ctx, p, &opt, func(ctx context.Context, ids []string) error {
+			output, err := x.EntitySummariesWithContext(ctx, &EntitySummariesInput{ID: ids})
+			if err != nil {
+				return err
+			}
+			for i := range output.Resources {
+				select {
+				case ch <- &DetectionSummaryQueue{Detection: &output.Resources[i]}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+
+		if err != nil && ctx.Err() == nil {
+			select {
+			case ch <- &DetectionSummaryQueue{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch
+}
+
+// DetectStatus is status of detection set by UpdateDetects.
+type DetectStatus string
+
+// Statuses of detection
+const (
+	DetectStatusNew           DetectStatus = "new"
+	DetectStatusInProgress    DetectStatus = "in_progress"
+	DetectStatusTruePositive  DetectStatus = "true_positive"
+	DetectStatusFalsePositive DetectStatus = "false_positive"
+	DetectStatusIgnored       DetectStatus = "ignored"
+	DetectStatusClosed        DetectStatus = "closed"
+	DetectStatusReopened      DetectStatus = "reopened"
+)
+
+// Valid returns true if x is known status.
+func (x DetectStatus) Valid() bool {
+	switch x {
+	case DetectStatusNew, DetectStatusInProgress, DetectStatusTruePositive, DetectStatusFalsePositive,
+		DetectStatusIgnored, DetectStatusClosed, DetectStatusReopened:
+		return true
+	}
+	return false
+}
+
+// UpdateDetectsMaxIDs is maximum number of IDs in a request of DetectionAPI.UpdateDetects
+const UpdateDetectsMaxIDs = 1000
+
+// UpdateDetectsInput is arguments of UpdateDetects. At least one of Status, AssignedToUUID, Comment and ShowInUI is required.
+type UpdateDetectsInput struct {
+	IDs []string
+
+	// Status is not changed if empty
+	Status DetectStatus
+	// AssignedToUUID is UUID of user to be assigned
+	AssignedToUUID *string
+	Comment        *string
+	// ShowInUI false hides detections in Falcon console
+	ShowInUI *bool
+
+	// BatchSize is number of IDs in a request. IDs are split and sent sequentially. Default and maximum is UpdateDetectsMaxIDs.
+	BatchSize int
+}
+
+type updateDetectsRequest struct {
+	IDs            []string `json:"ids"`
+	Status         string   `json:"status,omitempty"`
+	AssignedToUUID *string  `json:"assigned_to_uuid,omitempty"`
+	Comment        *string  `json:"comment,omitempty"`
+	ShowInUI       *bool    `json:"show_in_ui,omitempty"`
+}
+
+// UpdateDetectsOutput is result of UpdateDetects. Each ID of input is in either of Updated or Failed.
+type UpdateDetectsOutput struct {
+	Updated []string
+	Failed  []*UpdateDetectError
+	// Meta is meta data of responses of each batch
+	Meta []MetaData
+}
+
+// UpdateDetectError is error of a detection in UpdateDetects.
+type UpdateDetectError struct {
+	ID  string
+	Err error
+}
+
+func (x *UpdateDetectError) Error() string {
+	return fmt.Sprintf("Fail to update detection %s: %v", x.ID, x.Err)
+}
+
+// Cause returns original error for errors.Cause
+func (x *UpdateDetectError) Cause() error { return x.Err }
+
+// Unwrap returns original error for errors.Is and errors.As
+func (x *UpdateDetectError) Unwrap() error { return x.Err }
+
+// UpdateDetects updates status, assignee, comment and visibility of detections (PATCH detects/entities/detects/v2).
+func (x *DetectionAPI) UpdateDetects(input *UpdateDetectsInput) (*UpdateDetectsOutput, error) {
+	return x.UpdateDetectsWithContext(context.Background(), input)
+}
+
+// UpdateDetectsWithContext is same with UpdateDetects, but the request is bound to ctx.
+//
+// IDs are split into batches. If a batch fails, IDs of the batch are reported in Failed of output and remaining batches are still sent. A batch failed by HTTP 5xx is not retried because the update (and Comment) may have been applied by the server. Error is returned only for invalid input or cancellation of ctx, and then output has results of batches sent before.
+func (x *DetectionAPI) UpdateDetectsWithContext(ctx context.Context, input *UpdateDetectsInput) (*UpdateDetectsOutput, error) {
+	if len(input.IDs) == 0 {
+		return nil, errors.New("IDs is required for UpdateDetects")
+	}
+	if input.Status == "" && input.AssignedToUUID == nil && input.Comment == nil && input.ShowInUI == nil {
+		return nil, errors.New("Nothing to update, set Status, AssignedToUUID, Comment or ShowInUI")
+	}
+	if input.Status != "" && !input.Status.Valid() {
+		return nil, errors.Errorf("Invalid detection status: %s", input.Status)
+	}
+
+	batchSize := input.BatchSize
+	if batchSize <= 0 || batchSize > UpdateDetectsMaxIDs {
+		batchSize = UpdateDetectsMaxIDs
+	}
+
+	output := &UpdateDetectsOutput{}
+	for start := 0; start < len(input.IDs); start += batchSize {
+		end := start + batchSize
+		if end > len(input.IDs) {
+			end = len(input.IDs)
+		}
+		ids := input.IDs[start:end]
+
+		meta, err := x.updateDetects(ctx, input, ids)
+		if err != nil && ctx.Err() != nil {
+			return output, ctx.Err()
+		}
+		if meta != nil {
+			output.Meta = append(output.Meta, *meta)
+		}
+		output.merge(ids, err)
+	}
+
+	x.client.log.Debug("Done UpdateDetects", LogFields{
+		"status":    input.Status,
+		LogKeyPath:  "detects/entities/detects/v2",
+		"requested": len(input.IDs),
+		"updated":   len(output.Updated),
+		"failed":    len(output.Failed),
+	})
+
+	return output, nil
+}
+
+func (x *DetectionAPI) updateDetects(ctx context.Context, input *UpdateDetectsInput, ids []string) (*MetaData, error) {
+	raw, err := json.Marshal(updateDetectsRequest{
+		IDs:            ids,
+		Status:         string(input.Status),
+		AssignedToUUID: input.AssignedToUUID,
+		Comment:        input.Comment,
+		ShowInUI:       input.ShowInUI,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to marshal UpdateDetects input")
+	}
+
+	// Not Idempotent: retry after 5xx may post Comment twice
+	req := Request{
+		Method:  "PATCH",
+		Path:    "detects/entities/detects/v2",
+		Body:    bytes.NewReader(raw),
+		Headers: []httpHeader{{"Content-Type", "application/json"}},
+	}
+
+	var output BaseResponse
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
+		return nil, errors.Wrap(err, "Fail to update detections")
+	}
+	return &output.Meta, nil
+}
+
+// merge sorts ids of a batch into Updated and Failed by err. Errors having ID in response are reported to the ID only, and other errors are reported to all IDs of the batch.
+func (x *UpdateDetectsOutput) merge(ids []string, err error) {
+	if err == nil {
+		x.Updated = append(x.Updated, ids...)
+		return
+	}
+
+	apiErr := AsAPIError(err)
+	if apiErr == nil || len(apiErr.Errors) == 0 {
+		for _, id := range ids {
+			x.Failed = append(x.Failed, &UpdateDetectError{ID: id, Err: err})
+		}
+		return
+	}
+
+	inBatch := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		inBatch[id] = true
+	}
+	perID := map[string]error{}
+	for _, e := range apiErr.Errors {
+		if e.ID == "" || !inBatch[e.ID] {
+			// Error not bound to an ID fails the whole batch
+			perID = nil
+			break
+		}
+		perID[e.ID] = errors.Errorf("%d: %s", e.Code, e.Message)
+	}
+
+	for _, id := range ids {
+		switch {
+		case perID == nil:
+			x.Failed = append(x.Failed, &UpdateDetectError{ID: id, Err: err})
+		case perID[id] != nil:
+			x.Failed = append(x.Failed, &UpdateDetectError{ID: id, Err: perID[id]})
+		default:
+			x.Updated = append(x.Updated, id)
+		}
+	}
+}
+
+// Aggregates counts detections by aggregations (detects/aggregates/detects/GET/v1) without retrieving their summaries.
+func (x *DetectionAPI) Aggregates(input *AggregatesInput) (*AggregatesOutput, error) {
+	return x.AggregatesWithContext(context.Background(), input)
+}
+
+// AggregatesWithContext is same with Aggregates, but the request is bound to ctx.
+func (x *DetectionAPI) AggregatesWithContext(ctx context.Context, input *AggregatesInput) (*AggregatesOutput, error) {
+	raw, err := marshalAggregations(input.Aggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	req := Request{
+		Method:     "POST",
+		Path:       "detects/aggregates/detects/GET/v1",
+		Body:       bytes.NewReader(raw),
+		Headers:    []httpHeader{{"Content-Type", "application/json"}},
+		Idempotent: true,
+	}
+
+	var output AggregatesOutput
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
+		return nil, errors.Wrap(err, "Fail to aggregate detections")
+	}
+
+	x.client.log.Debug("Done Aggregates", LogFields{
+		"aggregations": aggregationNames(input.Aggregations),
+		LogKeyTraceID:  output.Meta.TraceID,
+		LogKeyPath:     req.Path,
+		"returned":     len(output.Resources),
+	})
 
 	return &output, nil
 }
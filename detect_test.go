@@ -1,10 +1,16 @@
 package gofalcon_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/k0kubun/pp"
 	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/m-mizutani/gofalcon/fql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -29,3 +35,153 @@ func TestDetectionAPI(t *testing.T) {
 		pp.Println(detail)
 	}
 }
+
+func TestQueriesDetectsFilterExpr(t *testing.T) {
+	var filter string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("filter")
+		w.Write([]byte(`{"resources":[]}`))
+	}))
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	_, err := client.Detection.QueriesDetects(&gofalcon.QueriesDetectsInput{
+		Filter:     gofalcon.String("status:'new',status:'reopened'"),
+		FilterExpr: fql.And(fql.Ge("max_severity", 50), fql.Gt("last_behavior", fql.Now("-1d"))),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "(status:'new',status:'reopened')+(max_severity:>=50+last_behavior:>'now-1d')", filter)
+}
+
+func TestUpdateDetects(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	ids := server.SeedDetections(5)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	t.Run("update in batches", func(t *testing.T) {
+		output, err := client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+			IDs:            ids,
+			Status:         gofalcon.DetectStatusInProgress,
+			AssignedToUUID: gofalcon.String("user-uuid"),
+			Comment:        gofalcon.String("investigating"),
+			BatchSize:      2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, ids, output.Updated)
+		assert.Equal(t, 0, len(output.Failed))
+		assert.Equal(t, 3, len(output.Meta))
+
+		patches := 0
+		for _, req := range server.Requests() {
+			if req.Method == "PATCH" && req.Path == "/detects/entities/detects/v2" {
+				patches++
+			}
+		}
+		assert.Equal(t, 3, patches)
+
+		detail, err := client.Detection.EntitySummaries(&gofalcon.EntitySummariesInput{ID: ids})
+		require.NoError(t, err)
+		require.Equal(t, 5, len(detail.Resources))
+		for _, d := range detail.Resources {
+			assert.Equal(t, "in_progress", d.Status)
+			assert.Equal(t, "user-uuid", d.AssignedToUID)
+		}
+	})
+
+	t.Run("errors are reported by ID", func(t *testing.T) {
+		output, err := client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+			IDs:      []string{ids[0], "ldt:unknown", ids[1]},
+			ShowInUI: gofalcon.Bool(false),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{ids[0], ids[1]}, output.Updated)
+		require.Equal(t, 1, len(output.Failed))
+		assert.Equal(t, "ldt:unknown", output.Failed[0].ID)
+		assert.Contains(t, output.Failed[0].Error(), "detection not found")
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+			Status: gofalcon.DetectStatusClosed,
+		})
+		assert.Error(t, err)
+
+		_, err = client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{IDs: ids})
+		assert.Error(t, err)
+
+		_, err = client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+			IDs:    ids,
+			Status: gofalcon.DetectStatus("resolved"),
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.Detection.UpdateDetectsWithContext(ctx, &gofalcon.UpdateDetectsInput{
+			IDs:    ids,
+			Status: gofalcon.DetectStatusClosed,
+		})
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestUpdateDetectsBatchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"meta":{"trace_id":"x"},"errors":[{"code":400,"message":"bad request"}]}`))
+	}))
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	output, err := client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+		IDs:    []string{"a", "b"},
+		Status: gofalcon.DetectStatusFalsePositive,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(output.Updated))
+	require.Equal(t, 2, len(output.Failed))
+	for _, f := range output.Failed {
+		apiErr := gofalcon.AsAPIError(f)
+		require.NotNil(t, apiErr)
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	}
+}
+
+func TestUpdateDetectsServerErrorIsNotRetried(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	ids := server.SeedDetections(2)
+
+	client, err := server.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+	}))
+	require.NoError(t, err)
+
+	server.Inject(falcontest.Fault{Method: "PATCH", Path: "/detects/entities/detects/v2", Status: http.StatusBadGateway, Times: 1})
+	output, err := client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+		IDs:     ids,
+		Comment: gofalcon.String("investigating"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(output.Updated))
+	require.Equal(t, 2, len(output.Failed))
+	assert.Equal(t, http.StatusBadGateway, gofalcon.AsAPIError(output.Failed[0]).StatusCode)
+
+	patches := 0
+	for _, req := range server.Requests() {
+		if req.Method == "PATCH" {
+			patches++
+		}
+	}
+	assert.Equal(t, 1, patches)
+}
@@ -0,0 +1,450 @@
+package gofalcon
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m-mizutani/gofalcon/fql"
+	"github.com/pkg/errors"
+)
+
+// DetectionChangeType is kind of change found by DetectionWatcher.
+type DetectionChangeType string
+
+// Types of detection change
+const (
+	DetectionChangeNew        DetectionChangeType = "new_detection"
+	DetectionChangeStatus     DetectionChangeType = "status_changed"
+	DetectionChangeBehaviors  DetectionChangeType = "new_behaviors"
+	DetectionChangeAssignment DetectionChangeType = "assignment_changed"
+)
+
+// Watermark fields of DetectionWatcher
+const (
+	WatermarkDateUpdated  = "date_updated"
+	WatermarkLastBehavior = "last_behavior"
+)
+
+// DetectionChange is a change of detection issued by DetectionWatcher.
+type DetectionChange struct {
+	Type      DetectionChangeType
+	Detection *DetectionResources
+	// Previous is state of the detection seen last time. It's nil for DetectionChangeNew.
+	Previous *DetectionSnapshot
+	// NewBehaviors is behaviors added since last time. It's set for DetectionChangeBehaviors.
+	NewBehaviors []DetectionBehavior
+}
+
+// DetectionSnapshot is state of detection to find changes.
+type DetectionSnapshot struct {
+	Status        string    `json:"status"`
+	AssignedToUID string    `json:"assigned_to_uid"`
+	BehaviorIDs   []string  `json:"behavior_ids"`
+	Updated       time.Time `json:"updated"`
+}
+
+// DetectionWatchState is state of DetectionWatcher saved to DetectionWatchStore after each poll.
+type DetectionWatchState struct {
+	// Watermark is the newest value of watermark field in seen detections
+	Watermark  time.Time                     `json:"watermark"`
+	Detections map[string]*DetectionSnapshot `json:"detections"`
+}
+
+// DetectionWatchStore saves state of DetectionWatcher by name.
+type DetectionWatchStore interface {
+	// Load returns saved state. It returns nil if no state is saved yet.
+	Load(ctx context.Context, name string) (*DetectionWatchState, error)
+	Save(ctx context.Context, name string, state *DetectionWatchState) error
+}
+
+// DetectionChangeHandler processes a change of detection. Returning error stops DetectionWatcher unless OnError of the watcher ignores it.
+type DetectionChangeHandler func(ctx context.Context, change *DetectionChange) error
+
+// DetectionWatcher polls detections by QueriesDetects and EntitySummaries, and issues changes compared with detections seen in previous polls. It's alternative of event stream for API client without the scope.
+//
+// Detections updated after the watermark are retrieved in each poll. Then the watermark and state of seen detections are saved to Store only after all changes of the poll are handled, so changes are delivered at least once even if the process is restarted. If more detections than MaxOffset are updated after the watermark, a poll handles the oldest ones and the next poll continues from them.
+type DetectionWatcher struct {
+	// Name is key of state in Store. Default is "default".
+	Name string
+	// Store saves watermark and seen detections. NewDetectionWatcher sets MemoryDetectionWatchStore, use FileDetectionWatchStore to keep them across restart. It must not be nil.
+	Store DetectionWatchStore
+	// Interval is period of polling. Default is DefaultDetectionWatchInterval.
+	Interval time.Duration
+	// WatermarkField is WatermarkDateUpdated (default) or WatermarkLastBehavior. last_behavior does not catch status and assignment changes that are not followed by new behavior.
+	WatermarkField string
+	// Overlap is subtracted from watermark in the query to catch detections indexed late. Default is DefaultDetectionWatchOverlap.
+	Overlap time.Duration
+	// Since is watermark of the first poll if Store has no state. Zero means all detections are issued as new.
+	Since time.Time
+	// Retention is period to keep seen detections after their last update. A detection updated after that is issued as new again. Default is DefaultDetectionWatchRetention.
+	Retention time.Duration
+
+	// Filter and FilterExpr narrow detections to watch. They are joined with watermark condition by AND.
+	Filter     string
+	FilterExpr fql.Expr
+
+	// OnError is called with error of polling and handler. If OnError returns nil, the error is ignored: a failed poll is retried at next interval, and a failed change is regarded as handled. If OnError returns error, Run stops and returns it. If OnError is nil, Run stops with the first error.
+	OnError func(err error) error
+
+	detection *DetectionAPI
+	// maxOffset is Paginator.MaxOffset of QueriesDetects. It's replaced by test.
+	maxOffset int
+}
+
+// Default values of DetectionWatcher
+const (
+	DefaultDetectionWatchInterval  = time.Minute
+	DefaultDetectionWatchOverlap   = time.Minute
+	DefaultDetectionWatchRetention = time.Hour * 24 * 30
+	defaultDetectionWatchName      = "default"
+)
+
+// NewDetectionWatcher is constructor of DetectionWatcher.
+func NewDetectionWatcher(client *Client) *DetectionWatcher {
+	return &DetectionWatcher{
+		Name:           defaultDetectionWatchName,
+		Store:          NewMemoryDetectionWatchStore(),
+		Interval:       DefaultDetectionWatchInterval,
+		WatermarkField: WatermarkDateUpdated,
+		Overlap:        DefaultDetectionWatchOverlap,
+		Retention:      DefaultDetectionWatchRetention,
+		detection:      client.Detection,
+	}
+}
+
+// Run polls detections every Interval and calls handler for each change until ctx is cancelled or OnError returns error. The first poll starts immediately. It returns nil if the watcher is stopped by ctx.
+func (x *DetectionWatcher) Run(ctx context.Context, handler DetectionChangeHandler) error {
+	interval := x.Interval
+	if interval <= 0 {
+		interval = DefaultDetectionWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := x.Poll(ctx, handler); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll retrieves detections once and calls handler for each change. It's useful to run the watcher from a scheduler such as cron. Errors are passed to OnError as same as Run.
+func (x *DetectionWatcher) Poll(ctx context.Context, handler DetectionChangeHandler) error {
+	field := x.watermarkField()
+	if field != WatermarkDateUpdated && field != WatermarkLastBehavior {
+		return errors.Errorf("Invalid WatermarkField of DetectionWatcher: %s", field)
+	}
+	if x.Store == nil {
+		return errors.New("Store of DetectionWatcher is required")
+	}
+
+	state, err := x.Store.Load(ctx, x.name())
+	if err != nil {
+		return x.handleError(ctx, errors.Wrap(err, "Fail to load state of DetectionWatcher"))
+	}
+	if state == nil {
+		state = &DetectionWatchState{Watermark: x.Since}
+	}
+	if state.Detections == nil {
+		state.Detections = make(map[string]*DetectionSnapshot)
+	}
+
+	detections, err := x.fetch(ctx, field, state.Watermark)
+	if err == ErrOffsetLimit {
+		// detections are the oldest ones in order of watermark. Remaining are retrieved by next poll from the advanced watermark.
+		x.detection.client.log.Warn("Detections exceed offset limit, remaining are polled next time", LogFields{
+			"name":     x.name(),
+			"returned": len(detections),
+		})
+	} else if err != nil {
+		return x.handleError(ctx, err)
+	}
+
+	changes := 0
+	for _, d := range detections {
+		for _, change := range diffDetection(d, state.Detections[d.DetectionID]) {
+			changes++
+			if err := handler(ctx, change); err != nil {
+				if err := x.handleError(ctx, errors.Wrapf(err, "Fail to handle %s of %s", change.Type, d.DetectionID)); err != nil {
+					return err
+				}
+			}
+		}
+
+		wm := x.watermarkOf(d)
+		state.Detections[d.DetectionID] = snapshotDetection(d, wm)
+		if wm.After(state.Watermark) {
+			state.Watermark = wm
+		}
+	}
+
+	x.prune(state)
+	if err := x.Store.Save(ctx, x.name(), state); err != nil {
+		return x.handleError(ctx, errors.Wrap(err, "Fail to save state of DetectionWatcher"))
+	}
+
+	x.detection.client.log.Debug("Done DetectionWatcher poll", LogFields{
+		"name":      x.name(),
+		"watermark": state.Watermark,
+		"returned":  len(detections),
+		"changes":   changes,
+	})
+	return nil
+}
+
+// handleError passes err to OnError. Cancellation of ctx is returned as it is.
+func (x *DetectionWatcher) handleError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if x.OnError == nil {
+		return err
+	}
+	return x.OnError(err)
+}
+
+func (x *DetectionWatcher) name() string {
+	if x.Name == "" {
+		return defaultDetectionWatchName
+	}
+	return x.Name
+}
+
+func (x *DetectionWatcher) watermarkField() string {
+	if x.WatermarkField == "" {
+		return WatermarkDateUpdated
+	}
+	return x.WatermarkField
+}
+
+// watermarkOf returns value of watermark field of d.
+func (x *DetectionWatcher) watermarkOf(d *DetectionResources) time.Time {
+	if x.watermarkField() == WatermarkLastBehavior {
+		return d.LastBehavior
+	}
+	return d.DateUpdated
+}
+
+// fetch retrieves detections updated after watermark in order of watermark field. If matched detections exceed offset limit of pagination, detections retrieved until the limit are returned with ErrOffsetLimit.
+func (x *DetectionWatcher) fetch(ctx context.Context, field string, watermark time.Time) ([]*DetectionResources, error) {
+	input := &QueriesDetectsInput{
+		Sort:       String(field + ".asc"),
+		FilterExpr: x.FilterExpr,
+	}
+	if x.Filter != "" {
+		input.Filter = String(x.Filter)
+	}
+	if !watermark.IsZero() {
+		cond := fql.Ge(field, fql.Time(watermark.Add(-x.Overlap)))
+		if input.FilterExpr != nil {
+			cond = fql.And(input.FilterExpr, cond)
+		}
+		input.FilterExpr = cond
+	}
+
+	// IDs are collected before hydration because FetchSummaries discards pending batches at ErrOffsetLimit
+	// Explicit limit lets Paginator stop at MaxOffset with ErrOffsetLimit instead of requesting beyond it
+	p := x.detection.QueriesDetectsPaginator(input)
+	p.Limit = EntitySummariesMaxIDs
+	p.MaxOffset = x.maxOffset
+	ids, pageErr := p.All(ctx)
+	if pageErr != nil && pageErr != ErrOffsetLimit {
+		return nil, errors.Wrap(pageErr, "Fail to poll detections")
+	}
+
+	var detections []*DetectionResources
+	for q := range x.detection.FetchSummaries(ctx, idPaginator(ids), nil) {
+		if q.Error != nil {
+			return nil, errors.Wrap(q.Error, "Fail to poll detections")
+		}
+		detections = append(detections, q.Detection)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// FetchSummaries retrieves summaries concurrently, then sort them again
+	sort.SliceStable(detections, func(i, j int) bool {
+		ti, tj := x.watermarkOf(detections[i]), x.watermarkOf(detections[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return detections[i].DetectionID < detections[j].DetectionID
+	})
+	return detections, pageErr
+}
+
+// idPaginator returns Paginator that walks ids as a page.
+func idPaginator(ids []string) *Paginator {
+	return NewPaginator(OffsetPagination, func(ctx context.Context, page Page) ([]string, MetaData, error) {
+		return ids, MetaData{}, nil
+	})
+}
+
+// prune discards seen detections not updated within Retention before watermark.
+func (x *DetectionWatcher) prune(state *DetectionWatchState) {
+	retention := x.Retention
+	if retention <= 0 {
+		retention = DefaultDetectionWatchRetention
+	}
+	threshold := state.Watermark.Add(-retention)
+	for id, s := range state.Detections {
+		if s.Updated.Before(threshold) {
+			delete(state.Detections, id)
+		}
+	}
+}
+
+func snapshotDetection(d *DetectionResources, updated time.Time) *DetectionSnapshot {
+	s := &DetectionSnapshot{
+		Status:        d.Status,
+		AssignedToUID: d.AssignedToUID,
+		Updated:       updated,
+	}
+	for _, b := range d.Behaviors {
+		s.BehaviorIDs = append(s.BehaviorIDs, b.BehaviorID)
+	}
+	return s
+}
+
+// diffDetection returns changes of d from prev. A detection not seen before is only DetectionChangeNew.
+func diffDetection(d *DetectionResources, prev *DetectionSnapshot) []*DetectionChange {
+	if prev == nil {
+		return []*DetectionChange{{Type: DetectionChangeNew, Detection: d}}
+	}
+
+	var changes []*DetectionChange
+	if d.Status != prev.Status {
+		changes = append(changes, &DetectionChange{Type: DetectionChangeStatus, Detection: d, Previous: prev})
+	}
+
+	seen := make(map[string]bool, len(prev.BehaviorIDs))
+	for _, id := range prev.BehaviorIDs {
+		seen[id] = true
+	}
+	var added []DetectionBehavior
+	for _, b := range d.Behaviors {
+		if !seen[b.BehaviorID] {
+			added = append(added, b)
+		}
+	}
+	if len(added) > 0 {
+		changes = append(changes, &DetectionChange{Type: DetectionChangeBehaviors, Detection: d, Previous: prev, NewBehaviors: added})
+	}
+
+	if d.AssignedToUID != prev.AssignedToUID {
+		changes = append(changes, &DetectionChange{Type: DetectionChangeAssignment, Detection: d, Previous: prev})
+	}
+	return changes
+}
+
+// MemoryDetectionWatchStore is DetectionWatchStore in memory.
+type MemoryDetectionWatchStore struct {
+	mutex  sync.Mutex
+	states map[string][]byte
+}
+
+// NewMemoryDetectionWatchStore is constructor of MemoryDetectionWatchStore.
+func NewMemoryDetectionWatchStore() *MemoryDetectionWatchStore {
+	return &MemoryDetectionWatchStore{states: make(map[string][]byte)}
+}
+
+// Load returns copy of state in memory.
+func (x *MemoryDetectionWatchStore) Load(ctx context.Context, name string) (*DetectionWatchState, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	raw, ok := x.states[name]
+	if !ok {
+		return nil, nil
+	}
+	var state DetectionWatchState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, errors.Wrap(err, "Fail to parse state of DetectionWatcher")
+	}
+	return &state, nil
+}
+
+// Save stores copy of state in memory.
+func (x *MemoryDetectionWatchStore) Save(ctx context.Context, name string, state *DetectionWatchState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "Fail to marshal state of DetectionWatcher")
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.states[name] = raw
+	return nil
+}
+
+// FileDetectionWatchStore is DetectionWatchStore with a JSON file. The file is replaced atomically by rename on each Save.
+type FileDetectionWatchStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewFileDetectionWatchStore is constructor of FileDetectionWatchStore. The file is created at first Save.
+func NewFileDetectionWatchStore(path string) *FileDetectionWatchStore {
+	return &FileDetectionWatchStore{path: path}
+}
+
+func (x *FileDetectionWatchStore) read() (map[string]*DetectionWatchState, error) {
+	states := make(map[string]*DetectionWatchState)
+	raw, err := ioutil.ReadFile(x.path)
+	if os.IsNotExist(err) {
+		return states, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Fail to read state file: %s", x.path)
+	}
+
+	if err := json.Unmarshal(raw, &states); err != nil {
+		return nil, errors.Wrapf(err, "Fail to parse state file: %s", x.path)
+	}
+	return states, nil
+}
+
+// Load returns state in the file.
+func (x *FileDetectionWatchStore) Load(ctx context.Context, name string) (*DetectionWatchState, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	states, err := x.read()
+	if err != nil {
+		return nil, err
+	}
+	return states[name], nil
+}
+
+// Save writes state to the file.
+func (x *FileDetectionWatchStore) Save(ctx context.Context, name string, state *DetectionWatchState) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	states, err := x.read()
+	if err != nil {
+		return err
+	}
+	states[name] = state
+
+	raw, err := json.Marshal(states)
+	if err != nil {
+		return errors.Wrap(err, "Fail to marshal state of DetectionWatcher")
+	}
+
+	return writeFileAtomic(x.path, raw)
+}
@@ -0,0 +1,328 @@
+package gofalcon_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type changeRecorder struct {
+	changes []*gofalcon.DetectionChange
+}
+
+func (x *changeRecorder) handle(ctx context.Context, change *gofalcon.DetectionChange) error {
+	x.changes = append(x.changes, change)
+	return nil
+}
+
+func (x *changeRecorder) types(id string) []gofalcon.DetectionChangeType {
+	var types []gofalcon.DetectionChangeType
+	for _, c := range x.changes {
+		if c.Detection.DetectionID == id {
+			types = append(types, c.Type)
+		}
+	}
+	return types
+}
+
+func TestDetectionWatcher(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	ids := server.SeedDetections(3)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "gofalcon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := gofalcon.NewFileDetectionWatchStore(filepath.Join(dir, "watcher.json"))
+
+	newWatcher := func() *gofalcon.DetectionWatcher {
+		watcher := gofalcon.NewDetectionWatcher(client)
+		watcher.Store = store
+		return watcher
+	}
+	ctx := context.Background()
+
+	t.Run("first poll issues all detections as new", func(t *testing.T) {
+		var rec changeRecorder
+		require.NoError(t, newWatcher().Poll(ctx, rec.handle))
+		require.Equal(t, 3, len(rec.changes))
+		for _, c := range rec.changes {
+			assert.Equal(t, gofalcon.DetectionChangeNew, c.Type)
+			assert.Nil(t, c.Previous)
+		}
+	})
+
+	t.Run("no change", func(t *testing.T) {
+		var rec changeRecorder
+		require.NoError(t, newWatcher().Poll(ctx, rec.handle))
+		assert.Equal(t, 0, len(rec.changes))
+
+		// Watermark is used as filter of the query
+		var last falcontest.Request
+		for _, req := range server.Requests() {
+			if req.Path == "/detects/queries/detects/v1" {
+				last = req
+			}
+		}
+		assert.Contains(t, last.Query.Get("filter"), "date_updated:>='")
+		assert.Equal(t, "date_updated.asc", last.Query.Get("sort"))
+	})
+
+	t.Run("status, assignment and behaviors", func(t *testing.T) {
+		_, err := client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+			IDs:            ids[:1],
+			Status:         gofalcon.DetectStatusInProgress,
+			AssignedToUUID: gofalcon.String("analyst"),
+		})
+		require.NoError(t, err)
+		require.True(t, server.AddBehaviors(ids[1], gofalcon.DetectionBehavior{
+			BehaviorID: "b1",
+			Tactic:     "Execution",
+			Timestamp:  time.Now().UTC(),
+		}))
+		server.AddDetections(gofalcon.DetectionResources{
+			DetectionID: "ldt:new",
+			Status:      "new",
+			DateUpdated: time.Now().UTC(),
+		})
+
+		// Restarted watcher resumes from the stored state
+		var rec changeRecorder
+		require.NoError(t, newWatcher().Poll(ctx, rec.handle))
+		assert.Equal(t, []gofalcon.DetectionChangeType{gofalcon.DetectionChangeStatus, gofalcon.DetectionChangeAssignment}, rec.types(ids[0]))
+		assert.Equal(t, []gofalcon.DetectionChangeType{gofalcon.DetectionChangeBehaviors}, rec.types(ids[1]))
+		assert.Equal(t, 0, len(rec.types(ids[2])))
+		assert.Equal(t, []gofalcon.DetectionChangeType{gofalcon.DetectionChangeNew}, rec.types("ldt:new"))
+
+		for _, c := range rec.changes {
+			switch c.Type {
+			case gofalcon.DetectionChangeStatus:
+				require.NotNil(t, c.Previous)
+				assert.NotEqual(t, "in_progress", c.Previous.Status)
+				assert.Equal(t, "in_progress", c.Detection.Status)
+			case gofalcon.DetectionChangeAssignment:
+				assert.Equal(t, "", c.Previous.AssignedToUID)
+				assert.Equal(t, "analyst", c.Detection.AssignedToUID)
+			case gofalcon.DetectionChangeBehaviors:
+				require.Equal(t, 1, len(c.NewBehaviors))
+				assert.Equal(t, "b1", c.NewBehaviors[0].BehaviorID)
+			}
+		}
+
+		state, err := store.Load(ctx, "default")
+		require.NoError(t, err)
+		require.NotNil(t, state)
+		// ids[2] is not updated since 2020 and pruned by Retention
+		assert.Equal(t, 3, len(state.Detections))
+		assert.NotContains(t, state.Detections, ids[2])
+		assert.WithinDuration(t, time.Now(), state.Watermark, time.Minute)
+	})
+}
+
+func TestDetectionWatcherHandlerError(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	server.SeedDetections(2)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	failure := errors.New("handler failed")
+	failing := func(ctx context.Context, change *gofalcon.DetectionChange) error { return failure }
+
+	t.Run("state is not saved if handler fails", func(t *testing.T) {
+		watcher := gofalcon.NewDetectionWatcher(client)
+		err := watcher.Poll(ctx, failing)
+		assert.True(t, errors.Is(err, failure))
+
+		var rec changeRecorder
+		require.NoError(t, watcher.Poll(ctx, rec.handle))
+		assert.Equal(t, 2, len(rec.changes))
+	})
+
+	t.Run("OnError ignores error", func(t *testing.T) {
+		watcher := gofalcon.NewDetectionWatcher(client)
+		var ignored []error
+		watcher.OnError = func(err error) error {
+			ignored = append(ignored, err)
+			return nil
+		}
+		require.NoError(t, watcher.Poll(ctx, failing))
+		assert.Equal(t, 2, len(ignored))
+
+		var rec changeRecorder
+		require.NoError(t, watcher.Poll(ctx, rec.handle))
+		assert.Equal(t, 0, len(rec.changes))
+	})
+
+	t.Run("invalid watermark field", func(t *testing.T) {
+		watcher := gofalcon.NewDetectionWatcher(client)
+		watcher.WatermarkField = "created_timestamp"
+		assert.Error(t, watcher.Poll(ctx, failing))
+	})
+
+	t.Run("nil store", func(t *testing.T) {
+		watcher := gofalcon.NewDetectionWatcher(client)
+		watcher.Store = nil
+		assert.Error(t, watcher.Poll(ctx, failing))
+	})
+}
+
+func TestDetectionWatcherRun(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	ids := server.SeedDetections(1)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	watcher := gofalcon.NewDetectionWatcher(client)
+	watcher.Interval = time.Millisecond * 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan *gofalcon.DetectionChange, 16)
+	done := make(chan error)
+	go func() {
+		done <- watcher.Run(ctx, func(ctx context.Context, change *gofalcon.DetectionChange) error {
+			ch <- change
+			return nil
+		})
+	}()
+
+	receive := func() *gofalcon.DetectionChange {
+		select {
+		case c := <-ch:
+			return c
+		case <-time.After(time.Second * 5):
+			require.Fail(t, "timeout")
+		}
+		return nil
+	}
+
+	assert.Equal(t, gofalcon.DetectionChangeNew, receive().Type)
+
+	_, err = client.Detection.UpdateDetects(&gofalcon.UpdateDetectsInput{
+		IDs:    ids,
+		Status: gofalcon.DetectStatusClosed,
+	})
+	require.NoError(t, err)
+	c := receive()
+	assert.Equal(t, gofalcon.DetectionChangeStatus, c.Type)
+	assert.Equal(t, "closed", c.Detection.Status)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		require.Fail(t, "Run is not stopped")
+	}
+}
+
+// newWatermarkServer serves detections sorted by date_updated and filtered by "date_updated:>=" condition of the watcher.
+func newWatermarkServer(t *testing.T, detections []gofalcon.DetectionResources) *httptest.Server {
+	cond := regexp.MustCompile(`date_updated:>='([^']+)'`)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/detects/queries/detects/v1":
+			var since time.Time
+			if m := cond.FindStringSubmatch(r.URL.Query().Get("filter")); m != nil {
+				var err error
+				since, err = time.Parse(time.RFC3339, m[1])
+				require.NoError(t, err)
+			}
+			var ids []string
+			for _, d := range detections { // sorted by date_updated
+				if !d.DateUpdated.Before(since) {
+					ids = append(ids, d.DetectionID)
+				}
+			}
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			end := offset + limit
+			if end > len(ids) {
+				end = len(ids)
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"meta":      map[string]interface{}{"pagination": map[string]int{"offset": offset, "limit": limit, "total": len(ids)}},
+				"resources": ids[offset:end],
+			}))
+
+		case "/detects/entities/summaries/GET/v1":
+			var input gofalcon.EntitySummariesInput
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+			var resources []gofalcon.DetectionResources
+			for _, d := range detections {
+				for _, id := range input.ID {
+					if d.DetectionID == id {
+						resources = append(resources, d)
+					}
+				}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"resources": resources}))
+		}
+	}))
+}
+
+func TestDetectionWatcherOffsetLimit(t *testing.T) {
+	base := time.Now().UTC().Add(-time.Hour * 48).Truncate(time.Second)
+	var detections []gofalcon.DetectionResources
+	for i := 0; i < 25; i++ {
+		detections = append(detections, gofalcon.DetectionResources{
+			DetectionID: fmt.Sprintf("ldt:%02d", i),
+			Status:      "new",
+			DateUpdated: base.Add(time.Hour * time.Duration(i)),
+		})
+	}
+	server := newWatermarkServer(t, detections)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+	watcher := gofalcon.NewDetectionWatcher(client)
+	watcher.SetMaxOffset(10)
+	ctx := context.Background()
+
+	// First poll with zero watermark stops at offset limit without error
+	var rec changeRecorder
+	require.NoError(t, watcher.Poll(ctx, rec.handle))
+	require.Equal(t, 10, len(rec.changes))
+	assert.Equal(t, "ldt:09", rec.changes[9].Detection.DetectionID)
+
+	state, err := watcher.Store.Load(ctx, "default")
+	require.NoError(t, err)
+	assert.True(t, state.Watermark.Equal(base.Add(time.Hour*9)))
+
+	// Following polls continue from the watermark
+	for i := 0; i < 2; i++ {
+		require.NoError(t, watcher.Poll(ctx, rec.handle))
+	}
+	seen := map[string]int{}
+	for _, c := range rec.changes {
+		assert.Equal(t, gofalcon.DetectionChangeNew, c.Type)
+		seen[c.Detection.DetectionID]++
+	}
+	assert.Equal(t, 25, len(seen))
+	assert.Equal(t, 25, len(rec.changes))
+}
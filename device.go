@@ -1,12 +1,13 @@
 package gofalcon
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
 
+	"github.com/m-mizutani/gofalcon/fql"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 type DeviceResource struct {
@@ -90,6 +91,9 @@ type QueryDevicesInput struct {
 	Limit   *int
 	Sort    *string
 	Filters []QueryDevicesFilter
+
+	// FilterExpr is FQL filter built by fql package. It's joined with Filters by AND if both are set.
+	FilterExpr fql.Expr
 }
 
 type QueryDevicesOutput struct {
@@ -99,6 +103,11 @@ type QueryDevicesOutput struct {
 
 // QueryDevices searches hosts in your environment by platform, hostname, IP, and other criteria.
 func (x *DeviceAPI) QueryDevices(input *QueryDevicesInput) (*QueryDevicesOutput, error) {
+	return x.QueryDevicesWithContext(context.Background(), input)
+}
+
+// QueryDevicesWithContext is same with QueryDevices, but the request is bound to ctx.
+func (x *DeviceAPI) QueryDevicesWithContext(ctx context.Context, input *QueryDevicesInput) (*QueryDevicesOutput, error) {
 	qs := url.Values{}
 	if input.Offset != nil {
 		qs.Add("offset", fmt.Sprintf("%d", *input.Offset))
@@ -109,12 +118,12 @@ func (x *DeviceAPI) QueryDevices(input *QueryDevicesInput) (*QueryDevicesOutput,
 	if input.Sort != nil {
 		qs.Add("sort", *input.Sort)
 	}
-	if len(input.Filters) > 0 {
-		var filters []string
-		for _, filter := range input.Filters {
-			filters = append(filters, filter.String())
-		}
-		qs.Add("filter", strings.Join(filters, "+"))
+	var filters []string
+	for _, filter := range input.Filters {
+		filters = append(filters, filter.String())
+	}
+	if filter := joinFilters(strings.Join(filters, "+"), exprString(input.FilterExpr)); filter != "" {
+		qs.Add("filter", filter)
 	}
 
 	req := Request{
@@ -123,19 +132,37 @@ func (x *DeviceAPI) QueryDevices(input *QueryDevicesInput) (*QueryDevicesOutput,
 	}
 
 	var output QueryDevicesOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to QueryDevice")
 	}
 
-	Logger.WithFields(logrus.Fields{
-		"qs":       qs.Encode(),
-		"meta":     output.Meta,
-		"returned": len(output.Resources),
-	}).Debug("Done QueryDevices")
+	x.client.log.Debug("Done QueryDevices", LogFields{
+		"qs":          qs.Encode(),
+		LogKeyTraceID: output.Meta.TraceID,
+		LogKeyPath:    req.Path,
+		"returned":    len(output.Resources),
+	})
 
 	return &output, nil
 }
 
+// QueryDevicesPaginator returns Paginator to retrieve all device IDs matched with input. Offset and Limit of input are ignored, use Paginator.Limit instead.
+func (x *DeviceAPI) QueryDevicesPaginator(input *QueryDevicesInput) *Paginator {
+	return NewPaginator(OffsetPagination, func(ctx context.Context, page Page) ([]string, MetaData, error) {
+		pageInput := *input
+		pageInput.Offset = Int(page.Offset)
+		if page.Limit > 0 {
+			pageInput.Limit = Int(page.Limit)
+		}
+
+		output, err := x.QueryDevicesWithContext(ctx, &pageInput)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+		return output.Resources, output.Meta, nil
+	})
+}
+
 type EntityDevicesInput struct {
 	ID []string
 }
@@ -147,6 +174,11 @@ type EntityDevicesOutput struct {
 
 // EntityDevices gets details on one or more hosts by providing agent IDs (AID)
 func (x *DeviceAPI) EntityDevices(input *EntityDevicesInput) (*EntityDevicesOutput, error) {
+	return x.EntityDevicesWithContext(context.Background(), input)
+}
+
+// EntityDevicesWithContext is same with EntityDevices, but the request is bound to ctx.
+func (x *DeviceAPI) EntityDevicesWithContext(ctx context.Context, input *EntityDevicesInput) (*EntityDevicesOutput, error) {
 	qs := url.Values{}
 	for _, id := range input.ID {
 		qs.Add("ids", id)
@@ -158,15 +190,61 @@ func (x *DeviceAPI) EntityDevices(input *EntityDevicesInput) (*EntityDevicesOutp
 	}
 
 	var output EntityDevicesOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to EntityDevices")
 	}
 
-	Logger.WithFields(logrus.Fields{
-		"qs":       qs.Encode(),
-		"meta":     output.Meta,
-		"returned": len(output.Resources),
-	}).Debug("Done EntityDevices")
+	x.client.log.Debug("Done EntityDevices", LogFields{
+		"qs":          qs.Encode(),
+		LogKeyTraceID: output.Meta.TraceID,
+		LogKeyPath:    req.Path,
+		"returned":    len(output.Resources),
+	})
 
 	return &output, nil
 }
+
+// DeviceQueue is issued from FetchDevices. If error is occurred, Device must be nil.
+type DeviceQueue struct {
+	Error  error
+	Device *DeviceResource
+}
+
+// FetchDevices retrieves details of all devices walked by p (e.g. QueryDevicesPaginator). IDs are split into batches (EntityDevicesMaxIDs by default) and fetched concurrently. The channel is closed after all devices are issued or an error is issued.
+func (x *DeviceAPI) FetchDevices(ctx context.Context, p *Paginator, options *HydrateOptions) chan *DeviceQueue {
+	ch := make(chan *DeviceQueue, EntityDevicesMaxIDs)
+	opt := HydrateOptions{BatchSize: EntityDevicesMaxIDs}
+	if options != nil {
+		opt.Workers = options.Workers
+		if options.BatchSize > 0 && options.BatchSize < EntityDevicesMaxIDs {
+			opt.BatchSize = options.BatchSize
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		err := Hydrate(ctx, p, &opt, func(ctx context.Context, ids []string) error {
+			output, err := x.EntityDevicesWithContext(ctx, &EntityDevicesInput{ID: ids})
+			if err != nil {
+				return err
+			}
+			for i := range output.Resources {
+				select {
+				case ch <- &DeviceQueue{Device: &output.Resources[i]}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+
+		if err != nil && ctx.Err() == nil {
+			select {
+			case ch <- &DeviceQueue{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,86 @@
+package gofalcon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// APIError is returned when Falcon API responds error status code or errors in response body. It can be extracted from wrapped error by errors.As.
+type APIError struct {
+	// StatusCode is HTTP status code of the response
+	StatusCode int
+	// Method and Path of the request
+	Method string
+	Path   string
+	// Errors is "errors" field of the response
+	Errors []ServerError
+	// TraceID is "meta.trace_id" field of the response. It's required to ask CrowdStrike support.
+	TraceID string
+	// Body is raw response body
+	Body []byte
+}
+
+func (x *APIError) Error() string {
+	msg := string(x.Body)
+	if len(x.Errors) > 0 {
+		var messages []string
+		for _, e := range x.Errors {
+			messages = append(messages, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		msg = strings.Join(messages, ", ")
+	}
+
+	return fmt.Sprintf("Fail HTTP request %s %s (status=%d, trace_id=%s): %s",
+		x.Method, x.Path, x.StatusCode, x.TraceID, msg)
+}
+
+// Retryable returns true if the request can be succeeded by retry.
+func (x *APIError) Retryable() bool {
+	return isRetryableStatus(x.StatusCode)
+}
+
+// AsAPIError extracts *APIError from err. It returns nil if err does not have *APIError.
+func AsAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return nil
+}
+
+func hasStatus(err error, codes ...int) bool {
+	apiErr := AsAPIError(err)
+	if apiErr == nil {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound returns true if err is caused by HTTP 404 response.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsRateLimited returns true if err is caused by HTTP 429 response.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// IsAuthError returns true if err is caused by HTTP 401 or 403 response.
+func IsAuthError(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized, http.StatusForbidden)
+}
+
+// IsRetryable returns true if err is caused by a response that can be succeeded by retry.
+func IsRetryable(err error) bool {
+	apiErr := AsAPIError(err)
+	return apiErr != nil && apiErr.Retryable()
+}
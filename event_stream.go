@@ -1,9 +1,11 @@
 package gofalcon
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
@@ -14,7 +16,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 // SensorAPI is for /sensor/ prefix APIs
@@ -39,6 +40,15 @@ type DataFeedSessionToken struct {
 	Token      string `json:"token"`
 }
 
+// ExpiresAt parses Expiration.
+func (x DataFeedSessionToken) ExpiresAt() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, x.Expiration)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "Fail to parse expiration of session token: %s", x.Expiration)
+	}
+	return t, nil
+}
+
 type DataFeedResource struct {
 	DataFeedURL                  string `json:"dataFeedURL"`
 	RefreshActiveSessionInterval int    `json:"refreshActiveSessionInterval"`
@@ -46,6 +56,29 @@ type DataFeedResource struct {
 	SessionToken                 DataFeedSessionToken
 }
 
+// withOffset returns copy of x that DataFeedURL has offset parameter. The stream starts from the event of the offset.
+func (x DataFeedResource) withOffset(offset int) (DataFeedResource, error) {
+	u, err := url.Parse(x.DataFeedURL)
+	if err != nil {
+		return x, errors.Wrapf(err, "Fail to parse DataFeedURL: %s", x.DataFeedURL)
+	}
+
+	qs := u.Query()
+	qs.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = qs.Encode()
+	x.DataFeedURL = u.String()
+	return x, nil
+}
+
+// refreshInterval returns duration until next refresh of active session. margin is subtracted from RefreshActiveSessionInterval advertised by server. DefaultRefreshInterval is used if the interval is not available.
+func (x DataFeedResource) refreshInterval(margin time.Duration) time.Duration {
+	interval := time.Second*time.Duration(x.RefreshActiveSessionInterval) - margin
+	if interval <= 0 {
+		return DefaultRefreshInterval
+	}
+	return interval
+}
+
 // Partition extracts parition number from DataFeedURL
 func (x DataFeedResource) Partition() (int, error) {
 	urlArr := strings.Split(strings.Split(x.DataFeedURL, "?")[0], "/")
@@ -58,6 +91,11 @@ func (x DataFeedResource) Partition() (int, error) {
 
 // EntitiesDatafeed retrieves URL of event stream.
 func (x *SensorAPI) EntitiesDatafeed(input *EntitiesDatafeedInput) (*EntitiesDatafeedOutput, error) {
+	return x.EntitiesDatafeedWithContext(context.Background(), input)
+}
+
+// EntitiesDatafeedWithContext is same with EntitiesDatafeed, but the request is bound to ctx.
+func (x *SensorAPI) EntitiesDatafeedWithContext(ctx context.Context, input *EntitiesDatafeedInput) (*EntitiesDatafeedOutput, error) {
 	qs := url.Values{}
 	if input.AppID != nil {
 		qs.Add("appId", *input.AppID)
@@ -73,15 +111,15 @@ func (x *SensorAPI) EntitiesDatafeed(input *EntitiesDatafeedInput) (*EntitiesDat
 	}
 
 	var output EntitiesDatafeedOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to query detections")
 	}
 
-	Logger.WithFields(logrus.Fields{
-		"appId":  StringValue(input.AppID),
-		"format": StringValue(input.Format),
-		"meta":   output.Meta,
-	}).Debug("Done SensorAPI.EntitiesDatafeed")
+	x.client.log.Debug("Done SensorAPI.EntitiesDatafeed", LogFields{
+		LogKeyAppID:   StringValue(input.AppID),
+		"format":      StringValue(input.Format),
+		LogKeyTraceID: output.Meta.TraceID,
+	})
 
 	return &output, nil
 }
@@ -100,6 +138,11 @@ type EntitiesDatafeedActionOutput struct {
 
 // EntitiesDatafeedAction retrieves URL of event stream.
 func (x *SensorAPI) EntitiesDatafeedAction(input *EntitiesDatafeedActionInput) (*EntitiesDatafeedActionOutput, error) {
+	return x.EntitiesDatafeedActionWithContext(context.Background(), input)
+}
+
+// EntitiesDatafeedActionWithContext is same with EntitiesDatafeedAction, but the request is bound to ctx.
+func (x *SensorAPI) EntitiesDatafeedActionWithContext(ctx context.Context, input *EntitiesDatafeedActionInput) (*EntitiesDatafeedActionOutput, error) {
 	qs := url.Values{}
 	if input.AppID == nil {
 		return nil, fmt.Errorf("Input AppID is required")
@@ -122,20 +165,62 @@ func (x *SensorAPI) EntitiesDatafeedAction(input *EntitiesDatafeedActionInput) (
 	}
 
 	var output EntitiesDatafeedActionOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrapf(err, "Fail to %s DataFeed", *input.ActionName)
 	}
 
-	Logger.WithFields(logrus.Fields{
-		"appId":       StringValue(input.AppID),
-		"action_name": StringValue(input.ActionName),
-		"partition":   IntValue(input.Partition),
-		"meta":        output.Meta,
-	}).Debug("Done SensorAPI.EntitiesDatafeedAction")
+	x.client.log.Debug("Done SensorAPI.EntitiesDatafeedAction", LogFields{
+		LogKeyAppID:     StringValue(input.AppID),
+		"action_name":   StringValue(input.ActionName),
+		LogKeyPartition: IntValue(input.Partition),
+		LogKeyTraceID:   output.Meta.TraceID,
+	})
 
 	return &output, nil
 }
 
+// RefreshActiveStreamSession extends session of the partition stream by RefreshActiveSessionURL of feed. If the URL is not available, EntitiesDatafeedAction with "refresh_active_stream_session" is called instead.
+func (x *SensorAPI) RefreshActiveStreamSession(ctx context.Context, appID string, feed DataFeedResource) error {
+	if feed.RefreshActiveSessionURL == "" {
+		partition, err := feed.Partition()
+		if err != nil {
+			return err
+		}
+		_, err = x.EntitiesDatafeedActionWithContext(ctx, &EntitiesDatafeedActionInput{
+			AppID:      &appID,
+			ActionName: String("refresh_active_stream_session"),
+			Partition:  &partition,
+		})
+		return err
+	}
+
+	u, err := url.Parse(feed.RefreshActiveSessionURL)
+	if err != nil {
+		return errors.Wrapf(err, "Fail to parse RefreshActiveSessionURL: %s", feed.RefreshActiveSessionURL)
+	}
+
+	req := Request{
+		Method:      "POST",
+		Path:        u.Path,
+		QueryString: u.Query(),
+		Headers:     []httpHeader{{"Content-Type", "application/json"}},
+		Idempotent:  true,
+	}
+
+	var output EntitiesDatafeedActionOutput
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
+		return errors.Wrap(err, "Fail to refresh active stream session")
+	}
+
+	x.client.log.Debug("Done SensorAPI.RefreshActiveStreamSession", LogFields{
+		LogKeyAppID:   appID,
+		LogKeyPath:    u.Path,
+		LogKeyTraceID: output.Meta.TraceID,
+	})
+
+	return nil
+}
+
 // --------------------------------------------
 
 // StreamEventMetaData is metadata of event stream from Falcon API.
@@ -147,51 +232,189 @@ type StreamEventMetaData struct {
 }
 
 type streamEvent struct {
-	Meta  StreamEventMetaData    `json:"metadata"`
-	Event map[string]interface{} `json:"event"`
+	Meta  StreamEventMetaData `json:"metadata"`
+	Event json.RawMessage     `json:"event"`
 }
 
 // StreamQueue is issued from EventStream() including metadata, event and error.
-// If error is occurred, Meta and Event must be nil.
+// If error is occurred, Meta and Event must be nil. If Reconnect is set, Error, Meta and Event must be nil.
+//
+// Payload is typed event decoded by Meta.EventType (e.g. *DetectionSummaryEvent). It's *UnknownEvent if the event type is not supported. See RegisterStreamEvent to add event type.
 type StreamQueue struct {
+	Error     error
+	Partition int
+	Meta      *StreamEventMetaData
+	Event     map[string]interface{}
+	Payload   StreamEvent
+	Reconnect *StreamReconnect
+
+	ack func() error
+}
+
+// Ack commits offset of the event to CheckpointStore of EventStreamInput. Call it after the event has been processed, then the stream resumes from the next event on restart. It does nothing if CheckpointStore is not set. Offset older than committed one is ignored.
+func (x *StreamQueue) Ack() error {
+	if x.ack == nil {
+		return nil
+	}
+	return x.ack()
+}
+
+// StreamReconnect is issued in StreamQueue when the stream of a partition has been disconnected and is reconnecting. It's not terminal error, the stream continues.
+type StreamReconnect struct {
+	// Attempt is number of consecutive reconnects. It's reset when an event is received.
+	Attempt int
+	// Reason is the cause of disconnection.
+	Reason error
+	// Offset is the offset of the event that the stream resumes from. -1 means no offset is specified.
+	Offset int
+	// NewSession is true if DataFeedURL and session token have been renewed by EntitiesDatafeed.
+	NewSession bool
+}
+
+// ReconnectPolicy configures reconnection of event stream.
+type ReconnectPolicy struct {
+	// MaxAttempts is maximum number of consecutive reconnects. 0 means unlimited.
+	MaxAttempts int
+	// MinBackoff is wait time before the first reconnect. It's doubled by each consecutive reconnect.
+	MinBackoff time.Duration
+	// MaxBackoff is upper limit of wait time.
+	MaxBackoff time.Duration
+	// MaxRenewals is maximum number of renewals of DataFeedURL since the last received event. It's applied even if MaxAttempts is 0. 0 means DefaultMaxRenewals.
+	MaxRenewals int
+}
+
+// DefaultReconnectPolicy reconnects unlimitedly with backoff from 1 second to 1 minute.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MinBackoff: time.Second,
+	MaxBackoff: time.Minute,
+}
+
+func (x ReconnectPolicy) maxRenewals() int {
+	if x.MaxRenewals > 0 {
+		return x.MaxRenewals
+	}
+	return DefaultMaxRenewals
+}
+
+func (x ReconnectPolicy) backoff(attempt int) time.Duration {
+	return RetryPolicy{MinBackoff: x.MinBackoff, MaxBackoff: x.MaxBackoff}.backoff(attempt)
+}
+
+// RefreshResult is notified to EventStreamInput.OnRefresh for each attempt to refresh active session.
+type RefreshResult struct {
+	AppID     string
+	Partition int
+	// Attempt is 1 for first try, and incremented by retry.
+	Attempt int
+	// Error is nil if the refresh succeeded.
 	Error error
-	Meta  *StreamEventMetaData
-	Event map[string]interface{}
+	// Next is scheduled time of next refresh. It's zero if the refresh failed.
+	Next time.Time
 }
 
 const (
-	// StreamEventQueueSize is default queue size
+	// StreamEventQueueSize is default size of the buffer of EventStream
 	StreamEventQueueSize = 1024
+
+	// DefaultRefreshMargin is subtracted from RefreshActiveSessionInterval to refresh active session before expiration.
+	DefaultRefreshMargin = time.Minute * 5
+	// DefaultRefreshInterval is used if server does not advertise RefreshActiveSessionInterval.
+	DefaultRefreshInterval = time.Minute * 25
+	// DefaultRefreshRetry is default number of retries of failed refresh.
+	DefaultRefreshRetry = 3
+
+	// StreamRenewTimeout is waiting time to retrieve new DataFeedURL when reconnecting.
+	StreamRenewTimeout = time.Minute * 2
+	// DefaultMaxRenewals is default value of ReconnectPolicy.MaxRenewals
+	DefaultMaxRenewals = 3
 )
 
-func readEventStreamFeed(feed DataFeedResource) chan *StreamQueue {
+var errStreamClosed = errors.New("Event stream is closed by server")
+
+// errStreamRenewing is reason of disconnection to renew DataFeedURL of all partitions.
+var errStreamRenewing = errors.New("Event stream is closed to renew DataFeedURL")
+
+// sendStreamQueue pushes q to ch unless ctx is done. It returns false if ctx is done.
+func sendStreamQueue(ctx context.Context, ch chan *StreamQueue, q *StreamQueue) bool {
+	select {
+	case ch <- q:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (x *SensorAPI) readEventStreamFeed(ctx context.Context, feed DataFeedResource) chan *StreamQueue {
 	ch := make(chan *StreamQueue, 128)
 	go func() {
 		defer close(ch)
 		url := feed.DataFeedURL
-		client := http.Client{}
 
-		req, err := http.NewRequest("GET", url, nil)
+		// Request bound to ctx closes the response body when ctx is cancelled
+		// and then Decode() below returns with error.
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
-			ch <- &StreamQueue{Error: errors.Wrap(err, "fail to create a graylog http request")}
+			sendStreamQueue(ctx, ch, &StreamQueue{Error: errors.Wrap(err, "fail to create a graylog http request")})
 			return
 		}
 
 		req.Header.Add("Authorization", "Token "+feed.SessionToken.Token)
 		req.Header.Add("Accept", "application/json")
+		if x.client.userAgent != "" {
+			req.Header.Set("User-Agent", x.client.userAgent)
+		}
+
+		// Span of connection ends when response header is received
+		attrs := SpanAttributes{SpanKeyMethod: req.Method, SpanKeyPath: req.URL.Path}
+		if partition, err := feed.Partition(); err == nil {
+			attrs[SpanKeyPartition] = partition
+		}
+		spanCtx, span := x.client.tracer.Start(ctx, SpanNameDatafeedConnect, attrs)
+		req = req.WithContext(spanCtx)
+		injectTraceContext(x.client.tracer, spanCtx, req)
+
+		req, err = x.client.middleware.before(req)
+		if err != nil {
+			err = errors.Wrap(err, "Fail to apply middleware to request")
+			span.End(err)
+			sendStreamQueue(ctx, ch, &StreamQueue{Error: err})
+			return
+		}
 
-		resp, err := client.Do(req)
+		start := time.Now()
+		resp, err := x.client.streamHTTPClient.Do(req)
 		if err != nil {
-			ch <- &StreamQueue{Error: errors.Wrap(err, "fail to send request to server")}
+			err = errors.Wrap(err, "fail to send request to server")
+			span.End(err)
+			if ctx.Err() == nil {
+				x.client.middleware.onError(req, err, time.Since(start))
+				sendStreamQueue(ctx, ch, &StreamQueue{Error: err})
+			}
 			return
 		}
+		x.client.middleware.after(req, resp, time.Since(start))
+		span.SetAttributes(SpanAttributes{SpanKeyStatusCode: resp.StatusCode})
 
-		Logger.WithFields(logrus.Fields{
-			"url":  feed.DataFeedURL,
-			"code": resp.StatusCode,
-		}).Info("Opened DataFeedURL")
+		x.client.log.Info("Opened DataFeedURL", LogFields{
+			LogKeyURL: feed.DataFeedURL,
+			"code":    resp.StatusCode,
+		})
 
 		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			apiErr := &APIError{
+				StatusCode: resp.StatusCode,
+				Method:     req.Method,
+				Path:       req.URL.Path,
+				Body:       body,
+			}
+			x.client.middleware.onError(req, apiErr, time.Since(start))
+			span.End(apiErr)
+			sendStreamQueue(ctx, ch, &StreamQueue{Error: apiErr})
+			return
+		}
+		span.End(nil)
 		decoder := json.NewDecoder(resp.Body)
 
 		for {
@@ -199,14 +422,24 @@ func readEventStreamFeed(feed DataFeedResource) chan *StreamQueue {
 			if err := decoder.Decode(&ev); err == io.EOF {
 				break
 			} else if err != nil {
-				ch <- &StreamQueue{Error: errors.Wrap(err, "fail to unmarshal event stream")}
+				if ctx.Err() == nil {
+					sendStreamQueue(ctx, ch, &StreamQueue{Error: errors.Wrap(err, "fail to unmarshal event stream")})
+				}
 				return
 			}
 
 			q := new(StreamQueue)
 			q.Meta = &ev.Meta
-			q.Event = ev.Event
-			ch <- q
+			if err := json.Unmarshal(ev.Event, &q.Event); err != nil {
+				if !sendStreamQueue(ctx, ch, &StreamQueue{Error: errors.Wrap(err, "fail to unmarshal event of event stream")}) {
+					return
+				}
+				continue
+			}
+			q.Payload = DecodeStreamEvent(ev.Meta.EventType, ev.Event)
+			if !sendStreamQueue(ctx, ch, q) {
+				return
+			}
 		}
 	}()
 
@@ -220,18 +453,155 @@ type EventStreamInput struct {
 	// Timeout is waiting seconds to retrieve DataFeedURL. Because another DataFeedURL
 	// can not be retrieved if same AppID process is running,
 	Timeout *int
+
+	// Offsets is starting offset per partition. The stream of the partition starts from the event of the offset. It takes priority over Checkpoint.
+	Offsets map[int]int
+
+	// Checkpoint stores offsets committed by StreamQueue.Ack(). The stream resumes from the event next to the committed offset. AppID should be set to resume the stream because offsets are stored per AppID.
+	Checkpoint CheckpointStore
+
+	// Reconnect enables reconnection of each partition when the stream is disconnected by EOF, decode error or HTTP error. The stream resumes from the event next to the last received one with current DataFeedURL. If the session token is rejected by HTTP 401 or 403, DataFeedURL is renewed by EntitiesDatafeed, and all partitions of the stream are reconnected with new session because EntitiesDatafeed issues nothing while the partitions are connected. Reconnection is issued as StreamQueue.Reconnect instead of error. HTTP 4xx other than 401, 403 and 429 is not recoverable and issued as error without reconnection. If nil, the partition exits at disconnection. DefaultReconnectPolicy is available.
+	Reconnect *ReconnectPolicy
+
+	// RefreshMargin is subtracted from RefreshActiveSessionInterval advertised by server to schedule refresh of active session. 0 means DefaultRefreshMargin.
+	RefreshMargin time.Duration
+	// RefreshRetry is number of retries of failed refresh before the partition exits. 0 means DefaultRefreshRetry, negative value disables retry.
+	RefreshRetry int
+	// OnRefresh is called with result of each attempt to refresh active session. It's called from refreshing goroutine of each partition.
+	OnRefresh func(result RefreshResult)
+
+	// QueueSize is number of events buffered in memory between the stream and the consumer. 0 means StreamEventQueueSize.
+	QueueSize int
+	// Overflow is policy when the buffer is full. Default is OverflowBlock. OverflowDropOldest and OverflowDropNewest can not be used with Checkpoint because Ack() of following events commits offsets beyond dropped events, and then EventStream issues error.
+	Overflow OverflowPolicy
+	// SpillDir is directory to write events overflowing QueueSize to a temporary file. Overflow policy is applied only if writing the file fails. It relieves memory, but is not durable storage: the file is not synced and removed when the stream exits, then spilled events are lost by crash or restart. Use Checkpoint to resume the stream from unprocessed events.
+	SpillDir string
+	// Stats receives counters of the buffer if set.
+	Stats *EventStreamStats
+}
+
+func (x *EventStreamInput) refreshMargin() time.Duration {
+	if x.RefreshMargin > 0 {
+		return x.RefreshMargin
+	}
+	return DefaultRefreshMargin
+}
+
+func (x *EventStreamInput) refreshRetry() int {
+	switch {
+	case x.RefreshRetry < 0:
+		return 0
+	case x.RefreshRetry == 0:
+		return DefaultRefreshRetry
+	default:
+		return x.RefreshRetry
+	}
+}
+
+// refreshSession refreshes active session of feed with retry and notifies results to OnRefresh.
+func (x *SensorAPI) refreshSession(ctx context.Context, appID string, partition int, feed DataFeedResource, input *EventStreamInput) error {
+	ctx, span := x.client.tracer.Start(ctx, SpanNameDatafeedRefresh, SpanAttributes{
+		SpanKeyAppID:     appID,
+		SpanKeyPartition: partition,
+	})
+	err := x.refreshSessionWithRetry(ctx, appID, partition, feed, input, span)
+	span.End(err)
+	return err
+}
+
+func (x *SensorAPI) refreshSessionWithRetry(ctx context.Context, appID string, partition int, feed DataFeedResource, input *EventStreamInput, span Span) error {
+	retry := input.refreshRetry()
+	policy := RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Second * 30}
+
+	for attempt := 1; ; attempt++ {
+		span.SetAttributes(SpanAttributes{SpanKeyAttempts: attempt})
+		err := x.RefreshActiveStreamSession(ctx, appID, feed)
+		result := RefreshResult{
+			AppID:     appID,
+			Partition: partition,
+			Attempt:   attempt,
+			Error:     err,
+		}
+		if err == nil {
+			result.Next = time.Now().Add(feed.refreshInterval(input.refreshMargin()))
+		}
+		x.client.metrics.ObserveStreamRefresh(appID, partition, err)
+		if input.OnRefresh != nil {
+			input.OnRefresh(result)
+		}
+
+		if err == nil {
+			x.client.log.Info("Refresh DataFeedURL", LogFields{
+				LogKeyAppID:     appID,
+				LogKeyPartition: partition,
+				LogKeyURL:       feed.DataFeedURL,
+				"next":          result.Next,
+			})
+			return nil
+		}
+		if ctx.Err() != nil || attempt > retry {
+			return err
+		}
+
+		x.client.log.Warn("Fail to refresh DataFeedURL, retrying", LogFields{
+			LogKeyAppID:     appID,
+			LogKeyPartition: partition,
+			LogKeyAttempt:   attempt,
+			LogKeyError:     err,
+		})
+		if err := sleepWithContext(ctx, policy.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// startOffset returns starting offset of the partition. It returns -1 if no offset is specified.
+func (x *EventStreamInput) startOffset(ctx context.Context, appID string, partition int) (int, error) {
+	if offset, ok := x.Offsets[partition]; ok {
+		return offset, nil
+	}
+	if x.Checkpoint == nil {
+		return -1, nil
+	}
+
+	offset, found, err := x.Checkpoint.Load(ctx, appID, partition)
+	if err != nil {
+		return -1, errors.Wrap(err, "Fail to load checkpoint")
+	}
+	if !found {
+		return -1, nil
+	}
+	return offset + 1, nil
 }
 
 // EventStream generates channel of event stream
 func (x *SensorAPI) EventStream(input *EventStreamInput) chan *StreamQueue {
-	ch := make(chan *StreamQueue, StreamEventQueueSize)
+	return x.EventStreamWithContext(context.Background(), input)
+}
+
+// EventStreamWithContext is same with EventStream, but the stream is bound to ctx. When ctx is cancelled, all goroutines reading DataFeedURL exit, their HTTP bodies are closed and then the returned channel is closed.
+func (x *SensorAPI) EventStreamWithContext(ctx context.Context, input *EventStreamInput) chan *StreamQueue {
+	ch := make(chan *StreamQueue)
 	if input == nil {
 		input = &EventStreamInput{}
 	}
 
+	buf, err := newStreamBuffer(input, x.client.log)
+	if err != nil {
+		go func() {
+			defer close(ch)
+			sendStreamQueue(ctx, ch, &StreamQueue{Error: err})
+		}()
+		return ch
+	}
+
 	go func() {
 		defer close(ch)
-		wait := 0.0
+		buf.forward(ctx, ch)
+	}()
+
+	go func() {
+		defer buf.close()
 
 		appID := strings.Replace(uuid.New().String()[:23], "-", "", -1)
 		if input.AppID != nil {
@@ -241,92 +611,536 @@ func (x *SensorAPI) EventStream(input *EventStreamInput) chan *StreamQueue {
 		if input.Timeout != nil {
 			timeout = *input.Timeout
 		}
-		startTime := time.Now()
 
-		var output *EntitiesDatafeedOutput
-		var err error
+		output, err := x.waitDatafeed(ctx, appID, time.Second*time.Duration(timeout))
+		if err != nil {
+			if ctx.Err() == nil {
+				buf.push(ctx, &StreamQueue{Error: err})
+			}
+			return
+		}
 
-		for {
-			output, err = x.EntitiesDatafeed(&EntitiesDatafeedInput{
-				AppID: &appID,
-			})
-			if err != nil {
-				ch <- &StreamQueue{Error: err}
-				return
+		renewer := newDatafeedRenewer(x, appID)
+		var wg sync.WaitGroup
+		for _, feed := range output.Resources {
+			wg.Add(1)
+
+			go func(f DataFeedResource) {
+				defer wg.Done()
+				defer x.client.log.Info("Exit DataFeedURL", LogFields{
+					LogKeyAppID: appID,
+					LogKeyURL:   f.DataFeedURL,
+				})
+
+				x.streamPartition(ctx, appID, f, input, buf, renewer)
+			}(feed)
+		}
+		wg.Wait()
+
+	}()
+
+	return ch
+}
+
+// waitDatafeed calls EntitiesDatafeed until DataFeedURL is available. Because another DataFeedURL can not be retrieved if same AppID process is running.
+func (x *SensorAPI) waitDatafeed(ctx context.Context, appID string, timeout time.Duration) (*EntitiesDatafeedOutput, error) {
+	wait := 0.0
+	startTime := time.Now()
+
+	for {
+		output, err := x.EntitiesDatafeedWithContext(ctx, &EntitiesDatafeedInput{
+			AppID: &appID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(output.Resources) > 0 {
+			return output, nil
+		}
+
+		sec := time.Duration(math.Pow(2, wait))
+		x.client.log.Warn("No event stream info, retrying", LogFields{
+			LogKeyAppID: appID,
+			LogKeyWait:  time.Second * sec,
+		})
+		if err := sleepWithContext(ctx, time.Second*sec); err != nil {
+			return nil, err
+		}
+		if wait < 6 {
+			wait++
+		}
+
+		now := time.Now()
+		if now.Sub(startTime) > timeout {
+			return nil, fmt.Errorf("Fail to retrieve DataFeedURL, timeout")
+		}
+	}
+}
+
+// datafeedRenewer shares renewal of DataFeedURL among partitions of a stream. EntitiesDatafeed of an AppID returns nothing while any partition is connected, then renewal tears down connections of all partitions, and the result is handed to all partitions. Only one renewal runs at once.
+type datafeedRenewer struct {
+	api   *SensorAPI
+	appID string
+
+	mutex sync.Mutex
+	// generation is incremented by each successful renewal. Feeds of the initial EntitiesDatafeed are generation 0.
+	generation int
+	feeds      map[int]DataFeedResource // key is partition
+	call       *renewCall
+	// connected is number of partitions connected to DataFeedURL
+	connected int
+	// teardown is closed to disconnect all partitions when renewal starts
+	teardown chan struct{}
+	// idle is closed when connected becomes 0 during renewal
+	idle chan struct{}
+}
+
+// renewCall is in-flight renewal shared by partitions.
+type renewCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newDatafeedRenewer(api *SensorAPI, appID string) *datafeedRenewer {
+	return &datafeedRenewer{
+		api:      api,
+		appID:    appID,
+		feeds:    make(map[int]DataFeedResource),
+		teardown: make(chan struct{}),
+	}
+}
+
+// connect registers connection of a partition that has feed of generation. The returned channel is closed when the connection should be closed for renewal. ok is false if feeds newer than generation are available or renewal is in progress, then the partition should take them by sync before connecting.
+func (x *datafeedRenewer) connect(generation int) (teardown <-chan struct{}, ok bool) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.generation > generation || x.call != nil {
+		return nil, false
+	}
+	x.connected++
+	return x.teardown, true
+}
+
+// disconnect unregisters connection registered by connect.
+func (x *datafeedRenewer) disconnect() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.connected--
+	if x.connected == 0 && x.idle != nil {
+		close(x.idle)
+		x.idle = nil
+	}
+}
+
+// sync waits for renewal in progress and returns feed of partition renewed after generation. ok is false if no newer feed is available.
+func (x *datafeedRenewer) sync(ctx context.Context, partition, generation int) (feed DataFeedResource, newGeneration int, ok bool, err error) {
+	x.mutex.Lock()
+	call := x.call
+	x.mutex.Unlock()
+
+	if call != nil {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return DataFeedResource{}, generation, false, ctx.Err()
+		}
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.generation <= generation {
+		return DataFeedResource{}, generation, false, nil
+	}
+	feed, ok = x.feeds[partition]
+	if !ok {
+		return DataFeedResource{}, generation, false, fmt.Errorf("Partition %d is not found in renewed DataFeedURL", partition)
+	}
+	return feed, x.generation, true, nil
+}
+
+// renew returns new DataFeedURL and session token of partition. generation is of the feed the partition has. If another partition has already renewed feeds after generation, they are returned without calling EntitiesDatafeed. Otherwise it disconnects all partitions and calls EntitiesDatafeed.
+func (x *datafeedRenewer) renew(ctx context.Context, partition, generation int) (DataFeedResource, int, error) {
+	for {
+		x.mutex.Lock()
+		if x.generation > generation {
+			x.mutex.Unlock()
+			feed, newGeneration, _, err := x.sync(ctx, partition, generation)
+			return feed, newGeneration, err
+		}
+
+		call := x.call
+		if call != nil {
+			x.mutex.Unlock()
+			select {
+			case <-call.done:
+				if call.err != nil {
+					return DataFeedResource{}, generation, call.err
+				}
+				continue // Take the renewed feed
+			case <-ctx.Done():
+				return DataFeedResource{}, generation, ctx.Err()
 			}
+		}
 
-			if len(output.Resources) > 0 {
-				break
+		call = &renewCall{done: make(chan struct{})}
+		x.call = call
+		close(x.teardown)
+		idle := make(chan struct{})
+		if x.connected == 0 {
+			close(idle)
+		} else {
+			x.idle = idle
+		}
+		x.mutex.Unlock()
+
+		var output *EntitiesDatafeedOutput
+		err := x.waitDisconnected(ctx, idle)
+		if err == nil {
+			output, err = x.api.waitDatafeed(ctx, x.appID, StreamRenewTimeout)
+		}
+
+		x.mutex.Lock()
+		if err == nil {
+			x.generation++
+			x.feeds = make(map[int]DataFeedResource)
+			for _, feed := range output.Resources {
+				if p, err := feed.Partition(); err == nil {
+					x.feeds[p] = feed
+				}
 			}
+		}
+		x.call = nil
+		x.idle = nil
+		x.teardown = make(chan struct{})
+		x.mutex.Unlock()
+
+		call.err = err
+		close(call.done)
+		if err != nil {
+			return DataFeedResource{}, generation, err
+		}
+	}
+}
+
+// waitDisconnected waits until all partitions are disconnected. A partition blocked by full buffer may not notice teardown, then EntitiesDatafeed is called after StreamRenewTimeout anyway.
+func (x *datafeedRenewer) waitDisconnected(ctx context.Context, idle chan struct{}) error {
+	timer := time.NewTimer(StreamRenewTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+	case <-timer.C:
+		x.api.client.log.Warn("Partitions are not disconnected for renewal", LogFields{
+			LogKeyAppID: x.appID,
+		})
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// streamSession is current DataFeedResource of a partition shared by the reader of the stream and refresher of the session.
+type streamSession struct {
+	mutex sync.Mutex
+	feed  DataFeedResource
+	// replaced is closed when feed is replaced by renewal
+	replaced chan struct{}
+}
+
+func newStreamSession(feed DataFeedResource) *streamSession {
+	return &streamSession{feed: feed, replaced: make(chan struct{})}
+}
+
+func (x *streamSession) current() (DataFeedResource, <-chan struct{}) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.feed, x.replaced
+}
+
+func (x *streamSession) replace(feed DataFeedResource) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.feed = feed
+	close(x.replaced)
+	x.replaced = make(chan struct{})
+}
+
+// extend updates expiration of session token after active session of feed has been refreshed at now. It does nothing if the session has been replaced.
+func (x *streamSession) extend(feed DataFeedResource, now time.Time) {
+	if feed.RefreshActiveSessionInterval <= 0 {
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.feed.SessionToken.Token != feed.SessionToken.Token {
+		return
+	}
+	expiresAt := now.Add(time.Second * time.Duration(feed.RefreshActiveSessionInterval))
+	x.feed.SessionToken.Expiration = expiresAt.UTC().Format(time.RFC3339Nano)
+}
+
+// refreshLoop refreshes active session of the partition before expiration until ctx is done. Schedule of refresh restarts when the session is replaced. It returns error if refresh failed after retries.
+func (x *SensorAPI) refreshLoop(ctx context.Context, appID string, partition int, session *streamSession, input *EventStreamInput) error {
+	for {
+		feed, replaced := session.current()
+		timer := time.NewTimer(feed.refreshInterval(input.refreshMargin()))
+		select {
+		case <-timer.C:
+		case <-replaced:
+			timer.Stop()
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
 
-			sec := time.Duration(math.Pow(2, wait))
-			Logger.Warnf("No event stream info. Retry after %d sec...", sec)
-			time.Sleep(time.Second * sec)
-			if wait < 6 {
-				wait++
+		now := time.Now()
+		err := x.refreshSession(ctx, appID, partition, feed, input)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			select {
+			case <-replaced:
+				continue // Failure of old session does not matter
+			default:
+				return err
 			}
+		}
+		session.extend(feed, now)
+	}
+}
 
-			now := time.Now()
-			if now.Sub(startTime) > time.Second*time.Duration(timeout) {
-				ch <- &StreamQueue{Error: errors.Wrap(err, "Fail to retrieve DataFeedURL, timeout")}
-				return
+// isPermanentStreamError returns true if the stream can not be recovered by reconnection, e.g. HTTP 400 and 404. Authentication error is recovered by renewal of session and 429 is recovered by waiting.
+func isPermanentStreamError(err error) bool {
+	apiErr := AsAPIError(err)
+	if apiErr == nil || apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 {
+		return false
+	}
+	return !IsAuthError(err) && !IsRateLimited(err)
+}
+
+// streamPartition reads events of a partition and pushes them to buf until ctx is done. Active session is refreshed by another goroutine. If input.Reconnect is set, it reconnects to DataFeedURL when the stream is disconnected. DataFeedURL is renewed by renewer shared with other partitions only if the session token is rejected.
+func (x *SensorAPI) streamPartition(ctx context.Context, appID string, feed DataFeedResource, input *EventStreamInput, buf *streamBuffer, renewer *datafeedRenewer) {
+	partition, err := feed.Partition()
+	if err != nil {
+		buf.push(ctx, &StreamQueue{Error: err})
+		return
+	}
+
+	offset, err := input.startOffset(ctx, appID, partition)
+	if err != nil {
+		buf.push(ctx, &StreamQueue{Error: err, Partition: partition})
+		return
+	}
+	cp := &checkpointer{
+		store:     input.Checkpoint,
+		appID:     appID,
+		partition: partition,
+		committed: offset - 1,
+	}
+
+	// Failure of refresh stops the partition by cancel
+	ctx, cancel := context.WithCancel(ctx)
+	session := newStreamSession(feed)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := x.refreshLoop(ctx, appID, partition, session, input); err != nil {
+			buf.push(ctx, &StreamQueue{Error: errors.Wrap(err, "Fail to refresh active stream session"), Partition: partition})
+			cancel()
+		}
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	attempt := 0
+	renewals := 0   // renewals since the last received event
+	generation := 0 // generation of feed in renewer
+	for {
+		// reason is error that the stream is disconnected by
+		var reason error
+
+		teardown, ok := renewer.connect(generation)
+		if ok {
+			feed, _ = session.current()
+			reason = x.readPartition(ctx, appID, partition, feed, &offset, cp, buf, teardown, func() {
+				attempt, renewals = 0, 0
+			})
+			renewer.disconnect()
+		} else {
+			reason = errStreamRenewing
+		}
+
+		if ctx.Err() != nil || reason == nil {
+			return
+		}
+		if input.Reconnect == nil {
+			if reason != errStreamClosed {
+				buf.push(ctx, &StreamQueue{Error: reason, Partition: partition})
 			}
+			return
 		}
 
-		var wg sync.WaitGroup
-		for _, feed := range output.Resources {
-			wg.Add(1)
+		if isPermanentStreamError(reason) {
+			buf.push(ctx, &StreamQueue{
+				Error:     errors.Wrapf(reason, "Give up reconnecting to partition %d", partition),
+				Partition: partition,
+			})
+			return
+		}
 
-			go func(f DataFeedResource) {
-				defer wg.Done()
-				defer Logger.WithFields(logrus.Fields{
-					"appId": appID,
-					"url":   f.DataFeedURL,
-				}).Info("Exit DataFeedURL")
+		// Reconnect with current DataFeedURL unless session token is rejected. Failure of renewal is also retried with backoff as a reconnect attempt.
+		renew := IsAuthError(reason)
+		torn := reason == errStreamRenewing
+		newSession := false
+		for {
+			attempt++
+			if input.Reconnect.MaxAttempts > 0 && attempt > input.Reconnect.MaxAttempts {
+				buf.push(ctx, &StreamQueue{
+					Error:     errors.Wrapf(reason, "Give up reconnecting to partition %d", partition),
+					Partition: partition,
+				})
+				return
+			}
 
-				partition, err := f.Partition()
-				if err != nil {
-					ch <- &StreamQueue{Error: err}
+			// Renewal by other partition has been done, no need to wait
+			if !torn {
+				if err := sleepWithContext(ctx, input.Reconnect.backoff(attempt)); err != nil {
 					return
 				}
+			}
 
-				readCh := readEventStreamFeed(f)
-				ticker := time.NewTicker(time.Minute * 25)
-
-				for {
-					select {
-					case q := <-readCh:
-						if q == nil {
-							return
-						}
-						ch <- q
-						if q.Error != nil {
-							return
-						}
-
-					case <-ticker.C:
-						_, err = x.EntitiesDatafeedAction(&EntitiesDatafeedActionInput{
-							AppID:      &appID,
-							ActionName: String("refresh_active_stream_session"),
-							Partition:  &partition,
-						})
-						if err != nil {
-							ch <- &StreamQueue{Error: errors.Wrap(err, "fail to unmarshal event stream")}
-							return
-						}
-
-						Logger.WithFields(logrus.Fields{
-							"appId":     appID,
-							"partition": partition,
-							"url":       f.DataFeedURL,
-						}).Info("Refresh DataFeedURL")
+			var renewed DataFeedResource
+			var ok bool
+			if renew {
+				renewals++
+				if renewals > input.Reconnect.maxRenewals() {
+					buf.push(ctx, &StreamQueue{
+						Error:     errors.Wrapf(reason, "Give up renewing DataFeedURL of partition %d", partition),
+						Partition: partition,
+					})
+					return
+				}
+
+				renewed, generation, err = renewer.renew(ctx, partition, generation)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
 					}
+					reason = errors.Wrap(err, "Fail to renew DataFeedURL")
+					if isPermanentStreamError(err) {
+						buf.push(ctx, &StreamQueue{Error: reason, Partition: partition})
+						return
+					}
+					x.client.log.Warn("Fail to renew DataFeedURL, retrying", LogFields{
+						LogKeyAppID:     appID,
+						LogKeyPartition: partition,
+						LogKeyAttempt:   attempt,
+						LogKeyError:     err,
+					})
+					torn = false
+					continue
 				}
-			}(feed)
+				ok = true
+			} else {
+				// Take feed renewed by other partition because old session has been replaced
+				renewed, generation, ok, err = renewer.sync(ctx, partition, generation)
+				if err != nil {
+					if ctx.Err() == nil {
+						buf.push(ctx, &StreamQueue{Error: err, Partition: partition})
+					}
+					return
+				}
+			}
+
+			if ok {
+				// New session starts new refresh interval
+				session.replace(renewed)
+				newSession = true
+			}
+			break
 		}
-		wg.Wait()
 
+		x.client.metrics.ObserveStreamReconnect(appID, partition)
+		x.client.log.Warn("Reconnect DataFeedURL", LogFields{
+			LogKeyAppID:     appID,
+			LogKeyPartition: partition,
+			LogKeyAttempt:   attempt,
+			LogKeyOffset:    offset,
+			"reason":        reason,
+		})
+
+		notice := &StreamQueue{
+			Partition: partition,
+			Reconnect: &StreamReconnect{
+				Attempt:    attempt,
+				Reason:     reason,
+				Offset:     offset,
+				NewSession: newSession,
+			},
+		}
+		if !buf.push(ctx, notice) {
+			return
+		}
+	}
+}
+
+// readPartition connects to DataFeedURL of feed from *offset and pushes events to buf until the stream is disconnected. *offset is advanced by each event and received is called. It returns error that the stream is disconnected by, or nil if the stream should stop because buf is closed.
+func (x *SensorAPI) readPartition(ctx context.Context, appID string, partition int, feed DataFeedResource, offset *int, cp *checkpointer, buf *streamBuffer, teardown <-chan struct{}, received func()) error {
+	target := feed
+	if *offset >= 0 {
+		var err error
+		if target, err = feed.withOffset(*offset); err != nil {
+			buf.push(ctx, &StreamQueue{Error: err, Partition: partition})
+			return nil
+		}
+	}
+
+	// connCtx closes the stream connection when leaving
+	connCtx, closeConn := context.WithCancel(ctx)
+	readCh := x.readEventStreamFeed(connCtx, target)
+	defer func() {
+		closeConn()
+		// Wait for the connection to be closed before renewal
+		for range readCh {
+		}
 	}()
 
-	return ch
+	for {
+		select {
+		case q := <-readCh:
+			if q == nil {
+				return errStreamClosed
+			}
+			if q.Error != nil {
+				return q.Error
+			}
+
+			q.Partition = partition
+			if q.Meta != nil {
+				x.client.metrics.ObserveStreamEvent(appID, partition, q.Meta)
+				*offset = q.Meta.Offset + 1
+				eventOffset := q.Meta.Offset
+				q.ack = func() error {
+					return cp.commit(context.Background(), eventOffset)
+				}
+			}
+			received()
+			if !buf.push(ctx, q) {
+				return nil
+			}
+
+		case <-teardown:
+			return errStreamRenewing
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
@@ -1,12 +1,19 @@
 package gofalcon_test
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/k0kubun/pp"
 	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -24,7 +31,7 @@ func TestSensorAPI(t *testing.T) {
 		pp.Println(output.Resources)
 	}
 
-	ch := gofalcon.ReadEventStreamFeed(output.Resources[0])
+	ch := commonClient.Sensor.ReadEventStreamFeed(context.Background(), output.Resources[0])
 	require.NoError(t, err)
 
 	q := <-ch
@@ -61,6 +68,30 @@ func TestEventStream(t *testing.T) {
 	assert.Equal(t, 1, qCount)
 }
 
+func TestEventStreamWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := commonClient.Sensor.EventStreamWithContext(ctx, nil)
+	cancel()
+
+	closed := false
+	timeout := time.After(time.Second * 10)
+Loop:
+	for {
+		select {
+		case q, ok := <-ch:
+			if !ok {
+				closed = true
+				break Loop
+			}
+			assert.NoError(t, q.Error)
+		case <-timeout:
+			break Loop
+		}
+	}
+
+	assert.True(t, closed)
+}
+
 func TestMultipleEventStream(t *testing.T) {
 	qCount1 := 0
 	qCount2 := 0
@@ -92,3 +123,464 @@ Loop:
 	assert.NotEqual(t, 0, qCount1)
 	assert.NotEqual(t, 0, qCount2)
 }
+
+type eventStreamServer struct {
+	*httptest.Server
+	total      int      // number of events in partition 0
+	perConn    int      // number of events sent in a connection. 0 means unlimited
+	expiration string   // expiration of session token
+	interval   int      // refreshActiveSessionInterval in seconds
+	hold       bool     // keep stream connection open after sending events
+	refreshErr bool     // respond error to refresh request
+	eventTypes []string // event types assigned by offset in rotation. Default is DetectionSummaryEvent
+
+	mutex         sync.Mutex
+	offsets       []string // requested offsets of stream
+	datafeedCount int
+	refreshCount  int
+}
+
+// newEventStreamServer serves datafeed API and a stream of partition 0 that has events of offset 0 to total-1.
+func newEventStreamServer(t *testing.T, total, perConn int) *eventStreamServer {
+	s := &eventStreamServer{total: total, perConn: perConn, expiration: "2100-01-01T00:00:00Z", interval: 1800}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+
+		switch r.URL.Path {
+		case "/sensors/entities/datafeed/v2":
+			s.datafeedCount++
+			appID := r.URL.Query().Get("appId")
+			fmt.Fprintf(w, `{"resources":[{"dataFeedURL":"%s/sensors/entities/datafeed/v1/0?appId=%s","sessionToken":{"token":"xxx","expiration":"%s"},"refreshActiveSessionURL":"%s/sensors/entities/datafeed-actions/v1/0?appId=%s&action_name=refresh_active_stream_session","refreshActiveSessionInterval":%d}]}`,
+				s.URL, appID, s.expiration, s.URL, appID, s.interval)
+
+		case "/sensors/entities/datafeed-actions/v1/0":
+			s.refreshCount++
+			if s.refreshErr {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errors":[{"code":400,"message":"invalid session"}]}`)
+			} else {
+				fmt.Fprint(w, `{"meta":{"trace_id":"xxx"}}`)
+			}
+
+		case "/sensors/entities/datafeed/v1/0":
+			s.offsets = append(s.offsets, r.URL.Query().Get("offset"))
+			start, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			for i := start; i < s.total && (s.perConn == 0 || i < start+s.perConn); i++ {
+				eventType := "DetectionSummaryEvent"
+				if len(s.eventTypes) > 0 {
+					eventType = s.eventTypes[i%len(s.eventTypes)]
+				}
+				fmt.Fprintf(w, `{"metadata":{"customerIDString":"cid","offset":%d,"eventType":"%s","eventCreationTime":1604551845000},"event":{"DetectId":"ldt:%d"}}`+"\n", i, eventType, i)
+			}
+			if s.hold {
+				w.(http.Flusher).Flush()
+				s.mutex.Unlock()
+				<-r.Context().Done()
+				return
+			}
+		}
+		s.mutex.Unlock()
+	}))
+	return s
+}
+
+func (x *eventStreamServer) refreshes() int {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.refreshCount
+}
+
+func (x *eventStreamServer) requestedOffsets() []string {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return append([]string{}, x.offsets...)
+}
+
+func TestEventStreamCheckpoint(t *testing.T) {
+	server := newEventStreamServer(t, 5, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+	store := gofalcon.NewMemoryCheckpointStore()
+
+	readEvents := func(n int) []int {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var got []int
+		ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+			AppID:      gofalcon.String("myapp"),
+			Checkpoint: store,
+		})
+		for q := range ch {
+			require.NoError(t, q.Error)
+			assert.Equal(t, 0, q.Partition)
+			got = append(got, q.Meta.Offset)
+			require.NoError(t, q.Ack())
+			if len(got) == n {
+				break
+			}
+		}
+		return got
+	}
+
+	assert.Equal(t, []int{0, 1, 2}, readEvents(3))
+	assert.Equal(t, []int{3, 4}, readEvents(2))
+	assert.Equal(t, []string{"", "3"}, server.requestedOffsets())
+
+	offset, found, err := store.Load(context.Background(), "myapp", 0)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 4, offset)
+}
+
+func TestEventStreamOffsets(t *testing.T) {
+	server := newEventStreamServer(t, 5, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		Offsets: map[int]int{0: 2},
+	})
+	q := <-ch
+	require.NoError(t, q.Error)
+	assert.Equal(t, 2, q.Meta.Offset)
+	assert.Equal(t, []string{"2"}, server.requestedOffsets())
+}
+
+func TestEventStreamReconnect(t *testing.T) {
+	server := newEventStreamServer(t, 5, 2)
+	server.expiration = "2000-01-01T00:00:00Z" // Renewal is triggered by 401 and 403, not by expiration
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond},
+	})
+
+	var events []int
+	var reconnects []*gofalcon.StreamReconnect
+	for q := range ch {
+		require.NoError(t, q.Error)
+		if q.Reconnect != nil {
+			reconnects = append(reconnects, q.Reconnect)
+			continue
+		}
+		events = append(events, q.Meta.Offset)
+		if len(events) == 5 {
+			break
+		}
+	}
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, events)
+	assert.Equal(t, []string{"", "2", "4"}, server.requestedOffsets()[:3])
+	require.True(t, len(reconnects) >= 2)
+	assert.Equal(t, 2, reconnects[0].Offset)
+	assert.Equal(t, 1, reconnects[0].Attempt)
+	assert.False(t, reconnects[0].NewSession)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 1, server.datafeedCount)
+}
+
+func TestEventStreamReconnectMaxAttempts(t *testing.T) {
+	server := newEventStreamServer(t, 0, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ch := client.Sensor.EventStream(&gofalcon.EventStreamInput{
+		Reconnect: &gofalcon.ReconnectPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond},
+	})
+
+	var reconnects int
+	var lastErr error
+	for q := range ch {
+		if q.Reconnect != nil {
+			reconnects++
+		}
+		if q.Error != nil {
+			lastErr = q.Error
+		}
+	}
+
+	assert.Equal(t, 2, reconnects)
+	assert.Error(t, lastErr)
+	assert.Equal(t, 3, len(server.requestedOffsets()))
+}
+
+func TestEventStreamRefresh(t *testing.T) {
+	server := newEventStreamServer(t, 1, 0)
+	server.interval = 1
+	server.hold = true
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	results := make(chan gofalcon.RefreshResult, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		AppID:         gofalcon.String("myapp"),
+		RefreshMargin: time.Millisecond * 900, // Refresh every 100ms
+		OnRefresh:     func(result gofalcon.RefreshResult) { results <- result },
+	})
+
+	q := <-ch
+	require.NoError(t, q.Error)
+	assert.Equal(t, 0, q.Meta.Offset)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case result := <-results:
+			assert.NoError(t, result.Error)
+			assert.Equal(t, "myapp", result.AppID)
+			assert.Equal(t, 0, result.Partition)
+			assert.Equal(t, 1, result.Attempt)
+			assert.False(t, result.Next.IsZero())
+		case <-time.After(time.Second * 5):
+			require.Fail(t, "refresh is not called")
+		}
+	}
+	assert.True(t, server.refreshes() >= 2)
+}
+
+func TestEventStreamRefreshError(t *testing.T) {
+	server := newEventStreamServer(t, 0, 0)
+	server.interval = 1
+	server.hold = true
+	server.refreshErr = true
+	defer server.Close()
+
+	client := gofalcon.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{MaxAttempts: 1}))
+	client.Endpoint = server.URL
+
+	var attempts []int
+	ch := client.Sensor.EventStream(&gofalcon.EventStreamInput{
+		RefreshMargin: time.Millisecond * 900,
+		RefreshRetry:  1,
+		OnRefresh:     func(result gofalcon.RefreshResult) { attempts = append(attempts, result.Attempt) },
+	})
+
+	q := <-ch
+	require.Error(t, q.Error)
+	apiErr := gofalcon.AsAPIError(q.Error)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, []int{1, 2}, attempts)
+	assert.Equal(t, 2, server.refreshes())
+}
+
+func TestEventStreamRenewSharedByPartitions(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithPartitions(4))
+	defer server.Close()
+	client, err := server.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{MaxAttempts: 1}))
+	require.NoError(t, err)
+
+	seed := func() {
+		for p := 0; p < 4; p++ {
+			server.SeedEvents(p, 1)
+		}
+	}
+	readEvents := func(ch chan *gofalcon.StreamQueue) map[int]int {
+		received := map[int]int{}
+		for len(received) < 4 {
+			select {
+			case q := <-ch:
+				require.NotNil(t, q)
+				require.NoError(t, q.Error)
+				if q.Meta != nil {
+					received[q.Partition] = q.Meta.Offset
+				}
+			case <-time.After(time.Second * 5):
+				require.Fail(t, "events are not received")
+			}
+		}
+		return received
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seed()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		AppID:     gofalcon.String("myapp"),
+		Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 10},
+	})
+	assert.Equal(t, map[int]int{0: 0, 1: 0, 2: 0, 3: 0}, readEvents(ch))
+
+	// First renewal fails and is retried as a reconnect attempt
+	server.InjectStatus("/sensors/entities/datafeed/v2", http.StatusServiceUnavailable, 1)
+	server.ExpireSessions()
+	server.DropOpenStreams()
+	seed()
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1, 3: 1}, readEvents(ch))
+
+	datafeedCount := 0
+	for _, req := range server.Requests() {
+		if req.Path == "/sensors/entities/datafeed/v2" {
+			datafeedCount++
+		}
+	}
+	// Initial one, failed renewal and successful renewal
+	assert.Equal(t, 3, datafeedCount)
+}
+
+func TestEventStreamRenewTearsDownPartitions(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithPartitions(4))
+	defer server.Close()
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for p := 0; p < 4; p++ {
+		server.SeedEvents(p, 1)
+	}
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		AppID:     gofalcon.String("myapp"),
+		Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 10},
+	})
+
+	read := func(n int) (map[int]int, map[int]bool) {
+		received := map[int]int{}
+		renewed := map[int]bool{}
+		for len(received) < n {
+			select {
+			case q := <-ch:
+				require.NotNil(t, q)
+				require.NoError(t, q.Error)
+				if q.Reconnect != nil && q.Reconnect.NewSession {
+					renewed[q.Partition] = true
+				}
+				if q.Meta != nil {
+					received[q.Partition] = q.Meta.Offset
+				}
+			case <-time.After(time.Second * 5):
+				require.Fail(t, "events are not received")
+			}
+		}
+		return received, renewed
+	}
+	read(4)
+
+	// Only partition 2 is disconnected and rejected, but EntitiesDatafeed issues nothing until other partitions are disconnected
+	server.ExpireSessions()
+	server.DropOpenStreams(2)
+	for p := 0; p < 4; p++ {
+		server.SeedEvents(p, 1)
+	}
+	received, renewed := read(4)
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1, 3: 1}, received)
+	assert.Equal(t, map[int]bool{0: true, 1: true, 2: true, 3: true}, renewed)
+
+	datafeedCount := 0
+	for _, req := range server.Requests() {
+		if req.Path == "/sensors/entities/datafeed/v2" {
+			datafeedCount++
+		}
+	}
+	// Initial one and renewal. Renewal may be retried until server notices closed connections
+	assert.True(t, datafeedCount >= 2)
+}
+
+func TestEventStreamRenewLimit(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	server.InjectStatus("/sensors/entities/datafeed/v1/", http.StatusUnauthorized, 0)
+	ch := client.Sensor.EventStream(&gofalcon.EventStreamInput{
+		Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond, MaxRenewals: 2},
+	})
+
+	var errs []error
+	for q := range ch {
+		if q.Error != nil {
+			errs = append(errs, q.Error)
+		}
+	}
+	require.Equal(t, 1, len(errs))
+	assert.True(t, gofalcon.IsAuthError(errs[0]))
+
+	datafeedCount := 0
+	for _, req := range server.Requests() {
+		if req.Path == "/sensors/entities/datafeed/v2" {
+			datafeedCount++
+		}
+	}
+	// Initial one and 2 renewals
+	assert.Equal(t, 3, datafeedCount)
+}
+
+func TestEventStreamRefreshDuringReconnect(t *testing.T) {
+	server := newEventStreamServer(t, 0, 0)
+	server.interval = 1
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		RefreshMargin: time.Millisecond * 900, // Refresh every 100ms
+		Reconnect:     &gofalcon.ReconnectPolicy{MinBackoff: time.Minute},
+	})
+	go func() {
+		for range ch {
+		}
+	}()
+
+	// Stream is closed immediately and waits for reconnect, but active session is kept
+	assert.Eventually(t, func() bool {
+		return server.refreshes() >= 2
+	}, time.Second*5, time.Millisecond*50)
+	assert.Equal(t, 1, len(server.requestedOffsets()))
+}
+
+func TestExtendedSessionToken(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	feed := gofalcon.DataFeedResource{
+		RefreshActiveSessionInterval: 1800,
+		SessionToken:                 gofalcon.DataFeedSessionToken{Token: "xxx", Expiration: "2020-01-01T00:10:00Z"},
+	}
+	token := gofalcon.ExtendedSessionToken(feed, now)
+	expiresAt, err := token.ExpiresAt()
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute*30), expiresAt)
+}
+
+func TestEventStreamReconnectPermanentError(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	server.InjectStatus("/sensors/entities/datafeed/v1/", http.StatusBadRequest, 0)
+	ch := client.Sensor.EventStream(&gofalcon.EventStreamInput{
+		Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond},
+	})
+
+	var errs []error
+	for q := range ch {
+		require.Nil(t, q.Reconnect)
+		if q.Error != nil {
+			errs = append(errs, q.Error)
+		}
+	}
+	require.Equal(t, 1, len(errs))
+	assert.Equal(t, http.StatusBadRequest, gofalcon.AsAPIError(errs[0]).StatusCode)
+}
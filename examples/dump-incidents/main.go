@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"sync"
 
 	"github.com/k0kubun/pp"
 	"github.com/m-mizutani/gofalcon"
@@ -30,9 +32,10 @@ func main() {
 		log.Fatal("Fail oauth2", err)
 	}
 
-	var incBody, bhvBody struct {
+	type idsBody struct {
 		IDs []string `json:"ids"`
 	}
+	var incBody idsBody
 
 	// ---------- Incident -----------
 	incBody.IDs = []string{incidentID}
@@ -55,41 +58,40 @@ func main() {
 	fmt.Println("------- Incident entry ----------")
 	pp.Println(incident)
 
-	// -------- Behavior ------------
-	var behaviour gofalcon.Response
+	// -------- Behaviors ------------
 	qs := url.Values{}
 	qs.Add("filter", fmt.Sprintf("incident_id:\"%s\"", incidentID))
-	queryRequest := gofalcon.Request{
+	p := gofalcon.NewRequestPaginator(client, gofalcon.OffsetPagination, gofalcon.Request{
 		Method:      "GET",
 		Path:        "/incidents/queries/behaviors/v1",
 		QueryString: qs,
-	}
-	if err := client.SendRequest(queryRequest, &behaviour); err != nil {
-		log.Fatal("Fail request to incidents/queries/behaviors/v1: ", err)
-	}
-	fmt.Println("------- Behavior ----------")
-	pp.Println(behaviour)
+	})
 
-	// -------- Behaviors ------------
-	for _, v := range behaviour.Resources {
-		bhvBody.IDs = append(bhvBody.IDs, v.(string))
-	}
-	raw, err = json.Marshal(bhvBody)
+	fmt.Println("------- Behaviors ----------")
+	var mutex sync.Mutex
+	err = gofalcon.Hydrate(context.Background(), p, nil, func(ctx context.Context, ids []string) error {
+		raw, err := json.Marshal(idsBody{IDs: ids})
+		if err != nil {
+			return err
+		}
+
+		bhvsRequest := gofalcon.Request{
+			Method: "POST",
+			Path:   "/incidents/entities/behaviors/GET/v1",
+			Body:   bytes.NewReader(raw),
+		}
+
+		var behaviours gofalcon.Response
+		if err := client.SendRequestWithContext(ctx, bhvsRequest, &behaviours); err != nil {
+			return err
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		pp.Println(behaviours)
+		return nil
+	})
 	if err != nil {
-		log.Fatal("Fail to marshal body", err)
+		log.Fatal("Fail request to incidents behaviors: ", err)
 	}
-
-	bhvsRequest := gofalcon.Request{
-		Method: "POST",
-		Path:   "/incidents/entities/behaviors/GET/v1",
-		Body:   bytes.NewReader(raw),
-	}
-
-	var behaviours gofalcon.Response
-	if err := client.SendRequest(bhvsRequest, &behaviours); err != nil {
-		log.Fatal("Fail request to incidents/entities/behaviors/GET/v1: ", err)
-	}
-
-	fmt.Println("------- Behaviors ----------")
-	pp.Println(behaviours)
 }
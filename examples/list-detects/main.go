@@ -1,8 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"log"
 	"os"
 
@@ -21,41 +20,12 @@ func main() {
 		log.Fatal("Fail oauth2: ", err)
 	}
 
-	// Get list of detection IDs
-	queryrReq := gofalcon.Request{
-		Method: "GET",
-		Path:   "/detects/queries/detects/v1",
-	}
-
-	var queryResp gofalcon.Response
-	if err := client.SendRequest(queryrReq, &queryResp); err != nil {
-		log.Fatal("Fail request: ", err)
-	}
-
-	// Get summaries of detections
-	var body struct {
-		IDs []string `json:"ids"`
-	}
-	for _, resource := range queryResp.Resources {
-		body.IDs = append(body.IDs, resource.(string))
-	}
-	rawBody, err := json.Marshal(body)
-	if err != nil {
-		log.Fatal("Fail marshal: ", err)
-	}
-
-	summaryReq := gofalcon.Request{
-		Method: "POST",
-		Path:   "/detects/entities/summaries/GET/v1",
-		Body:   bytes.NewReader(rawBody),
-	}
-
-	var summaryResp gofalcon.Response
-	if err := client.SendRequest(summaryReq, &summaryResp); err != nil {
-		log.Fatal("Fail request: ", err)
-	}
-
-	for _, resource := range summaryResp.Resources {
-		pp.Println(resource)
+	// Walk all detection IDs and get summaries of them
+	p := client.Detection.QueriesDetectsPaginator(&gofalcon.QueriesDetectsInput{})
+	for q := range client.Detection.FetchSummaries(context.Background(), p, nil) {
+		if q.Error != nil {
+			log.Fatal("Fail request: ", q.Error)
+		}
+		pp.Println(q.Detection)
 	}
 }
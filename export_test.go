@@ -1,5 +1,38 @@
 package gofalcon
 
-var (
-	ReadEventStreamFeed = readEventStreamFeed
+import (
+	"context"
+	"time"
 )
+
+// SetCloudEndpoint replaces endpoint of cloud for test and returns function to restore it.
+func SetCloudEndpoint(cloud Cloud, endpoint string) func() {
+	orig := cloudEndpoints[cloud]
+	cloudEndpoints[cloud] = endpoint
+	return func() { cloudEndpoints[cloud] = orig }
+}
+
+func (x *SensorAPI) ReadEventStreamFeed(ctx context.Context, feed DataFeedResource) chan *StreamQueue {
+	return x.readEventStreamFeed(ctx, feed)
+}
+
+// ExtendedSessionToken returns session token of feed after its active session is refreshed at now.
+func ExtendedSessionToken(feed DataFeedResource, now time.Time) DataFeedSessionToken {
+	session := newStreamSession(feed)
+	session.extend(feed, now)
+	extended, _ := session.current()
+	return extended.SessionToken
+}
+
+func WriteLog(client *Client, level LogLevel, msg string, fields LogFields) {
+	client.log.write(level, msg, fields)
+}
+
+func (x RetryPolicy) Backoff(attempt int) time.Duration {
+	return x.backoff(attempt)
+}
+
+// SetMaxOffset replaces offset limit of Paginator used by the watcher.
+func (x *DetectionWatcher) SetMaxOffset(n int) {
+	x.maxOffset = n
+}
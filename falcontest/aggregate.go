@@ -0,0 +1,355 @@
+package falcontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+)
+
+// DefaultAggregationSize is number of buckets of terms aggregation if size is not specified.
+const DefaultAggregationSize = 10
+
+type aggregationQuery struct {
+	Name          string                   `json:"name"`
+	Type          gofalcon.AggregationType `json:"type"`
+	Field         string                   `json:"field"`
+	Size          int                      `json:"size"`
+	MinDocCount   *int                     `json:"min_doc_count"`
+	Missing       string                   `json:"missing"`
+	Interval      string                   `json:"interval"`
+	TimeZone      string                   `json:"time_zone"`
+	Ranges        []gofalcon.RangeSpec     `json:"ranges"`
+	DateRanges    []gofalcon.DateRangeSpec `json:"date_ranges"`
+	SubAggregates []aggregationQuery       `json:"sub_aggregates"`
+}
+
+// handleDetectAggregates evaluates aggregations over detections. filter, q and sort are not evaluated, and date_range accepts only RFC3339 boundaries. Field is a JSON path of DetectionResources, e.g. "behaviors.tactic".
+func (x *Server) handleDetectAggregates(w http.ResponseWriter, r *http.Request) {
+	var queries []aggregationQuery
+	if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	x.mutex.Lock()
+	raw, err := json.Marshal(x.detects)
+	x.mutex.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var docs []interface{}
+	if err := json.Unmarshal(raw, &docs); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	results := []gofalcon.AggregationResult{}
+	for _, q := range queries {
+		result, err := aggregate(docs, q)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		results = append(results, *result)
+	}
+	writeResources(w, results, nil)
+}
+
+func aggregate(docs []interface{}, q aggregationQuery) (*gofalcon.AggregationResult, error) {
+	if q.Name == "" || q.Field == "" {
+		return nil, fmt.Errorf("name and field are required")
+	}
+
+	var result *gofalcon.AggregationResult
+	var groups [][]interface{}
+	var err error
+
+	switch q.Type {
+	case gofalcon.AggregationTerms:
+		result, groups = aggregateTerms(docs, q)
+	case gofalcon.AggregationCardinality:
+		result = aggregateCardinality(docs, q)
+	case gofalcon.AggregationRange:
+		result, groups = aggregateRange(docs, q)
+	case gofalcon.AggregationDateRange:
+		result, groups, err = aggregateDateRange(docs, q)
+	case gofalcon.AggregationDateHistogram:
+		result, groups, err = aggregateDateHistogram(docs, q)
+	default:
+		err = fmt.Errorf("invalid aggregation type: %q", q.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range groups {
+		for _, sub := range q.SubAggregates {
+			subResult, err := aggregate(groups[i], sub)
+			if err != nil {
+				return nil, err
+			}
+			result.Buckets[i].SubAggregates = append(result.Buckets[i].SubAggregates, *subResult)
+		}
+	}
+	return result, nil
+}
+
+func aggregateTerms(docs []interface{}, q aggregationQuery) (*gofalcon.AggregationResult, [][]interface{}) {
+	groups := map[string][]interface{}{}
+	for _, doc := range docs {
+		keys := map[string]bool{}
+		for _, v := range fieldValues(doc, q.Field) {
+			keys[termKey(v)] = true
+		}
+		if len(keys) == 0 && q.Missing != "" {
+			keys[q.Missing] = true
+		}
+		for key := range keys {
+			groups[key] = append(groups[key], doc)
+		}
+	}
+
+	var keys []string
+	for key, group := range groups {
+		if q.MinDocCount == nil || len(group) >= *q.MinDocCount {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	size := q.Size
+	if size <= 0 {
+		size = DefaultAggregationSize
+	}
+
+	result := &gofalcon.AggregationResult{Name: q.Name, Buckets: []gofalcon.AggregationBucket{}}
+	var matched [][]interface{}
+	for i, key := range keys {
+		if i >= size {
+			result.SumOtherDocCount += int64(len(groups[key]))
+			continue
+		}
+		result.Buckets = append(result.Buckets, gofalcon.AggregationBucket{
+			Label: key,
+			Count: int64(len(groups[key])),
+		})
+		matched = append(matched, groups[key])
+	}
+	return result, matched
+}
+
+func aggregateCardinality(docs []interface{}, q aggregationQuery) *gofalcon.AggregationResult {
+	keys := map[string]bool{}
+	for _, doc := range docs {
+		for _, v := range fieldValues(doc, q.Field) {
+			keys[termKey(v)] = true
+		}
+	}
+	return &gofalcon.AggregationResult{
+		Name:    q.Name,
+		Buckets: []gofalcon.AggregationBucket{{Count: int64(len(keys))}},
+	}
+}
+
+func aggregateRange(docs []interface{}, q aggregationQuery) (*gofalcon.AggregationResult, [][]interface{}) {
+	result := &gofalcon.AggregationResult{Name: q.Name, Buckets: []gofalcon.AggregationBucket{}}
+	var groups [][]interface{}
+	for _, spec := range q.Ranges {
+		group := filterDocs(docs, q.Field, func(v interface{}) bool {
+			n, ok := v.(float64)
+			return ok && spec.From <= n && n < spec.To
+		})
+		result.Buckets = append(result.Buckets, gofalcon.AggregationBucket{
+			Label: fmt.Sprintf("%g-%g", spec.From, spec.To),
+			From:  spec.From,
+			To:    spec.To,
+			Count: int64(len(group)),
+		})
+		groups = append(groups, group)
+	}
+	return result, groups
+}
+
+func aggregateDateRange(docs []interface{}, q aggregationQuery) (*gofalcon.AggregationResult, [][]interface{}, error) {
+	result := &gofalcon.AggregationResult{Name: q.Name, Buckets: []gofalcon.AggregationBucket{}}
+	var groups [][]interface{}
+	for _, spec := range q.DateRanges {
+		from, err := parseBoundary(spec.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		to, err := parseBoundary(spec.To)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		group := filterDocs(docs, q.Field, func(v interface{}) bool {
+			t, ok := timeValue(v)
+			return ok && (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+		})
+		result.Buckets = append(result.Buckets, gofalcon.AggregationBucket{
+			StringFrom: spec.From,
+			StringTo:   spec.To,
+			Count:      int64(len(group)),
+		})
+		groups = append(groups, group)
+	}
+	return result, groups, nil
+}
+
+func aggregateDateHistogram(docs []interface{}, q aggregationQuery) (*gofalcon.AggregationResult, [][]interface{}, error) {
+	loc := time.UTC
+	if q.TimeZone != "" {
+		l, err := time.LoadLocation(q.TimeZone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid time_zone: %s", q.TimeZone)
+		}
+		loc = l
+	}
+
+	groups := map[time.Time][]interface{}{}
+	for _, doc := range docs {
+		keys := map[time.Time]bool{}
+		for _, v := range fieldValues(doc, q.Field) {
+			t, ok := timeValue(v)
+			if !ok {
+				continue
+			}
+			key, err := truncateTime(t.In(loc), q.Interval)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys[key] = true
+		}
+		for key := range keys {
+			groups[key] = append(groups[key], doc)
+		}
+	}
+
+	var keys []time.Time
+	for key, group := range groups {
+		if q.MinDocCount == nil || len(group) >= *q.MinDocCount {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Before(keys[j]) })
+
+	result := &gofalcon.AggregationResult{Name: q.Name, Buckets: []gofalcon.AggregationBucket{}}
+	var matched [][]interface{}
+	for _, key := range keys {
+		result.Buckets = append(result.Buckets, gofalcon.AggregationBucket{
+			Label:       float64(key.UnixNano() / int64(time.Millisecond)),
+			KeyAsString: key.Format(time.RFC3339),
+			Count:       int64(len(groups[key])),
+		})
+		matched = append(matched, groups[key])
+	}
+	return result, matched, nil
+}
+
+func truncateTime(t time.Time, interval string) (time.Time, error) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch interval {
+	case "minute":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()), nil
+	case "day":
+		return day, nil
+	case "week":
+		return day.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7)), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), nil
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location()), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid interval: %q", interval)
+}
+
+func parseBoundary(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date range: %s", s)
+	}
+	return t, nil
+}
+
+// fieldValues returns all values of dot separated path in doc. Arrays on the path are flattened.
+func fieldValues(doc interface{}, path string) []interface{} {
+	values := []interface{}{doc}
+	for _, key := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, v := range flatten(values) {
+			if m, ok := v.(map[string]interface{}); ok {
+				if child, ok := m[key]; ok && child != nil {
+					next = append(next, child)
+				}
+			}
+		}
+		values = next
+	}
+	return flatten(values)
+}
+
+func flatten(values []interface{}) []interface{} {
+	var flat []interface{}
+	for _, v := range values {
+		if arr, ok := v.([]interface{}); ok {
+			flat = append(flat, flatten(arr)...)
+		} else {
+			flat = append(flat, v)
+		}
+	}
+	return flat
+}
+
+func filterDocs(docs []interface{}, field string, match func(v interface{}) bool) []interface{} {
+	matched := []interface{}{}
+	for _, doc := range docs {
+		for _, v := range fieldValues(doc, field) {
+			if match(v) {
+				matched = append(matched, doc)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func termKey(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func timeValue(v interface{}) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,151 @@
+package falcontest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const streamPath = "/sensors/entities/datafeed/v1/"
+
+// Fault replaces response of requests matched by Method and Path.
+type Fault struct {
+	// Method matches HTTP method of request. Empty matches any method.
+	Method string
+	// Path matches prefix of request path, e.g. "/detects/". Empty matches any path.
+	Path string
+	// Times is number of requests affected by the fault. 0 means unlimited until ClearFaults.
+	Times int
+
+	// Status is HTTP status code of error response.
+	Status int
+	// RetryAfter sets X-RateLimit-Remaining: 0 and X-RateLimit-RetryAfter headers to the response.
+	RetryAfter time.Duration
+	// Drop closes connection in the middle of response. For event stream, the connection is closed after DropAfter events have been sent.
+	Drop      bool
+	DropAfter int
+
+	hits int
+}
+
+func (x *Fault) match(r *http.Request) bool {
+	if x.Times > 0 && x.hits >= x.Times {
+		return false
+	}
+	if x.Method != "" && x.Method != r.Method {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, x.Path)
+}
+
+// Inject adds fault. Faults are evaluated in order of injection, and the first matched fault is applied.
+func (x *Server) Inject(fault Fault) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.faults = append(x.faults, &fault)
+}
+
+// InjectStatus makes next times requests of path fail with status. times 0 means unlimited.
+func (x *Server) InjectStatus(path string, status, times int) {
+	x.Inject(Fault{Path: path, Status: status, Times: times})
+}
+
+// InjectRateLimit makes next times requests of path fail with 429 and rate limit headers indicating retry after retryAfter.
+func (x *Server) InjectRateLimit(path string, times int, retryAfter time.Duration) {
+	x.Inject(Fault{Path: path, Status: http.StatusTooManyRequests, RetryAfter: retryAfter, Times: times})
+}
+
+// DropConnections closes connections of next times requests of path in the middle of response.
+func (x *Server) DropConnections(path string, times int) {
+	x.Inject(Fault{Path: path, Drop: true, Times: times})
+}
+
+// DropStream closes next times event stream connections after afterEvents events have been sent.
+func (x *Server) DropStream(afterEvents, times int) {
+	x.Inject(Fault{Path: streamPath, Drop: true, DropAfter: afterEvents, Times: times})
+}
+
+// ClearFaults removes all injected faults.
+func (x *Server) ClearFaults() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.faults = nil
+}
+
+// intercept records requests and applies faults before the handler.
+func (x *Server) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x.mutex.Lock()
+		x.requests = append(x.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+		})
+
+		var fault *Fault
+		for _, f := range x.faults {
+			// Dropping stream after events is applied by handleStream
+			if f.Drop && strings.HasPrefix(r.URL.Path, streamPath) {
+				continue
+			}
+			if f.match(r) {
+				f.hits++
+				fault = f
+				break
+			}
+		}
+		x.mutex.Unlock()
+
+		if fault == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if fault.Drop {
+			// Connection closed before response is retried by http.Transport. Then the connection is closed in the middle of response body.
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"meta":`))
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			dropConnection(w)
+			return
+		}
+
+		if fault.RetryAfter > 0 {
+			w.Header().Set("X-RateLimit-Limit", "6000")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-RetryAfter", fmt.Sprintf("%d", time.Now().Add(fault.RetryAfter).Unix()))
+		}
+		writeError(w, fault.Status, fmt.Sprintf("falcontest: injected %d", fault.Status))
+	})
+}
+
+// streamFault returns number of events to be sent before dropping the stream connection. It returns -1 if the stream should not be dropped.
+func (x *Server) streamFault(r *http.Request) int {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	for _, f := range x.faults {
+		if f.Drop && f.match(r) {
+			f.hits++
+			return f.DropAfter
+		}
+	}
+	return -1
+}
+
+// dropConnection closes underlying connection abruptly. Data written to w must be flushed before.
+func dropConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
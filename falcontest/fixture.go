@@ -0,0 +1,287 @@
+package falcontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+)
+
+// AddDetections adds detections returned by detects API. DetectionID is required.
+func (x *Server) AddDetections(detects ...gofalcon.DetectionResources) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.detects = append(x.detects, detects...)
+}
+
+// AddDevices adds devices returned by devices API. DeviceID is required.
+func (x *Server) AddDevices(devices ...gofalcon.DeviceResource) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.devices = append(x.devices, devices...)
+}
+
+// AddBehaviors appends behaviors to the detection, and updates LastBehavior and DateUpdated. It returns false if the detection is not found.
+func (x *Server) AddBehaviors(detectionID string, behaviors ...gofalcon.DetectionBehavior) bool {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	for i := range x.detects {
+		d := &x.detects[i]
+		if d.DetectionID != detectionID {
+			continue
+		}
+		d.Behaviors = append(d.Behaviors, behaviors...)
+		for _, b := range behaviors {
+			if b.Timestamp.After(d.LastBehavior) {
+				d.LastBehavior = b.Timestamp
+			}
+		}
+		d.DateUpdated = time.Now().UTC()
+		return true
+	}
+	return false
+}
+
+// SeedDetections generates n detections by seed of WithSeed and returns their IDs.
+func (x *Server) SeedDetections(n int) []string {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	statuses := []string{"new", "in_progress", "true_positive", "false_positive", "ignored"}
+	base := time.Date(2020, 11, 5, 0, 0, 0, 0, time.UTC)
+
+	var ids []string
+	for i := 0; i < n; i++ {
+		aid := fmt.Sprintf("%032x", x.rand.Int63())
+		id := fmt.Sprintf("ldt:%s:%d", aid, x.rand.Int63())
+		created := base.Add(time.Duration(x.rand.Intn(86400*30)) * time.Second)
+		severity := x.rand.Intn(100)
+
+		x.detects = append(x.detects, gofalcon.DetectionResources{
+			Cid:                    DefaultCID,
+			DetectionID:            id,
+			CreatedTimestamp:       created,
+			FirstBehavior:          created,
+			LastBehavior:           created.Add(time.Minute),
+			DateUpdated:            created.Add(time.Minute),
+			MaxConfidence:          x.rand.Intn(100),
+			MaxSeverity:            severity,
+			MaxSeverityDisplayname: severityName(severity),
+			Status:                 statuses[x.rand.Intn(len(statuses))],
+			Device: gofalcon.DeviceResource{
+				Cid:      DefaultCID,
+				DeviceID: aid,
+				Hostname: fmt.Sprintf("host-%d", i),
+			},
+		})
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// SeedDevices generates n devices by seed of WithSeed and returns their IDs.
+func (x *Server) SeedDevices(n int) []string {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	platforms := []string{"Windows", "Mac", "Linux"}
+
+	var ids []string
+	for i := 0; i < n; i++ {
+		aid := fmt.Sprintf("%032x", x.rand.Int63())
+		x.devices = append(x.devices, gofalcon.DeviceResource{
+			Cid:          DefaultCID,
+			DeviceID:     aid,
+			Hostname:     fmt.Sprintf("host-%d", i),
+			LocalIP:      fmt.Sprintf("10.0.%d.%d", x.rand.Intn(256), x.rand.Intn(256)),
+			MacAddress:   fmt.Sprintf("02-00-00-%02x-%02x-%02x", x.rand.Intn(256), x.rand.Intn(256), x.rand.Intn(256)),
+			PlatformName: platforms[x.rand.Intn(len(platforms))],
+			Status:       "normal",
+		})
+		ids = append(ids, aid)
+	}
+	return ids
+}
+
+func severityName(severity int) string {
+	switch {
+	case severity >= 80:
+		return "Critical"
+	case severity >= 60:
+		return "High"
+	case severity >= 40:
+		return "Medium"
+	case severity >= 20:
+		return "Low"
+	default:
+		return "Informational"
+	}
+}
+
+// page returns offset and limit of the request.
+func page(r *http.Request, defaultLimit int) (int, int) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	return offset, limit
+}
+
+func slice(total, offset, limit int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+// handleQueryDetects returns IDs of detections. filter, sort and q parameters are not evaluated.
+func (x *Server) handleQueryDetects(w http.ResponseWriter, r *http.Request) {
+	offset, limit := page(r, 9999)
+
+	x.mutex.Lock()
+	start, end := slice(len(x.detects), offset, limit)
+	ids := []string{}
+	for _, d := range x.detects[start:end] {
+		ids = append(ids, d.DetectionID)
+	}
+	total := len(x.detects)
+	x.mutex.Unlock()
+
+	writeResources(w, ids, &gofalcon.Pagenation{Offset: offset, Limit: limit, Total: total})
+}
+
+func (x *Server) handleDetectSummaries(w http.ResponseWriter, r *http.Request) {
+	var input gofalcon.EntitySummariesInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(input.ID) > gofalcon.EntitySummariesMaxIDs {
+		writeError(w, http.StatusBadRequest, "too many ids")
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	resources := []gofalcon.DetectionResources{}
+	for _, id := range input.ID {
+		for _, d := range x.detects {
+			if d.DetectionID == id {
+				resources = append(resources, d)
+			}
+		}
+	}
+	writeResources(w, resources, nil)
+}
+
+// handleUpdateDetects updates status, assignee and visibility of detections. Unknown IDs are reported by errors with the ID and status 404, and other detections in the request are updated.
+func (x *Server) handleUpdateDetects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var input struct {
+		IDs            []string `json:"ids"`
+		Status         string   `json:"status"`
+		AssignedToUUID *string  `json:"assigned_to_uuid"`
+		ShowInUI       *bool    `json:"show_in_ui"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(input.IDs) == 0 || len(input.IDs) > gofalcon.UpdateDetectsMaxIDs {
+		writeError(w, http.StatusBadRequest, "invalid number of ids")
+		return
+	}
+	if input.Status != "" && !gofalcon.DetectStatus(input.Status).Valid() {
+		writeError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	var notFound []gofalcon.ServerError
+	for _, id := range input.IDs {
+		found := false
+		for i := range x.detects {
+			d := &x.detects[i]
+			if d.DetectionID != id {
+				continue
+			}
+			found = true
+			d.DateUpdated = time.Now().UTC()
+			if input.Status != "" {
+				d.Status = input.Status
+			}
+			if input.AssignedToUUID != nil {
+				d.AssignedToUID = *input.AssignedToUUID
+			}
+			if input.ShowInUI != nil {
+				d.ShowInUI = *input.ShowInUI
+			}
+		}
+		if !found {
+			notFound = append(notFound, gofalcon.ServerError{Code: http.StatusNotFound, ID: id, Message: "detection not found"})
+		}
+	}
+
+	if len(notFound) > 0 {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"meta":      map[string]interface{}{"trace_id": "falcontest"},
+			"errors":    notFound,
+			"resources": []interface{}{},
+		})
+		return
+	}
+	writeResources(w, []interface{}{}, nil)
+}
+
+// handleQueryDevices returns IDs of devices. filter and sort parameters are not evaluated.
+func (x *Server) handleQueryDevices(w http.ResponseWriter, r *http.Request) {
+	offset, limit := page(r, 100)
+
+	x.mutex.Lock()
+	start, end := slice(len(x.devices), offset, limit)
+	ids := []string{}
+	for _, d := range x.devices[start:end] {
+		ids = append(ids, d.DeviceID)
+	}
+	total := len(x.devices)
+	x.mutex.Unlock()
+
+	writeResources(w, ids, &gofalcon.Pagenation{Offset: offset, Limit: limit, Total: total})
+}
+
+func (x *Server) handleEntityDevices(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["ids"]
+	if len(ids) > gofalcon.EntityDevicesMaxIDs {
+		writeError(w, http.StatusBadRequest, "too many ids")
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	resources := []gofalcon.DeviceResource{}
+	for _, id := range ids {
+		for _, d := range x.devices {
+			if d.DeviceID == id {
+				resources = append(resources, d)
+			}
+		}
+	}
+	writeResources(w, resources, nil)
+}
@@ -0,0 +1,323 @@
+// Package falcontest provides in-process mock server of Falcon API for tests. It serves OAuth2, detects, devices and event stream endpoints with fixtures, and can inject faults (expired token, rate limit and dropped connection).
+//
+//	server := falcontest.NewServer()
+//	defer server.Close()
+//	server.SeedDetections(10)
+//
+//	client, err := server.NewClient()
+//	output, err := client.Detection.QueriesDetects(&gofalcon.QueriesDetectsInput{})
+package falcontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+)
+
+// Default credentials accepted by Server
+const (
+	DefaultClientID = "falcontest-client-id"
+	DefaultSecret   = "falcontest-secret"
+	DefaultCID      = "0123456789abcdef0123456789abcdef"
+)
+
+// Option configures Server
+type Option func(x *Server)
+
+// WithCredentials replaces client ID and secret accepted by oauth2/token.
+func WithCredentials(clientID, secret string) Option {
+	return func(x *Server) {
+		x.clientID = clientID
+		x.secret = secret
+	}
+}
+
+// WithSeed sets seed of random fixtures generated by SeedDetections, SeedDevices and SeedEvents. Same seed generates same fixtures.
+func WithSeed(seed int64) Option {
+	return func(x *Server) {
+		x.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithPartitions sets number of event stream partitions. Default is 1.
+func WithPartitions(n int) Option {
+	return func(x *Server) {
+		x.partitions = n
+	}
+}
+
+// WithTokenTTL sets lifetime of OAuth2 access token. Default is 30 minutes.
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(x *Server) {
+		x.tokenTTL = ttl
+	}
+}
+
+// WithRefreshInterval sets refreshActiveSessionInterval of event stream. Default is 30 minutes.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(x *Server) {
+		x.refreshInterval = interval
+	}
+}
+
+// Request is log of request received by Server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+}
+
+// Server is mock of Falcon API. It's safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	clientID        string
+	secret          string
+	partitions      int
+	tokenTTL        time.Duration
+	refreshInterval time.Duration
+
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	tokens   map[string]*token
+	sessions map[string]*session
+	detects  []gofalcon.DetectionResources
+	devices  []gofalcon.DeviceResource
+	events   map[int][]*streamEvent // key is partition
+	faults   []*Fault
+	requests []Request
+	refreshs map[int]int
+	serial   int
+	// streaming is number of open streams per AppID
+	streaming map[string]int
+
+	// updated is closed and replaced when events are added to wake up streams
+	updated chan struct{}
+	// dropping is closed and replaced to close open streams, indexed by partition
+	dropping []chan struct{}
+	closing  chan struct{}
+}
+
+type token struct {
+	expiry  time.Time
+	expired bool
+}
+
+type session struct {
+	appID   string
+	expiry  time.Time
+	expired bool
+}
+
+// NewServer starts mock server of Falcon API. Close it after the test.
+func NewServer(options ...Option) *Server {
+	x := &Server{
+		clientID:        DefaultClientID,
+		secret:          DefaultSecret,
+		partitions:      1,
+		tokenTTL:        time.Minute * 30,
+		refreshInterval: time.Minute * 30,
+		rand:            rand.New(rand.NewSource(1)),
+		tokens:          make(map[string]*token),
+		sessions:        make(map[string]*session),
+		events:          make(map[int][]*streamEvent),
+		refreshs:        make(map[int]int),
+		streaming:       make(map[string]int),
+		updated:         make(chan struct{}),
+		closing:         make(chan struct{}),
+	}
+	for _, opt := range options {
+		opt(x)
+	}
+	for p := 0; p < x.partitions; p++ {
+		x.dropping = append(x.dropping, make(chan struct{}))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", x.handleToken)
+	mux.HandleFunc("/oauth2/revoke", x.handleRevoke)
+	mux.HandleFunc("/detects/queries/detects/v1", x.authorized(x.handleQueryDetects))
+	mux.HandleFunc("/detects/entities/summaries/GET/v1", x.authorized(x.handleDetectSummaries))
+	mux.HandleFunc("/detects/entities/detects/v2", x.authorized(x.handleUpdateDetects))
+	mux.HandleFunc("/detects/aggregates/detects/GET/v1", x.authorized(x.handleDetectAggregates))
+	mux.HandleFunc("/devices/queries/devices/v1", x.authorized(x.handleQueryDevices))
+	mux.HandleFunc("/devices/entities/devices/v1", x.authorized(x.handleEntityDevices))
+	mux.HandleFunc("/sensors/entities/datafeed/v2", x.authorized(x.handleDatafeed))
+	mux.HandleFunc("/sensors/entities/datafeed-actions/v1/", x.authorized(x.handleDatafeedAction))
+	mux.HandleFunc("/sensors/entities/datafeed/v1/", x.handleStream)
+
+	x.Server = httptest.NewServer(x.intercept(mux))
+	return x
+}
+
+// Close terminates open event streams and shuts down the server.
+func (x *Server) Close() {
+	x.mutex.Lock()
+	select {
+	case <-x.closing:
+	default:
+		close(x.closing)
+	}
+	x.mutex.Unlock()
+
+	x.Server.Close()
+}
+
+// ClientID returns client ID accepted by the server.
+func (x *Server) ClientID() string { return x.clientID }
+
+// Secret returns client secret accepted by the server.
+func (x *Server) Secret() string { return x.secret }
+
+// NewClient returns gofalcon.Client that is connected to the server and authorized by OAuth2.
+func (x *Server) NewClient(options ...gofalcon.Option) (*gofalcon.Client, error) {
+	client := gofalcon.NewClient(options...)
+	client.Endpoint = x.URL
+	if err := client.EnableOAuth2(x.clientID, x.secret); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Requests returns log of requests received by the server in order.
+func (x *Server) Requests() []Request {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return append([]Request{}, x.requests...)
+}
+
+// Refreshes returns number of refresh_active_stream_session actions of partition.
+func (x *Server) Refreshes(partition int) int {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.refreshs[partition]
+}
+
+// ExpireTokens makes all issued OAuth2 tokens expired. Then API responds 403 until the client retrieves new token.
+func (x *Server) ExpireTokens() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for _, t := range x.tokens {
+		t.expired = true
+	}
+}
+
+// ExpireSessions makes all session tokens of event stream expired. Then opening stream responds 401 until the client calls datafeed API again.
+func (x *Server) ExpireSessions() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for _, s := range x.sessions {
+		s.expired = true
+	}
+}
+
+func (x *Server) newID(prefix string) string {
+	x.serial++
+	return fmt.Sprintf("%s%032x", prefix, x.serial)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"meta":      map[string]interface{}{"trace_id": "falcontest"},
+		"errors":    []gofalcon.ServerError{{Code: status, Message: msg}},
+		"resources": []interface{}{},
+	})
+}
+
+func writeResources(w http.ResponseWriter, resources interface{}, pagination *gofalcon.Pagenation) {
+	meta := map[string]interface{}{
+		"powered_by": "falcontest",
+		"trace_id":   "falcontest",
+	}
+	if pagination != nil {
+		meta["pagination"] = map[string]interface{}{
+			"offset": pagination.Offset,
+			"limit":  pagination.Limit,
+			"total":  pagination.Total,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"meta":      meta,
+		"resources": resources,
+		"errors":    []interface{}{},
+	})
+}
+
+// authorized checks OAuth2 token of the request.
+func (x *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		parts := strings.SplitN(auth, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+			writeError(w, http.StatusUnauthorized, "access denied, authorization failed")
+			return
+		}
+
+		x.mutex.Lock()
+		t, ok := x.tokens[parts[1]]
+		valid := ok && !t.expired && time.Now().Before(t.expiry)
+		x.mutex.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "access denied, invalid bearer token")
+			return
+		}
+		if !valid {
+			writeError(w, http.StatusForbidden, "access denied, authorization failed")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (x *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.PostForm.Get("client_id") != x.clientID || r.PostForm.Get("client_secret") != x.secret {
+		writeError(w, http.StatusForbidden, "access denied, invalid client")
+		return
+	}
+
+	x.mutex.Lock()
+	accessToken := x.newID("token-")
+	x.tokens[accessToken] = &token{expiry: time.Now().Add(x.tokenTTL)}
+	x.mutex.Unlock()
+
+	w.Header().Set("X-Cs-Region", "us-1")
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "bearer",
+		"expires_in":   int(x.tokenTTL.Seconds()),
+	})
+}
+
+func (x *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	x.mutex.Lock()
+	delete(x.tokens, r.PostForm.Get("token"))
+	x.mutex.Unlock()
+
+	writeResources(w, []interface{}{}, nil)
+}
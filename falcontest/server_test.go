@@ -0,0 +1,216 @@
+package falcontest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuth2(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+	assert.Error(t, client.EnableOAuth2("invalid", "invalid"))
+	require.NoError(t, client.EnableOAuth2(server.ClientID(), server.Secret()))
+
+	_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+	require.NoError(t, err)
+
+	token, err := client.TokenSource().Token(context.Background())
+	require.NoError(t, err)
+	_, err = client.OAuth2.Revoke(&gofalcon.RevokeInput{Token: &token.Token})
+	require.NoError(t, err)
+}
+
+func TestDetects(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithSeed(42))
+	defer server.Close()
+	ids := server.SeedDetections(25)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	p := client.Detection.QueriesDetectsPaginator(&gofalcon.QueriesDetectsInput{Limit: gofalcon.Int(10)})
+	var got []string
+	for q := range client.Detection.FetchSummaries(context.Background(), p, &gofalcon.HydrateOptions{BatchSize: 7}) {
+		require.NoError(t, q.Error)
+		assert.Equal(t, falcontest.DefaultCID, q.Detection.Cid)
+		got = append(got, q.Detection.DetectionID)
+	}
+	assert.ElementsMatch(t, ids, got)
+
+	t.Run("same seed generates same fixtures", func(t *testing.T) {
+		other := falcontest.NewServer(falcontest.WithSeed(42))
+		defer other.Close()
+		assert.Equal(t, ids, other.SeedDetections(25))
+	})
+}
+
+func TestDevices(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	ids := server.SeedDevices(3)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	query, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{Limit: gofalcon.Int(2)})
+	require.NoError(t, err)
+	assert.Equal(t, ids[:2], query.Resources)
+	assert.Equal(t, 3, query.Meta.Pagenation.Total)
+
+	entity, err := client.Device.EntityDevices(&gofalcon.EntityDevicesInput{ID: query.Resources})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entity.Resources))
+	assert.NotEmpty(t, entity.Resources[0].MacAddress)
+}
+
+func TestFaults(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	server.SeedDevices(1)
+
+	client, err := server.NewClient(gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}))
+	require.NoError(t, err)
+
+	t.Run("expired token is refreshed", func(t *testing.T) {
+		server.ExpireTokens()
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+	})
+
+	t.Run("rate limited request is retried", func(t *testing.T) {
+		server.InjectRateLimit("/devices/", 2, 0)
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+	})
+
+	t.Run("injected status", func(t *testing.T) {
+		server.InjectStatus("/devices/queries/", http.StatusNotFound, 1)
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		assert.True(t, gofalcon.IsNotFound(err))
+
+		_, err = client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+	})
+
+	t.Run("dropped connection is retried", func(t *testing.T) {
+		server.DropConnections("/devices/", 1)
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+
+		server.DropConnections("/devices/", 3)
+		_, err = client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		assert.Error(t, err)
+	})
+
+	var throttled int
+	for _, req := range server.Requests() {
+		if req.Path == "/devices/queries/devices/v1" {
+			throttled++
+		}
+	}
+	assert.Equal(t, 12, throttled)
+}
+
+func TestEventStream(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithPartitions(2))
+	defer server.Close()
+	server.SeedEvents(0, 3)
+	server.SeedEvents(1, 2)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond},
+	})
+
+	received := map[int][]int{}
+	read := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case q := <-ch:
+				require.NoError(t, q.Error)
+				if q.Reconnect != nil {
+					i--
+					continue
+				}
+				_, ok := q.Payload.(*gofalcon.DetectionSummaryEvent)
+				assert.True(t, ok)
+				received[q.Partition] = append(received[q.Partition], q.Meta.Offset)
+			case <-time.After(time.Second * 5):
+				require.Fail(t, "timeout")
+			}
+		}
+	}
+
+	read(5)
+	assert.Equal(t, []int{0, 1, 2}, received[0])
+	assert.Equal(t, []int{0, 1}, received[1])
+
+	// Events added later are delivered to open stream
+	server.AddEvents(falcontest.Event{Partition: 1, Type: "CustomEvent", Body: map[string]string{"Foo": "bar"}})
+	select {
+	case q := <-ch:
+		require.NoError(t, q.Error)
+		assert.Equal(t, 1, q.Partition)
+		assert.Equal(t, 2, q.Meta.Offset)
+		assert.Equal(t, "bar", q.Event["Foo"])
+	case <-time.After(time.Second * 5):
+		require.Fail(t, "timeout")
+	}
+}
+
+func TestEventStreamFaults(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithRefreshInterval(time.Second))
+	defer server.Close()
+	server.SeedEvents(0, 5)
+	server.DropStream(2, 2)
+
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		Reconnect:     &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond},
+		RefreshMargin: time.Millisecond * 900,
+	})
+
+	var offsets []int
+	reconnects := 0
+	for len(offsets) < 5 {
+		select {
+		case q := <-ch:
+			require.NoError(t, q.Error)
+			if q.Reconnect != nil {
+				reconnects++
+				continue
+			}
+			offsets = append(offsets, q.Meta.Offset)
+		case <-time.After(time.Second * 5):
+			require.Fail(t, "timeout")
+		}
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, offsets)
+	assert.Equal(t, 2, reconnects)
+
+	require.True(t, assert.Eventually(t, func() bool {
+		return server.Refreshes(0) > 0
+	}, time.Second*5, time.Millisecond*50))
+}
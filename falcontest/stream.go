@@ -0,0 +1,240 @@
+package falcontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+)
+
+// Event is an event of event stream.
+type Event struct {
+	// Partition of the event. Default is 0.
+	Partition int
+	// Type is eventType of metadata, e.g. gofalcon.EventTypeDetectionSummary
+	Type string
+	// CreationTime is eventCreationTime of metadata. Current time is used if zero.
+	CreationTime time.Time
+	// Body is marshaled to "event" field.
+	Body interface{}
+}
+
+type streamEvent struct {
+	Meta  gofalcon.StreamEventMetaData `json:"metadata"`
+	Event interface{}                  `json:"event"`
+}
+
+// AddEvents appends events to the stream of each partition and returns their offsets. Open streams receive the events immediately.
+func (x *Server) AddEvents(events ...Event) []int {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	var offsets []int
+	for _, ev := range events {
+		created := ev.CreationTime
+		if created.IsZero() {
+			created = time.Now()
+		}
+
+		offset := len(x.events[ev.Partition])
+		x.events[ev.Partition] = append(x.events[ev.Partition], &streamEvent{
+			Meta: gofalcon.StreamEventMetaData{
+				CustomerIDString:  DefaultCID,
+				EventType:         ev.Type,
+				Offset:            offset,
+				EventCreationTime: created.UnixNano() / int64(time.Millisecond),
+			},
+			Event: ev.Body,
+		})
+		offsets = append(offsets, offset)
+	}
+
+	close(x.updated)
+	x.updated = make(chan struct{})
+	return offsets
+}
+
+// DropOpenStreams closes event stream connections of the partitions that are open now. All partitions are closed if no partition is given. Streams opened after that are not affected.
+func (x *Server) DropOpenStreams(partitions ...int) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if len(partitions) == 0 {
+		for p := range x.dropping {
+			partitions = append(partitions, p)
+		}
+	}
+	for _, p := range partitions {
+		close(x.dropping[p])
+		x.dropping[p] = make(chan struct{})
+	}
+}
+
+// SeedEvents generates n DetectionSummaryEvent of partition by seed of WithSeed.
+func (x *Server) SeedEvents(partition, n int) []int {
+	x.mutex.Lock()
+	var events []Event
+	for i := 0; i < n; i++ {
+		aid := fmt.Sprintf("%032x", x.rand.Int63())
+		severity := x.rand.Intn(5) + 1
+		events = append(events, Event{
+			Partition: partition,
+			Type:      gofalcon.EventTypeDetectionSummary,
+			Body: gofalcon.DetectionSummaryEvent{
+				DetectID:     fmt.Sprintf("ldt:%s:%d", aid, x.rand.Int63()),
+				SensorID:     aid,
+				ComputerName: fmt.Sprintf("host-%d", i),
+				Severity:     severity,
+				SeverityName: severityName(severity * 20),
+				DetectName:   "Suspicious Activity",
+			},
+		})
+	}
+	x.mutex.Unlock()
+
+	return x.AddEvents(events...)
+}
+
+func (x *Server) handleDatafeed(w http.ResponseWriter, r *http.Request) {
+	appID := r.URL.Query().Get("appId")
+	if appID == "" {
+		writeError(w, http.StatusBadRequest, "appId is required")
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	// Same as Falcon API, no DataFeedURL is issued while streams of the AppID are open
+	resources := []gofalcon.DataFeedResource{}
+	if x.streaming[appID] > 0 {
+		writeResources(w, resources, nil)
+		return
+	}
+
+	expiry := time.Now().Add(x.refreshInterval)
+	for p := 0; p < x.partitions; p++ {
+		sessionToken := x.newID("session-")
+		x.sessions[sessionToken] = &session{appID: appID, expiry: expiry}
+
+		resources = append(resources, gofalcon.DataFeedResource{
+			DataFeedURL:                  fmt.Sprintf("%s/sensors/entities/datafeed/v1/%d?appId=%s", x.URL, p, appID),
+			RefreshActiveSessionURL:      fmt.Sprintf("%s/sensors/entities/datafeed-actions/v1/%d?appId=%s&action_name=refresh_active_stream_session", x.URL, p, appID),
+			RefreshActiveSessionInterval: int(x.refreshInterval.Seconds()),
+			SessionToken: gofalcon.DataFeedSessionToken{
+				Token:      sessionToken,
+				Expiration: expiry.UTC().Format(time.RFC3339Nano),
+			},
+		})
+	}
+
+	writeResources(w, resources, nil)
+}
+
+func parsePartition(path, prefix string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(path, prefix))
+}
+
+func (x *Server) handleDatafeedAction(w http.ResponseWriter, r *http.Request) {
+	partition, err := parsePartition(r.URL.Path, "/sensors/entities/datafeed-actions/v1/")
+	if err != nil || partition < 0 || partition >= x.partitions {
+		writeError(w, http.StatusNotFound, "partition not found")
+		return
+	}
+	if action := r.URL.Query().Get("action_name"); action != "refresh_active_stream_session" {
+		writeError(w, http.StatusBadRequest, "unsupported action_name: "+action)
+		return
+	}
+
+	appID := r.URL.Query().Get("appId")
+	x.mutex.Lock()
+	x.refreshs[partition]++
+	for _, s := range x.sessions {
+		if s.appID == appID && !s.expired {
+			s.expiry = time.Now().Add(x.refreshInterval)
+		}
+	}
+	x.mutex.Unlock()
+
+	writeResources(w, []interface{}{}, nil)
+}
+
+// handleStream streams events of the partition from offset as JSON lines, and keeps the connection open to send events added later.
+func (x *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	partition, err := parsePartition(r.URL.Path, "/sensors/entities/datafeed/v1/")
+	if err != nil || partition < 0 || partition >= x.partitions {
+		writeError(w, http.StatusNotFound, "partition not found")
+		return
+	}
+
+	sessionToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")
+	x.mutex.Lock()
+	s, ok := x.sessions[sessionToken]
+	valid := ok && !s.expired && time.Now().Before(s.expiry)
+	x.mutex.Unlock()
+	if !valid {
+		writeError(w, http.StatusUnauthorized, "invalid session token")
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	dropAfter := x.streamFault(r)
+
+	x.mutex.Lock()
+	x.streaming[s.appID]++
+	x.mutex.Unlock()
+	defer func() {
+		x.mutex.Lock()
+		x.streaming[s.appID]--
+		x.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	sent := 0
+	for {
+		x.mutex.Lock()
+		var pending []*streamEvent
+		if offset < len(x.events[partition]) {
+			pending = x.events[partition][offset:]
+		}
+		updated, dropping := x.updated, x.dropping[partition]
+		x.mutex.Unlock()
+
+		for _, ev := range pending {
+			if dropAfter >= 0 && sent >= dropAfter {
+				break
+			}
+			if err := encoder.Encode(ev); err != nil {
+				return
+			}
+			offset++
+			sent++
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if dropAfter >= 0 && sent >= dropAfter {
+			dropConnection(w)
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-dropping:
+			dropConnection(w)
+			return
+		case <-r.Context().Done():
+			return
+		case <-x.closing:
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package fql provides builder and parser of FQL (Falcon Query Language) that is used for "filter" parameter of Falcon API.
+//
+//	filter := fql.And(
+//		fql.Eq("status", "new"),
+//		fql.Ge("max_severity", 50),
+//		fql.Gt("last_behavior", fql.Now("-1d")),
+//		fql.Or(fql.Like("device.hostname", "web*"), fql.In("device.platform_name", "Linux", "Mac")),
+//	)
+//	filter.String() // status:'new'+max_severity:>=50+last_behavior:>'now-1d'+(device.hostname:*'web*',device.platform_name:['Linux','Mac'])
+package fql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is FQL expression. String() returns FQL string to be set to "filter" parameter.
+type Expr interface {
+	String() string
+}
+
+// Op is operator of condition
+type Op string
+
+const (
+	OpEq       Op = ""   // field:value
+	OpNe       Op = "!"  // field:!value
+	OpGt       Op = ">"  // field:>value
+	OpGe       Op = ">=" // field:>=value
+	OpLt       Op = "<"  // field:<value
+	OpLe       Op = "<=" // field:<=value
+	OpWildcard Op = "*"  // field:*'value*'
+	OpMatch    Op = "~"  // field:~'value' (case insensitive text match)
+	OpNotMatch Op = "!~" // field:!~'value'
+)
+
+// Value is right hand side of condition.
+type Value interface {
+	fqlValue() string
+}
+
+// String is quoted string value such as 'abc'. Single quote and backslash are escaped.
+type String string
+
+func (x String) fqlValue() string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(string(x)) + "'"
+}
+
+// Raw is unquoted value such as number, boolean and null. It's written as it is.
+type Raw string
+
+func (x Raw) fqlValue() string { return string(x) }
+
+// List is array value such as ['a','b'].
+type List []Value
+
+func (x List) fqlValue() string {
+	values := make([]string, len(x))
+	for i, v := range x {
+		values[i] = v.fqlValue()
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+// Now returns date math value relative to current time. offset is like "-1d", "-12h" and "-30m". Empty offset means current time.
+func Now(offset string) Value {
+	return String("now" + offset)
+}
+
+// Time returns quoted RFC3339 timestamp value.
+func Time(t time.Time) Value {
+	return String(t.UTC().Format(time.RFC3339))
+}
+
+// ToValue converts Go value to FQL value. string is quoted, numbers and bool are written as they are, time.Time is quoted RFC3339 and slice of them is List.
+func ToValue(v interface{}) Value {
+	switch t := v.(type) {
+	case Value:
+		return t
+	case string:
+		return String(t)
+	case []string:
+		list := make(List, len(t))
+		for i, s := range t {
+			list[i] = String(s)
+		}
+		return list
+	case bool:
+		return Raw(strconv.FormatBool(t))
+	case int:
+		return Raw(strconv.Itoa(t))
+	case int64:
+		return Raw(strconv.FormatInt(t, 10))
+	case float64:
+		return Raw(strconv.FormatFloat(t, 'f', -1, 64))
+	case time.Time:
+		return Time(t)
+	case nil:
+		return Raw("null")
+	default:
+		return String(fmt.Sprint(t))
+	}
+}
+
+// Condition is a comparison of a field and a value.
+type Condition struct {
+	Field string
+	Op    Op
+	Value Value
+}
+
+func (x *Condition) String() string {
+	return x.Field + ":" + string(x.Op) + x.Value.fqlValue()
+}
+
+// AndExpr is conjunction of expressions joined by "+".
+type AndExpr []Expr
+
+func (x AndExpr) String() string { return join(x, "+") }
+
+// OrExpr is disjunction of expressions joined by ",".
+type OrExpr []Expr
+
+func (x OrExpr) String() string { return join(x, ",") }
+
+func join(exprs []Expr, sep string) string {
+	var parts []string
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		s := expr.String()
+		if s == "" {
+			continue
+		}
+
+		switch expr.(type) {
+		case AndExpr, OrExpr:
+			if len(exprs) > 1 {
+				s = "(" + s + ")"
+			}
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, sep)
+}
+
+func cond(field string, op Op, value interface{}) Expr {
+	return &Condition{Field: field, Op: op, Value: ToValue(value)}
+}
+
+// Eq is field:value
+func Eq(field string, value interface{}) Expr { return cond(field, OpEq, value) }
+
+// Ne is field:!value
+func Ne(field string, value interface{}) Expr { return cond(field, OpNe, value) }
+
+// Gt is field:>value
+func Gt(field string, value interface{}) Expr { return cond(field, OpGt, value) }
+
+// Ge is field:>=value
+func Ge(field string, value interface{}) Expr { return cond(field, OpGe, value) }
+
+// Lt is field:<value
+func Lt(field string, value interface{}) Expr { return cond(field, OpLt, value) }
+
+// Le is field:<=value
+func Le(field string, value interface{}) Expr { return cond(field, OpLe, value) }
+
+// Like is wildcard match such as field:*'web*'
+func Like(field, pattern string) Expr { return cond(field, OpWildcard, pattern) }
+
+// Match is case insensitive text match such as field:~'web'
+func Match(field, text string) Expr { return cond(field, OpMatch, text) }
+
+// In is field:['a','b']
+func In(field string, values ...interface{}) Expr {
+	return &Condition{Field: field, Op: OpEq, Value: toList(values)}
+}
+
+// NotIn is field:!['a','b']
+func NotIn(field string, values ...interface{}) Expr {
+	return &Condition{Field: field, Op: OpNe, Value: toList(values)}
+}
+
+func toList(values []interface{}) List {
+	list := make(List, len(values))
+	for i, v := range values {
+		list[i] = ToValue(v)
+	}
+	return list
+}
+
+// And joins exprs by "+". nil exprs are ignored.
+func And(exprs ...Expr) Expr { return AndExpr(exprs) }
+
+// Or joins exprs by ",". nil exprs are ignored.
+func Or(exprs ...Expr) Expr { return OrExpr(exprs) }
@@ -0,0 +1,90 @@
+package fql_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon/fql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	testCases := []struct {
+		title  string
+		expr   fql.Expr
+		expect string
+	}{
+		{"equal", fql.Eq("status", "new"), "status:'new'"},
+		{"not equal", fql.Ne("status", "closed"), "status:!'closed'"},
+		{"number", fql.Ge("max_severity", 50), "max_severity:>=50"},
+		{"bool", fql.Eq("show_in_ui", true), "show_in_ui:true"},
+		{"less than", fql.Lt("max_confidence", 10), "max_confidence:<10"},
+		{"date math", fql.Gt("last_behavior", fql.Now("-1d")), "last_behavior:>'now-1d'"},
+		{"time", fql.Le("first_behavior", time.Date(2020, 11, 5, 4, 10, 45, 0, time.UTC)), "first_behavior:<='2020-11-05T04:10:45Z'"},
+		{"wildcard", fql.Like("hostname", "web*"), "hostname:*'web*'"},
+		{"array", fql.In("platform_name", "Linux", "Mac"), "platform_name:['Linux','Mac']"},
+		{"not in array", fql.NotIn("status", "closed", "ignored"), "status:!['closed','ignored']"},
+		{"escape", fql.Eq("hostname", `it's\me`), `hostname:'it\'s\\me'`},
+		{
+			"and/or grouping",
+			fql.And(fql.Eq("status", "new"), fql.Or(fql.Eq("tactic", "Execution"), fql.Eq("tactic", "Persistence"))),
+			"status:'new'+(tactic:'Execution',tactic:'Persistence')",
+		},
+		{"ignore nil", fql.And(nil, fql.Eq("status", "new"), nil), "status:'new'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			assert.Equal(t, tc.expect, tc.expr.String())
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		filters := []string{
+			"status:'new'",
+			"status:!'closed'+max_severity:>=50",
+			"last_behavior:>'now-1d'",
+			"device.hostname:*'web*',device.platform_name:['Linux','Mac']",
+			"status:'new'+(tactic:'Execution',tactic:'Persistence')",
+			`hostname:'it\'s\\me'`,
+			"hostname:~'WEB'+hostname:!~'test'",
+			"show_in_ui:true",
+		}
+		for _, filter := range filters {
+			expr, err := fql.Parse(filter)
+			require.NoError(t, err, filter)
+			assert.Equal(t, filter, expr.String())
+		}
+	})
+
+	t.Run("Parse into conditions", func(t *testing.T) {
+		expr, err := fql.Parse("status:'new' + max_severity:>=50")
+		require.NoError(t, err)
+		and, ok := expr.(fql.AndExpr)
+		require.True(t, ok)
+		require.Equal(t, 2, len(and))
+		assert.Equal(t, &fql.Condition{Field: "max_severity", Op: fql.OpGe, Value: fql.Raw("50")}, and[1])
+	})
+
+	t.Run("Invalid filters", func(t *testing.T) {
+		filters := []string{
+			"",
+			"status",
+			"status:",
+			"status:'new",
+			"(status:'new'",
+			"status:'new'+",
+			"status:['a',",
+			"status:'new')",
+		}
+		for _, filter := range filters {
+			err := fql.Validate(filter)
+			assert.Error(t, err, filter)
+			_, ok := err.(*fql.SyntaxError)
+			assert.True(t, ok, filter)
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package fql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError is returned by Parse if the filter is invalid.
+type SyntaxError struct {
+	Pos int // byte offset in the filter
+	Msg string
+}
+
+func (x *SyntaxError) Error() string {
+	return fmt.Sprintf("FQL syntax error at %d: %s", x.Pos, x.Msg)
+}
+
+// Validate returns *SyntaxError if filter is not valid FQL.
+func Validate(filter string) error {
+	_, err := Parse(filter)
+	return err
+}
+
+// Parse converts FQL string to Expr. It checks only syntax, not field names.
+func Parse(filter string) (Expr, error) {
+	p := &parser{src: filter}
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("empty filter")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return expr, nil
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (x *parser) eof() bool { return x.pos >= len(x.src) }
+
+func (x *parser) peek() byte {
+	if x.eof() {
+		return 0
+	}
+	return x.src[x.pos]
+}
+
+func (x *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: x.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (x *parser) skipSpace() {
+	for !x.eof() && (x.src[x.pos] == ' ' || x.src[x.pos] == '\t') {
+		x.pos++
+	}
+}
+
+// consume skips spaces and c if next byte is c.
+func (x *parser) consume(c byte) bool {
+	x.skipSpace()
+	if x.peek() == c {
+		x.pos++
+		return true
+	}
+	return false
+}
+
+// or := and (',' and)*
+func (x *parser) parseOr() (Expr, error) {
+	var exprs OrExpr
+	for {
+		expr, err := x.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !x.consume(',') {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+// and := factor ('+' factor)*
+func (x *parser) parseAnd() (Expr, error) {
+	var exprs AndExpr
+	for {
+		expr, err := x.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !x.consume('+') {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+// factor := '(' or ')' | condition
+func (x *parser) parseFactor() (Expr, error) {
+	if x.consume('(') {
+		expr, err := x.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !x.consume(')') {
+			return nil, x.errorf("missing ')'")
+		}
+		return expr, nil
+	}
+	return x.parseCondition()
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+var operators = []Op{OpNotMatch, OpGe, OpLe, OpNe, OpGt, OpLt, OpWildcard, OpMatch}
+
+// condition := field ':' op? value
+func (x *parser) parseCondition() (Expr, error) {
+	x.skipSpace()
+	start := x.pos
+	for !x.eof() && isFieldChar(x.peek()) {
+		x.pos++
+	}
+	if start == x.pos {
+		return nil, x.errorf("field name is required")
+	}
+	field := x.src[start:x.pos]
+
+	if x.peek() != ':' {
+		return nil, x.errorf("':' is required after field %q", field)
+	}
+	x.pos++
+
+	op := OpEq
+	for _, candidate := range operators {
+		if strings.HasPrefix(x.src[x.pos:], string(candidate)) {
+			op = candidate
+			x.pos += len(candidate)
+			break
+		}
+	}
+
+	value, err := x.parseValue(true)
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{Field: field, Op: op, Value: value}, nil
+}
+
+// value := quoted | '[' value (',' value)* ']' | raw
+func (x *parser) parseValue(allowList bool) (Value, error) {
+	switch x.peek() {
+	case '\'', '"':
+		return x.parseQuoted()
+
+	case '[':
+		if !allowList {
+			return nil, x.errorf("nested array is not allowed")
+		}
+		x.pos++
+		var list List
+		if x.consume(']') {
+			return list, nil
+		}
+		for {
+			x.skipSpace()
+			v, err := x.parseValue(false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if x.consume(']') {
+				return list, nil
+			}
+			if !x.consume(',') {
+				return nil, x.errorf("',' or ']' is required in array")
+			}
+		}
+
+	default:
+		start := x.pos
+		for !x.eof() && !strings.ContainsRune("+,()[]' \t", rune(x.peek())) {
+			x.pos++
+		}
+		if start == x.pos {
+			return nil, x.errorf("value is required")
+		}
+		return Raw(x.src[start:x.pos]), nil
+	}
+}
+
+func (x *parser) parseQuoted() (Value, error) {
+	quote := x.peek()
+	start := x.pos
+	x.pos++
+
+	var b strings.Builder
+	for !x.eof() {
+		c := x.src[x.pos]
+		switch {
+		case c == '\\' && x.pos+1 < len(x.src):
+			b.WriteByte(x.src[x.pos+1])
+			x.pos += 2
+		case c == quote:
+			x.pos++
+			return String(b.String()), nil
+		default:
+			b.WriteByte(c)
+			x.pos++
+		}
+	}
+
+	x.pos = start
+	return nil, x.errorf("unterminated string")
+}
@@ -0,0 +1,104 @@
+package gofalcon
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// EntitySummariesMaxIDs is maximum number of IDs in a request of DetectionAPI.EntitySummaries
+	EntitySummariesMaxIDs = 1000
+	// EntityDevicesMaxIDs is maximum number of IDs in a request of DeviceAPI.EntityDevices
+	EntityDevicesMaxIDs = 100
+
+	defaultHydrateWorkers = 4
+)
+
+// HydrateOptions configures Hydrate.
+type HydrateOptions struct {
+	// BatchSize is maximum number of IDs passed to EntityFunc at once. 0 means maximum of the endpoint for typed helpers, and 100 for Hydrate.
+	BatchSize int
+	// Workers is number of EntityFunc called concurrently. Default is 4.
+	Workers int
+}
+
+// EntityFunc retrieves entities of ids. It's called by Hydrate concurrently.
+type EntityFunc func(ctx context.Context, ids []string) error
+
+// Hydrate walks all pages of p, splits IDs into batches and calls fetch with each batch by a bounded worker pool. It stops and returns the first error. The order of batches passed to fetch is not guaranteed.
+func Hydrate(ctx context.Context, p *Paginator, options *HydrateOptions, fetch EntityFunc) error {
+	batchSize, workers := 100, defaultHydrateWorkers
+	if options != nil {
+		if options.BatchSize > 0 {
+			batchSize = options.BatchSize
+		}
+		if options.Workers > 0 {
+			workers = options.Workers
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errOnce sync.Once
+	var firstErr error
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	batches := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ids := range batches {
+				if err := fetch(ctx, ids); err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+
+	send := func(ids []string) bool {
+		select {
+		case batches <- ids:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var buf []string
+Loop:
+	for p.HasNext() {
+		ids, err := p.Next(ctx)
+		if err != nil {
+			setErr(err)
+			break
+		}
+
+		buf = append(buf, ids...)
+		for len(buf) >= batchSize {
+			if !send(buf[:batchSize:batchSize]) {
+				break Loop
+			}
+			buf = buf[batchSize:]
+		}
+	}
+	if len(buf) > 0 && ctx.Err() == nil {
+		send(buf)
+	}
+
+	close(batches)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return parent.Err()
+}
@@ -0,0 +1,117 @@
+package gofalcon_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHydrateServer(t *testing.T, total int, batches *[]int) *httptest.Server {
+	var mutex sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/detects/queries/detects/v1":
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			ids := []string{}
+			for i := offset; i < offset+limit && i < total; i++ {
+				ids = append(ids, fmt.Sprintf("ldt:%d", i))
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"meta":      map[string]interface{}{"pagination": map[string]int{"offset": offset, "limit": limit, "total": total}},
+				"resources": ids,
+			}))
+
+		case "/detects/entities/summaries/GET/v1":
+			var input gofalcon.EntitySummariesInput
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+			mutex.Lock()
+			*batches = append(*batches, len(input.ID))
+			mutex.Unlock()
+
+			var resources []map[string]string
+			for _, id := range input.ID {
+				if id == "ldt:broken" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				resources = append(resources, map[string]string{"detection_id": id})
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"resources": resources}))
+		}
+	}))
+}
+
+func TestFetchSummaries(t *testing.T) {
+	var batches []int
+	server := newHydrateServer(t, 25, &batches)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	p := client.Detection.QueriesDetectsPaginator(&gofalcon.QueriesDetectsInput{})
+	p.Limit = 7
+	ch := client.Detection.FetchSummaries(context.Background(), p, &gofalcon.HydrateOptions{
+		BatchSize: 10,
+		Workers:   3,
+	})
+
+	found := map[string]bool{}
+	for q := range ch {
+		require.NoError(t, q.Error)
+		found[q.Detection.DetectionID] = true
+	}
+
+	assert.Equal(t, 25, len(found))
+	assert.True(t, found["ldt:0"])
+	assert.True(t, found["ldt:24"])
+	assert.ElementsMatch(t, []int{10, 10, 5}, batches)
+}
+
+func TestHydrate(t *testing.T) {
+	t.Run("Error of EntityFunc stops hydration", func(t *testing.T) {
+		ids := []string{"a", "b", "c", "d", "e"}
+		p := gofalcon.NewPaginator(gofalcon.OffsetPagination, func(ctx context.Context, page gofalcon.Page) ([]string, gofalcon.MetaData, error) {
+			return ids, gofalcon.MetaData{}, nil
+		})
+
+		err := gofalcon.Hydrate(context.Background(), p, &gofalcon.HydrateOptions{BatchSize: 2, Workers: 1},
+			func(ctx context.Context, batch []string) error {
+				if batch[0] == "c" {
+					return fmt.Errorf("broken")
+				}
+				return nil
+			})
+		assert.EqualError(t, err, "broken")
+	})
+
+	t.Run("Error of entity request is issued", func(t *testing.T) {
+		var batches []int
+		server := newHydrateServer(t, 0, &batches)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		p := gofalcon.NewPaginator(gofalcon.OffsetPagination, func(ctx context.Context, page gofalcon.Page) ([]string, gofalcon.MetaData, error) {
+			return []string{"ldt:broken"}, gofalcon.MetaData{}, nil
+		})
+
+		var errs []error
+		for q := range client.Detection.FetchSummaries(context.Background(), p, nil) {
+			errs = append(errs, q.Error)
+		}
+		require.Equal(t, 1, len(errs))
+		assert.Error(t, errs[0])
+	})
+}
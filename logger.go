@@ -0,0 +1,184 @@
+package gofalcon
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogLevel is severity of log
+type LogLevel int
+
+const (
+	// LogLevelDebug is for detail of API calls
+	LogLevelDebug LogLevel = iota
+	// LogLevelInfo is for state changes such as event stream connection
+	LogLevelInfo
+	// LogLevelWarn is for recoverable errors such as retry and reconnect
+	LogLevelWarn
+	// LogLevelError is for unrecoverable errors
+	LogLevelError
+)
+
+func (x LogLevel) String() string {
+	switch x {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("LogLevel(%d)", int(x))
+	}
+}
+
+// LogFields is structured fields of log.
+type LogFields map[string]interface{}
+
+// Keys of LogFields used consistently by Client
+const (
+	LogKeyTraceID   = "trace_id"
+	LogKeyPath      = "path"
+	LogKeyPartition = "partition"
+	LogKeyAppID     = "app_id"
+	LogKeyOffset    = "offset"
+	LogKeyAttempt   = "attempt"
+	LogKeyError     = "error"
+	LogKeyURL       = "url"
+	LogKeyWait      = "wait"
+)
+
+// StructuredLogger is logger of Client. Fields have been redacted before Log is called: tokens and secrets are replaced and query strings of URLs (e.g. DataFeedURL) are removed. Log must be safe for concurrent use.
+type StructuredLogger interface {
+	Log(level LogLevel, msg string, fields LogFields)
+}
+
+// WithLogger sets logger of the client. Without this option, the client writes logs to package level Logger.
+func WithLogger(logger StructuredLogger) Option {
+	return func(client *Client) {
+		client.log = newLogger(logger)
+	}
+}
+
+// DiscardLogger drops all logs.
+type DiscardLogger struct{}
+
+// Log implements StructuredLogger.
+func (x DiscardLogger) Log(level LogLevel, msg string, fields LogFields) {}
+
+// NewLogrusLogger creates StructuredLogger writing logs to logrus.
+func NewLogrusLogger(logger logrus.FieldLogger) StructuredLogger {
+	return &logrusLogger{logger: func() logrus.FieldLogger { return logger }}
+}
+
+type logrusLogger struct {
+	logger func() logrus.FieldLogger
+}
+
+func (x *logrusLogger) Log(level LogLevel, msg string, fields LogFields) {
+	entry := x.logger().WithFields(logrus.Fields(fields))
+	switch level {
+	case LogLevelDebug:
+		entry.Debug(msg)
+	case LogLevelInfo:
+		entry.Info(msg)
+	case LogLevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
+// logger redacts fields and writes logs to StructuredLogger.
+type logger struct {
+	sink StructuredLogger
+}
+
+func newLogger(sink StructuredLogger) logger {
+	if sink == nil {
+		sink = DiscardLogger{}
+	}
+	return logger{sink: sink}
+}
+
+// defaultLogger refers package level Logger when writing log, because Logger can be replaced after NewClient.
+func defaultLogger() logger {
+	return newLogger(&logrusLogger{logger: func() logrus.FieldLogger { return Logger }})
+}
+
+func (x logger) Debug(msg string, fields LogFields) { x.write(LogLevelDebug, msg, fields) }
+func (x logger) Info(msg string, fields LogFields)  { x.write(LogLevelInfo, msg, fields) }
+func (x logger) Warn(msg string, fields LogFields)  { x.write(LogLevelWarn, msg, fields) }
+func (x logger) Error(msg string, fields LogFields) { x.write(LogLevelError, msg, fields) }
+
+func (x logger) write(level LogLevel, msg string, fields LogFields) {
+	if x.sink == nil {
+		return
+	}
+	redacted := make(LogFields, len(fields))
+	for key, value := range fields {
+		redacted[key] = redactLogValue(key, value)
+	}
+	x.sink.Log(level, redactLogString(msg), redacted)
+}
+
+const logRedacted = "[REDACTED]"
+
+var sensitiveLogKeys = map[string]bool{
+	"token":          true,
+	"access_token":   true,
+	"session_token":  true,
+	"secret":         true,
+	"client_secret":  true,
+	"authorization":  true,
+	"refresh_token":  true,
+	"x-cs-useruuid":  true,
+	"x-cs-usertoken": true,
+}
+
+var (
+	logURLPattern   = regexp.MustCompile(`https?://[^\s"']+`)
+	logTokenPattern = regexp.MustCompile(`(?i)\b(bearer|basic|token)(\s+)[^\s"',]+`)
+)
+
+func redactLogValue(key string, value interface{}) interface{} {
+	if sensitiveLogKeys[strings.ToLower(key)] {
+		return logRedacted
+	}
+
+	switch v := value.(type) {
+	case string:
+		return redactLogString(v)
+	case error:
+		return redactLogString(v.Error())
+	case *url.URL:
+		return redactLogURL(v.String())
+	default:
+		return value
+	}
+}
+
+// redactLogString removes query strings of URLs and credentials of authorization headers in s.
+func redactLogString(s string) string {
+	s = logURLPattern.ReplaceAllStringFunc(s, redactLogURL)
+	return logTokenPattern.ReplaceAllString(s, "${1}${2}"+logRedacted)
+}
+
+func redactLogURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return logRedacted
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = logRedacted
+	}
+	u.Fragment = ""
+	return u.String()
+}
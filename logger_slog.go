@@ -0,0 +1,50 @@
+//go:build go1.21
+// +build go1.21
+
+package gofalcon
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// NewSlogLogger creates StructuredLogger writing logs to log/slog. It's available with Go 1.21 or later.
+func NewSlogLogger(logger *slog.Logger) StructuredLogger {
+	return &slogLogger{logger: logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (x *slogLogger) Log(level LogLevel, msg string, fields LogFields) {
+	var slogLevel slog.Level
+	switch level {
+	case LogLevelDebug:
+		slogLevel = slog.LevelDebug
+	case LogLevelInfo:
+		slogLevel = slog.LevelInfo
+	case LogLevelWarn:
+		slogLevel = slog.LevelWarn
+	default:
+		slogLevel = slog.LevelError
+	}
+
+	ctx := context.Background()
+	if !x.logger.Enabled(ctx, slogLevel) {
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, slog.Any(key, fields[key]))
+	}
+	x.logger.LogAttrs(ctx, slogLevel, msg, attrs...)
+}
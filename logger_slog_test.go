@@ -0,0 +1,33 @@
+//go:build go1.21
+// +build go1.21
+
+package gofalcon_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := gofalcon.NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.Log(gofalcon.LogLevelDebug, "ignored", nil)
+	logger.Log(gofalcon.LogLevelWarn, "Reconnect DataFeedURL", gofalcon.LogFields{
+		gofalcon.LogKeyPartition: 1,
+		gofalcon.LogKeyAppID:     "app",
+	})
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "WARN", record["level"])
+	assert.Equal(t, "Reconnect DataFeedURL", record["msg"])
+	assert.Equal(t, 1.0, record["partition"])
+	assert.Equal(t, "app", record["app_id"])
+}
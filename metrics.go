@@ -0,0 +1,52 @@
+package gofalcon
+
+import (
+	"time"
+)
+
+// Metrics receives measurements of Client and event stream. Implementations must be safe for concurrent use. prommetrics package provides implementation for Prometheus. Embed NopMetrics to implement only a part of methods.
+type Metrics interface {
+	// ObserveRequest is called for each attempt of API request sent by SendRequest. status is 0 if no response is received.
+	ObserveRequest(method, path string, status int, elapsed time.Duration)
+	// ObserveTokenRefresh is called when OAuth2TokenSource retrieves new access token. err is nil if succeeded.
+	ObserveTokenRefresh(err error)
+	// ObserveRateLimit is called when API response has X-RateLimit-* headers.
+	ObserveRateLimit(rl RateLimit)
+	// ObserveStreamEvent is called for each event received from event stream.
+	ObserveStreamEvent(appID string, partition int, meta *StreamEventMetaData)
+	// ObserveStreamReconnect is called when event stream of the partition reconnects.
+	ObserveStreamReconnect(appID string, partition int)
+	// ObserveStreamRefresh is called for each attempt to refresh active stream session. err is nil if succeeded.
+	ObserveStreamRefresh(appID string, partition int, err error)
+}
+
+// WithMetrics sets Metrics to the client. nil disables metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(client *Client) {
+		if metrics == nil {
+			metrics = NopMetrics{}
+		}
+		client.metrics = metrics
+	}
+}
+
+// NopMetrics discards all measurements.
+type NopMetrics struct{}
+
+// ObserveRequest implements Metrics.
+func (x NopMetrics) ObserveRequest(method, path string, status int, elapsed time.Duration) {}
+
+// ObserveTokenRefresh implements Metrics.
+func (x NopMetrics) ObserveTokenRefresh(err error) {}
+
+// ObserveRateLimit implements Metrics.
+func (x NopMetrics) ObserveRateLimit(rl RateLimit) {}
+
+// ObserveStreamEvent implements Metrics.
+func (x NopMetrics) ObserveStreamEvent(appID string, partition int, meta *StreamEventMetaData) {}
+
+// ObserveStreamReconnect implements Metrics.
+func (x NopMetrics) ObserveStreamReconnect(appID string, partition int) {}
+
+// ObserveStreamRefresh implements Metrics.
+func (x NopMetrics) ObserveStreamRefresh(appID string, partition int, err error) {}
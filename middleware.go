@@ -0,0 +1,59 @@
+package gofalcon
+
+import (
+	"net/http"
+	"time"
+)
+
+// Middleware hooks HTTP requests sent by Client, including OAuth2 token requests and event stream (DataFeedURL) connections. It's useful for audit logging, custom headers, request signing, metrics and tracing. Any hook can be nil.
+type Middleware struct {
+	// BeforeRequest is called before each attempt of request. It can modify req or return a new request (e.g. with context of a span). Request body can be read by req.GetBody. Returning error aborts the request without retry.
+	BeforeRequest func(req *http.Request) (*http.Request, error)
+
+	// AfterResponse is called when response is received regardless of status code. Body of API response has been buffered and can be read by the hook. Body of event stream must not be read because it's consumed by the client.
+	AfterResponse func(req *http.Request, resp *http.Response, elapsed time.Duration)
+
+	// OnError is called when request fails by transport error or error response of API (*APIError). It's also called after AfterResponse for error response.
+	OnError func(req *http.Request, err error, elapsed time.Duration)
+}
+
+// WithMiddleware appends middleware to the client. BeforeRequest hooks are called in order of the given middleware, and AfterResponse and OnError hooks are called in reverse order.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(client *Client) {
+		client.middleware = append(client.middleware, middleware...)
+	}
+}
+
+type middlewareChain []Middleware
+
+func (x middlewareChain) before(req *http.Request) (*http.Request, error) {
+	for _, mw := range x {
+		if mw.BeforeRequest == nil {
+			continue
+		}
+		newReq, err := mw.BeforeRequest(req)
+		if err != nil {
+			return req, err
+		}
+		if newReq != nil {
+			req = newReq
+		}
+	}
+	return req, nil
+}
+
+func (x middlewareChain) after(req *http.Request, resp *http.Response, elapsed time.Duration) {
+	for i := len(x) - 1; i >= 0; i-- {
+		if x[i].AfterResponse != nil {
+			x[i].AfterResponse(req, resp, elapsed)
+		}
+	}
+}
+
+func (x middlewareChain) onError(req *http.Request, err error, elapsed time.Duration) {
+	for i := len(x) - 1; i >= 0; i-- {
+		if x[i].OnError != nil {
+			x[i].OnError(req, err, elapsed)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package gofalcon_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// headerRecorder is http.RoundTripper to capture headers sent by client.
+type headerRecorder struct {
+	mutex   sync.Mutex
+	headers map[string]http.Header
+}
+
+func (x *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	x.mutex.Lock()
+	x.headers[req.URL.Path] = req.Header.Clone()
+	x.mutex.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (x *headerRecorder) get(path string) http.Header {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.headers[path]
+}
+
+func TestMiddleware(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	server.SeedDevices(1)
+	server.SeedEvents(0, 1)
+
+	var mutex sync.Mutex
+	var calls []string
+	record := func(s string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, s)
+	}
+
+	transport := &headerRecorder{headers: map[string]http.Header{}}
+	client, err := server.NewClient(
+		gofalcon.WithTransport(transport),
+		gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{MaxAttempts: 1}),
+		gofalcon.WithMiddleware(gofalcon.Middleware{
+			BeforeRequest: func(req *http.Request) (*http.Request, error) {
+				record("before1 " + req.URL.Path)
+				req.Header.Set("X-Audit-Id", "audit")
+				return req, nil
+			},
+			AfterResponse: func(req *http.Request, resp *http.Response, elapsed time.Duration) {
+				record(fmt.Sprintf("after1 %s %d", req.URL.Path, resp.StatusCode))
+			},
+			OnError: func(req *http.Request, err error, elapsed time.Duration) {
+				record("error1 " + req.URL.Path)
+			},
+		}, gofalcon.Middleware{
+			BeforeRequest: func(req *http.Request) (*http.Request, error) {
+				record("before2 " + req.URL.Path)
+				return nil, nil // nil keeps the request
+			},
+			AfterResponse: func(req *http.Request, resp *http.Response, elapsed time.Duration) {
+				record(fmt.Sprintf("after2 %s %d", req.URL.Path, resp.StatusCode))
+				if req.URL.Path == "/devices/queries/devices/v1" {
+					// Buffered body is readable
+					body, err := ioutil.ReadAll(resp.Body)
+					assert.NoError(t, err)
+					assert.Contains(t, string(body), "resources")
+				}
+			},
+			OnError: func(req *http.Request, err error, elapsed time.Duration) {
+				record("error2 " + req.URL.Path)
+				assert.True(t, gofalcon.IsNotFound(err))
+			},
+		}),
+	)
+	require.NoError(t, err)
+
+	reset := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = nil
+	}
+	getCalls := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, calls...)
+	}
+
+	t.Run("hooks are called in order", func(t *testing.T) {
+		reset()
+		output, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(output.Resources))
+
+		path := "/devices/queries/devices/v1"
+		assert.Equal(t, []string{
+			"before1 " + path,
+			"before2 " + path,
+			"after2 " + path + " 200",
+			"after1 " + path + " 200",
+		}, getCalls())
+		assert.Equal(t, "audit", transport.get(path).Get("X-Audit-Id"))
+	})
+
+	t.Run("OnError is called for error response", func(t *testing.T) {
+		reset()
+		server.InjectStatus("/devices/queries/", http.StatusNotFound, 1)
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.Error(t, err)
+
+		path := "/devices/queries/devices/v1"
+		assert.Equal(t, []string{
+			"before1 " + path,
+			"before2 " + path,
+			"after2 " + path + " 404",
+			"after1 " + path + " 404",
+			"error2 " + path,
+			"error1 " + path,
+		}, getCalls())
+	})
+
+	t.Run("event stream is hooked", func(t *testing.T) {
+		reset()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{})
+		q := <-ch
+		require.NoError(t, q.Error)
+
+		assert.Contains(t, getCalls(), "after1 /sensors/entities/datafeed/v1/0 200")
+		assert.Equal(t, "audit", transport.get("/sensors/entities/datafeed/v1/0").Get("X-Audit-Id"))
+	})
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+
+	errAbort := errors.New("abort")
+	client, err := server.NewClient(gofalcon.WithMiddleware(gofalcon.Middleware{
+		BeforeRequest: func(req *http.Request) (*http.Request, error) {
+			if req.URL.Path == "/devices/queries/devices/v1" {
+				return nil, errAbort
+			}
+			return req, nil
+		},
+	}))
+	require.NoError(t, err)
+
+	_, err = client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+	require.Error(t, err)
+	assert.Equal(t, errAbort, errors.Cause(err))
+
+	var sent int
+	for _, req := range server.Requests() {
+		if req.Path == "/devices/queries/devices/v1" {
+			sent++
+		}
+	}
+	assert.Equal(t, 0, sent)
+}
@@ -0,0 +1,260 @@
+package gofalcon
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MSSPAPI provides Flight Control APIs for MSSP (parent CID)
+type MSSPAPI struct {
+	client *Client
+}
+
+// QueryChildrenInput is arguments of QueryChildren
+type QueryChildrenInput struct {
+	Offset *int
+	Limit  *int
+	Sort   *string
+}
+
+// QueryChildrenOutput is a result of QueryChildren
+type QueryChildrenOutput struct {
+	BaseResponse
+	Resources []string `json:"resources"`
+}
+
+// QueryChildren retrieves CIDs of child tenants
+func (x *MSSPAPI) QueryChildren(input *QueryChildrenInput) (*QueryChildrenOutput, error) {
+	return x.QueryChildrenWithContext(context.Background(), input)
+}
+
+// QueryChildrenWithContext is same with QueryChildren, but the request is bound to ctx.
+func (x *MSSPAPI) QueryChildrenWithContext(ctx context.Context, input *QueryChildrenInput) (*QueryChildrenOutput, error) {
+	qs := url.Values{}
+	if input.Offset != nil {
+		qs.Add("offset", fmt.Sprintf("%d", *input.Offset))
+	}
+	if input.Limit != nil {
+		qs.Add("limit", fmt.Sprintf("%d", *input.Limit))
+	}
+	if input.Sort != nil {
+		qs.Add("sort", *input.Sort)
+	}
+
+	req := Request{
+		Method:      "GET",
+		Path:        "mssp/queries/children/v1",
+		QueryString: qs,
+	}
+
+	var output QueryChildrenOutput
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
+		return nil, errors.Wrap(err, "Fail to query children")
+	}
+
+	x.client.log.Debug("Done QueryChildren", LogFields{
+		"qs":          qs.Encode(),
+		LogKeyTraceID: output.Meta.TraceID,
+		LogKeyPath:    req.Path,
+		"returned":    len(output.Resources),
+	})
+
+	return &output, nil
+}
+
+// QueryChildrenPaginator returns Paginator to retrieve all CIDs of child tenants. Offset and Limit of input are ignored, use Paginator.Limit instead.
+func (x *MSSPAPI) QueryChildrenPaginator(input *QueryChildrenInput) *Paginator {
+	return NewPaginator(OffsetPagination, func(ctx context.Context, page Page) ([]string, MetaData, error) {
+		pageInput := *input
+		pageInput.Offset = Int(page.Offset)
+		if page.Limit > 0 {
+			pageInput.Limit = Int(page.Limit)
+		}
+
+		output, err := x.QueryChildrenWithContext(ctx, &pageInput)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+		return output.Resources, output.Meta, nil
+	})
+}
+
+// --------------------------------------------
+
+// FlightControl manages one authenticated Client per child CID from a single parent credential.
+type FlightControl struct {
+	// Concurrency is number of child tenants processed in parallel by ForEachMember. Default is 4.
+	Concurrency int
+
+	clientID string
+	secret   string
+	options  []Option
+
+	mutex   sync.Mutex
+	parent  *Client
+	members map[string]*Client
+	// memberLocks serializes creation of Client per member CID. A lock is a channel with capacity 1 to be acquired with context.
+	memberLocks map[string]chan struct{}
+}
+
+// NewFlightControl is constructor of FlightControl. options are applied to parent and all member clients.
+func NewFlightControl(clientID, secret string, options ...Option) *FlightControl {
+	return &FlightControl{
+		Concurrency: 4,
+		clientID:    clientID,
+		secret:      secret,
+		options:     options,
+		members:     make(map[string]*Client),
+		memberLocks: make(map[string]chan struct{}),
+	}
+}
+
+// Parent returns authenticated Client of parent CID.
+func (x *FlightControl) Parent(ctx context.Context) (*Client, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.parent != nil {
+		return x.parent, nil
+	}
+
+	client := NewClient(x.options...)
+	if err := client.EnableOAuth2WithContext(ctx, x.clientID, x.secret); err != nil {
+		return nil, errors.Wrap(err, "Fail to authenticate parent CID")
+	}
+	x.parent = client
+	return client, nil
+}
+
+// Member returns authenticated Client of child tenant. The Client is created at first call and reused after that. Concurrent calls for same member CID authenticate only once.
+func (x *FlightControl) Member(ctx context.Context, memberCID string) (*Client, error) {
+	if client := x.member(memberCID); client != nil {
+		return client, nil
+	}
+
+	// Authenticate under lock of the member CID only to not block other members
+	lock := x.memberLock(memberCID)
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-lock }()
+
+	if client := x.member(memberCID); client != nil {
+		return client, nil
+	}
+
+	options := append(append([]Option{}, x.options...), WithMemberCID(memberCID))
+	client := NewClient(options...)
+	if err := client.EnableOAuth2WithContext(ctx, x.clientID, x.secret); err != nil {
+		return nil, errors.Wrapf(err, "Fail to authenticate member CID %s", memberCID)
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.members[memberCID] = client
+	return client, nil
+}
+
+func (x *FlightControl) member(memberCID string) *Client {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.members[memberCID]
+}
+
+func (x *FlightControl) memberLock(memberCID string) chan struct{} {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	lock, ok := x.memberLocks[memberCID]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		x.memberLocks[memberCID] = lock
+	}
+	return lock
+}
+
+// Children retrieves all CIDs of child tenants.
+func (x *FlightControl) Children(ctx context.Context) ([]string, error) {
+	parent, err := x.Parent(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parent.MSSP.QueryChildrenPaginator(&QueryChildrenInput{}).All(ctx)
+}
+
+// MemberError is returned by ForEachMember if fn failed for some child tenants.
+type MemberError struct {
+	Errors map[string]error // key is member CID
+}
+
+func (x *MemberError) Error() string {
+	var msgs []string
+	for cid, err := range x.Errors {
+		msgs = append(msgs, cid+": "+err.Error())
+	}
+	sort.Strings(msgs)
+	return fmt.Sprintf("Fail in %d member CID(s): %s", len(x.Errors), strings.Join(msgs, ", "))
+}
+
+// ForEachMember calls fn with Client of each child tenant in parallel. If memberCIDs is empty, all children retrieved by Children are used. Failure of a child tenant does not stop others and all failures are returned as *MemberError.
+func (x *FlightControl) ForEachMember(ctx context.Context, memberCIDs []string, fn func(ctx context.Context, memberCID string, client *Client) error) error {
+	if len(memberCIDs) == 0 {
+		cids, err := x.Children(ctx)
+		if err != nil {
+			return err
+		}
+		memberCIDs = cids
+	}
+
+	concurrency := x.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	errs := make(map[string]error)
+	sem := make(chan struct{}, concurrency)
+
+Loop:
+	for _, cid := range memberCIDs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break Loop
+		}
+
+		wg.Add(1)
+		go func(cid string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			client, err := x.Member(ctx, cid)
+			if err == nil {
+				err = fn(ctx, cid, client)
+			}
+			if err != nil {
+				errMutex.Lock()
+				errs[cid] = err
+				errMutex.Unlock()
+			}
+		}(cid)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &MemberError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package gofalcon_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlightControl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2/token":
+			require.NoError(t, r.ParseForm())
+			cid := r.PostForm.Get("member_cid")
+			if cid == "broken" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if cid == "" {
+				cid = "parent"
+			}
+			fmt.Fprintf(w, `{"access_token":"%s","token_type":"bearer","expires_in":1799}`, cid)
+
+		case "/mssp/queries/children/v1":
+			w.Write([]byte(`{"meta":{"pagination":{"offset":0,"limit":100,"total":3}},"resources":["child1","child2","broken"]}`))
+
+		case "/devices/queries/devices/v1":
+			cid := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+			fmt.Fprintf(w, `{"resources":["%s"]}`, cid)
+		}
+	}))
+	defer server.Close()
+
+	endpoint := gofalcon.Option(func(client *gofalcon.Client) { client.Endpoint = server.URL })
+	fc := gofalcon.NewFlightControl("id", "secret", endpoint)
+
+	ctx := context.Background()
+	children, err := fc.Children(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"child1", "child2", "broken"}, children)
+
+	var mutex sync.Mutex
+	results := map[string]string{}
+	err = fc.ForEachMember(ctx, nil, func(ctx context.Context, cid string, client *gofalcon.Client) error {
+		output, err := client.Device.QueryDevicesWithContext(ctx, &gofalcon.QueryDevicesInput{})
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		results[cid] = output.Resources[0]
+		return nil
+	})
+
+	assert.Equal(t, map[string]string{"child1": "child1", "child2": "child2"}, results)
+	require.Error(t, err)
+	memberErr, ok := err.(*gofalcon.MemberError)
+	require.True(t, ok)
+	assert.Equal(t, 1, len(memberErr.Errors))
+	assert.Contains(t, memberErr.Errors, "broken")
+}
+
+func TestFlightControlMemberAuthenticatesOnce(t *testing.T) {
+	var tokenCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenCount, 1)
+		w.Write([]byte(`{"access_token":"child1","token_type":"bearer","expires_in":1799}`))
+	}))
+	defer server.Close()
+
+	endpoint := gofalcon.Option(func(client *gofalcon.Client) { client.Endpoint = server.URL })
+	fc := gofalcon.NewFlightControl("id", "secret", endpoint)
+
+	var wg sync.WaitGroup
+	clients := make([]*gofalcon.Client, 16)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := fc.Member(context.Background(), "child1")
+			assert.NoError(t, err)
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCount))
+	for _, client := range clients {
+		assert.Same(t, clients[0], client)
+	}
+}
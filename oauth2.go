@@ -2,10 +2,11 @@ package gofalcon
 
 import (
 	"bytes"
+	"context"
+	"net/http"
 	"net/url"
 
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 // OAuth2API provides oauth2 get token and revoke token operation.
@@ -17,6 +18,7 @@ type OAuth2API struct {
 type TokenInput struct {
 	ClientID     *string // client_id
 	ClientSecret *string // client_secret
+	MemberCID    *string // member_cid
 }
 
 // TokenOutput is a result of Token
@@ -24,10 +26,18 @@ type TokenOutput struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
 	ExpiresIn   int    `json:"expires_in"`
+
+	// Region is value of X-Cs-Region header, that indicates the cloud of the tenant (e.g. "us-2")
+	Region string `json:"-"`
 }
 
 // Token generates an OAuth2 access token
 func (x *OAuth2API) Token(input *TokenInput) (*TokenOutput, error) {
+	return x.TokenWithContext(context.Background(), input)
+}
+
+// TokenWithContext is same with Token, but the request is bound to ctx.
+func (x *OAuth2API) TokenWithContext(ctx context.Context, input *TokenInput) (*TokenOutput, error) {
 	qs := url.Values{}
 	buf := bytes.Buffer{}
 	if input.ClientID != nil {
@@ -36,6 +46,9 @@ func (x *OAuth2API) Token(input *TokenInput) (*TokenOutput, error) {
 	if input.ClientSecret != nil {
 		qs.Add("client_secret", *input.ClientSecret)
 	}
+	if input.MemberCID != nil {
+		qs.Add("member_cid", *input.MemberCID)
+	}
 
 	buf.Write([]byte(qs.Encode()))
 
@@ -44,16 +57,24 @@ func (x *OAuth2API) Token(input *TokenInput) (*TokenOutput, error) {
 		Path:    "oauth2/token",
 		Body:    bytes.NewReader(buf.Bytes()),
 		Headers: []httpHeader{{"Content-Type", "application/x-www-form-urlencoded"}},
+		// Issuing another token has no side effect
+		Idempotent: true,
+		noAuth:     true,
 	}
 
 	var output TokenOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	req.onResponse = func(resp *http.Response) {
+		output.Region = resp.Header.Get("X-Cs-Region")
+	}
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to OAuth2 Token")
 	}
+	x.client.discoverCloud(output.Region)
 
-	Logger.WithFields(logrus.Fields{
-		"client_id": *input.ClientID,
-	}).Debug("Authorized OAuth2")
+	x.client.log.Debug("Authorized OAuth2", LogFields{
+		"client_id":  StringValue(input.ClientID),
+		"member_cid": StringValue(input.MemberCID),
+	})
 
 	return &output, nil
 }
@@ -68,6 +89,11 @@ type RevokeOutput struct{}
 
 // Revoke disable oauth2 token
 func (x *OAuth2API) Revoke(input *RevokeInput) (*RevokeOutput, error) {
+	return x.RevokeWithContext(context.Background(), input)
+}
+
+// RevokeWithContext is same with Revoke, but the request is bound to ctx.
+func (x *OAuth2API) RevokeWithContext(ctx context.Context, input *RevokeInput) (*RevokeOutput, error) {
 	qs := url.Values{}
 	buf := bytes.Buffer{}
 	qs.Add("token", *input.Token)
@@ -81,11 +107,11 @@ func (x *OAuth2API) Revoke(input *RevokeInput) (*RevokeOutput, error) {
 	}
 
 	var output RevokeOutput
-	if err := x.client.SendRequest(req, &output); err != nil {
+	if err := x.client.SendRequestWithContext(ctx, req, &output); err != nil {
 		return nil, errors.Wrap(err, "Fail to revoke OAuth2 Token")
 	}
 
-	Logger.Debug("Revoked OAuth2 Token")
+	x.client.log.Debug("Revoked OAuth2 Token", nil)
 
 	return &output, nil
 }
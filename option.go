@@ -0,0 +1,103 @@
+package gofalcon
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option is functional option of NewClient
+type Option func(client *Client)
+
+// WithHTTPClient replaces http.Client used for both of API request and event stream. Timeout of the client is applied to only API request because event stream is long-lived connection.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithTransport sets http.RoundTripper of http.Client. It's useful to inject custom transport for test.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(client *Client) {
+		client.transport = transport
+	}
+}
+
+// WithTimeout sets timeout of API request. Event stream is not affected.
+func WithTimeout(timeout time.Duration) Option {
+	return func(client *Client) {
+		client.timeout = timeout
+	}
+}
+
+// WithUserAgent sets User-Agent header of all requests.
+func WithUserAgent(userAgent string) Option {
+	return func(client *Client) {
+		client.userAgent = userAgent
+	}
+}
+
+// WithProxy sets proxy server URL. It's applied only if transport is *http.Transport (default).
+func WithProxy(proxyURL *url.URL) Option {
+	return func(client *Client) {
+		client.proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// WithMemberCID sets CID of child tenant for MSSP (Flight Control). EnableOAuth2 retrieves a token of the child tenant with parent's credential.
+func WithMemberCID(memberCID string) Option {
+	return func(client *Client) {
+		client.MemberCID = memberCID
+	}
+}
+
+// WithTLSConfig sets TLS configuration such as custom CA bundle (RootCAs). It's applied only if transport is *http.Transport (default).
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(client *Client) {
+		client.tlsConfig = cfg
+	}
+}
+
+// setupHTTPClient builds http.Client for API request and event stream from options.
+func (x *Client) setupHTTPClient() {
+	base := http.Client{}
+	if x.httpClient != nil {
+		base = *x.httpClient
+	}
+	if x.transport != nil {
+		base.Transport = x.transport
+	}
+
+	if x.proxy != nil || x.tlsConfig != nil {
+		var transport *http.Transport
+		switch t := base.Transport.(type) {
+		case nil:
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			transport = t.Clone()
+		default:
+			x.log.Warn("Proxy and TLS config are ignored because transport is not *http.Transport", nil)
+		}
+
+		if transport != nil {
+			if x.proxy != nil {
+				transport.Proxy = x.proxy
+			}
+			if x.tlsConfig != nil {
+				transport.TLSClientConfig = x.tlsConfig
+			}
+			base.Transport = transport
+		}
+	}
+
+	apiClient := base
+	if x.timeout > 0 {
+		apiClient.Timeout = x.timeout
+	}
+	x.apiHTTPClient = &apiClient
+
+	streamClient := base
+	streamClient.Timeout = 0
+	x.streamHTTPClient = &streamClient
+}
@@ -0,0 +1,98 @@
+// Package oteltrace provides OpenTelemetry implementation of gofalcon.Tracer. Spans are started as children of span in context given to *WithContext methods of the client. Trace context is injected to HTTP request headers by global TextMapPropagator (otel.SetTextMapPropagator).
+//
+//	client := gofalcon.NewClient(gofalcon.WithTracer(oteltrace.New(otel.GetTracerProvider())))
+//	output, err := client.Detection.QueriesDetectsWithContext(ctx, input)
+package oteltrace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/m-mizutani/gofalcon"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is name of tracer created from TracerProvider.
+const InstrumentationName = "github.com/m-mizutani/gofalcon"
+
+// Tracer implements gofalcon.Tracer with OpenTelemetry.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var (
+	_ gofalcon.Tracer          = (*Tracer)(nil)
+	_ gofalcon.TracePropagator = (*Tracer)(nil)
+)
+
+// New creates Tracer from provider. Global TracerProvider is used if provider is nil.
+func New(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: provider.Tracer(InstrumentationName)}
+}
+
+// Start implements gofalcon.Tracer. Spans are created as client span.
+func (x *Tracer) Start(ctx context.Context, name string, attrs gofalcon.SpanAttributes) (context.Context, gofalcon.Span) {
+	ctx, span := x.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(toAttributes(attrs)...),
+	)
+	return ctx, &otelSpan{span: span}
+}
+
+// Inject implements gofalcon.TracePropagator. Trace context of ctx is set to header by global TextMapPropagator. Nothing is set if the propagator is not configured.
+func (x *Tracer) Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (x *otelSpan) SetAttributes(attrs gofalcon.SpanAttributes) {
+	x.span.SetAttributes(toAttributes(attrs)...)
+}
+
+func (x *otelSpan) End(err error) {
+	if err != nil {
+		x.span.RecordError(err)
+		x.span.SetStatus(codes.Error, err.Error())
+	}
+	x.span.End()
+}
+
+// toAttributes converts attrs to attribute.KeyValue sorted by key.
+func toAttributes(attrs gofalcon.SpanAttributes) []attribute.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]attribute.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		switch v := attrs[key].(type) {
+		case string:
+			kvs = append(kvs, attribute.String(key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(key, v))
+		default:
+			kvs = append(kvs, attribute.String(key, fmt.Sprintf("%v", v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,170 @@
+package oteltrace_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/m-mizutani/gofalcon/oteltrace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func findSpans(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func setup(t *testing.T, server *falcontest.Server) (*gofalcon.Client, *tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client, err := server.NewClient(
+		gofalcon.WithTracer(oteltrace.New(provider)),
+		gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{MaxAttempts: 1}),
+	)
+	require.NoError(t, err)
+	return client, recorder, provider
+}
+
+func TestRequestSpan(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	server.SeedDetections(3)
+	client, recorder, provider := setup(t, server)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "playbook")
+	_, err := client.Detection.QueriesDetectsWithContext(ctx, &gofalcon.QueriesDetectsInput{Limit: gofalcon.Int(2)})
+	require.NoError(t, err)
+	parent.End()
+
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range findSpans(recorder, gofalcon.SpanNameRequest) {
+		if attrs(span)[gofalcon.SpanKeyPath].AsString() == "/detects/queries/detects/v1" {
+			spans = append(spans, span)
+		}
+	}
+	require.Equal(t, 1, len(spans))
+	span := spans[0]
+
+	// Trace context is propagated from ctx
+	assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+
+	a := attrs(span)
+	assert.Equal(t, "GET", a[gofalcon.SpanKeyMethod].AsString())
+	assert.Equal(t, int64(200), a[gofalcon.SpanKeyStatusCode].AsInt64())
+	assert.Equal(t, "falcontest", a[gofalcon.SpanKeyTraceID].AsString())
+	assert.Equal(t, int64(1), a[gofalcon.SpanKeyAttempts].AsInt64())
+	assert.Equal(t, int64(2), a[gofalcon.SpanKeyPaginationLimit].AsInt64())
+	assert.Equal(t, int64(3), a[gofalcon.SpanKeyPaginationTotal].AsInt64())
+	assert.Equal(t, codes.Unset, span.Status().Code)
+
+	t.Run("error response", func(t *testing.T) {
+		server.InjectStatus("/devices/queries/", http.StatusNotFound, 1)
+		_, err := client.Device.QueryDevicesWithContext(context.Background(), &gofalcon.QueryDevicesInput{})
+		require.Error(t, err)
+
+		var failed sdktrace.ReadOnlySpan
+		for _, span := range findSpans(recorder, gofalcon.SpanNameRequest) {
+			if attrs(span)[gofalcon.SpanKeyPath].AsString() == "/devices/queries/devices/v1" {
+				failed = span
+			}
+		}
+		require.NotNil(t, failed)
+		assert.Equal(t, codes.Error, failed.Status().Code)
+		assert.Equal(t, int64(404), attrs(failed)[gofalcon.SpanKeyStatusCode].AsInt64())
+	})
+}
+
+func TestDatafeedSpan(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithRefreshInterval(time.Second))
+	defer server.Close()
+	server.SeedEvents(0, 1)
+	client, recorder, _ := setup(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		AppID:         gofalcon.String("trace"),
+		RefreshMargin: time.Millisecond * 900,
+	})
+	go func() {
+		for range ch {
+		}
+	}()
+
+	require.True(t, assert.Eventually(t, func() bool {
+		return len(findSpans(recorder, gofalcon.SpanNameDatafeedRefresh)) > 0
+	}, time.Second*5, time.Millisecond*50))
+
+	connects := findSpans(recorder, gofalcon.SpanNameDatafeedConnect)
+	require.Equal(t, 1, len(connects))
+	assert.Equal(t, int64(0), attrs(connects[0])[gofalcon.SpanKeyPartition].AsInt64())
+	assert.Equal(t, int64(200), attrs(connects[0])[gofalcon.SpanKeyStatusCode].AsInt64())
+
+	refresh := findSpans(recorder, gofalcon.SpanNameDatafeedRefresh)[0]
+	assert.Equal(t, "trace", attrs(refresh)[gofalcon.SpanKeyAppID].AsString())
+
+	// Refresh request is child of refresh span
+	var child bool
+	for _, span := range findSpans(recorder, gofalcon.SpanNameRequest) {
+		if span.Parent().SpanID() == refresh.SpanContext().SpanID() {
+			child = true
+		}
+	}
+	assert.True(t, child)
+}
+
+func TestPropagation(t *testing.T) {
+	orig := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(orig)
+
+	server := falcontest.NewServer()
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	var traceparent string
+	client, err := server.NewClient(
+		gofalcon.WithTracer(oteltrace.New(provider)),
+		gofalcon.WithMiddleware(gofalcon.Middleware{
+			BeforeRequest: func(req *http.Request) (*http.Request, error) {
+				traceparent = req.Header.Get("traceparent")
+				return req, nil
+			},
+		}),
+	)
+	require.NoError(t, err)
+
+	_, err = client.Device.QueryDevicesWithContext(context.Background(), &gofalcon.QueryDevicesInput{})
+	require.NoError(t, err)
+
+	spans := findSpans(recorder, gofalcon.SpanNameRequest)
+	require.NotEqual(t, 0, len(spans))
+	sc := spans[len(spans)-1].SpanContext()
+	assert.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", traceparent)
+}
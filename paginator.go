@@ -0,0 +1,200 @@
+package gofalcon
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxOffset is upper limit of offset + limit of offset based pagination in Falcon API.
+	MaxOffset = 10000
+)
+
+// ErrOffsetLimit is returned by Paginator if more results remain beyond MaxOffset. Use narrower filter or after-token based endpoint to retrieve all results.
+var ErrOffsetLimit = errors.New("Results exceed offset limit of pagination")
+
+// PaginationMode is a type of pagination of "queries" endpoint.
+type PaginationMode int
+
+const (
+	// OffsetPagination uses offset and limit query parameters
+	OffsetPagination PaginationMode = iota
+	// AfterPagination uses continuation token returned in meta.pagination and "after" query parameter
+	AfterPagination
+	// ScrollPagination uses continuation token returned in meta.pagination and "offset" query parameter (e.g. devices/queries/devices-scroll/v1)
+	ScrollPagination
+)
+
+// Page is a position of pagination to be retrieved.
+type Page struct {
+	Offset int    // for OffsetPagination
+	After  string // for AfterPagination and ScrollPagination. Empty for the first page
+	Limit  int    // 0 means default of the endpoint
+}
+
+// PageFunc retrieves IDs of a page. It's used by Paginator.
+type PageFunc func(ctx context.Context, page Page) (ids []string, meta MetaData, err error)
+
+// Paginator walks all pages of "queries" endpoint. It's not safe for concurrent use.
+//
+//	p := client.Detection.QueriesDetectsPaginator(&gofalcon.QueriesDetectsInput{})
+//	for p.HasNext() {
+//		ids, err := p.Next(ctx)
+//		...
+//	}
+type Paginator struct {
+	// Limit is page size. 0 means default of the endpoint.
+	Limit int
+	// Max is maximum number of IDs to be retrieved. 0 means unlimited.
+	Max int
+	// MaxOffset is upper limit of offset + limit for OffsetPagination. 0 means MaxOffset of Falcon API.
+	MaxOffset int
+
+	mode    PaginationMode
+	fetch   PageFunc
+	page    Page
+	fetched int
+	done    bool
+	err     error
+}
+
+// NewPaginator is constructor of Paginator.
+func NewPaginator(mode PaginationMode, fetch PageFunc) *Paginator {
+	return &Paginator{
+		mode:  mode,
+		fetch: fetch,
+	}
+}
+
+func (x *Paginator) maxOffset() int {
+	if x.MaxOffset > 0 {
+		return x.MaxOffset
+	}
+	return MaxOffset
+}
+
+// HasNext returns true if next page may exist.
+func (x *Paginator) HasNext() bool {
+	return !x.done
+}
+
+// Next retrieves IDs of next page. It returns ErrOffsetLimit if remaining results can not be retrieved by offset based pagination.
+func (x *Paginator) Next(ctx context.Context) ([]string, error) {
+	if x.err != nil {
+		x.done = true
+		return nil, x.err
+	}
+	if x.done {
+		return nil, nil
+	}
+
+	page := x.page
+	page.Limit = x.Limit
+	if x.Max > 0 && (page.Limit == 0 || x.fetched+page.Limit > x.Max) {
+		page.Limit = x.Max - x.fetched
+	}
+	if x.mode == OffsetPagination && page.Limit > 0 && page.Offset+page.Limit > x.maxOffset() {
+		page.Limit = x.maxOffset() - page.Offset
+	}
+
+	ids, meta, err := x.fetch(ctx, page)
+	if err != nil {
+		x.done = true
+		return nil, err
+	}
+
+	if x.Max > 0 && x.fetched+len(ids) >= x.Max {
+		ids = ids[:x.Max-x.fetched]
+		x.done = true
+	}
+	x.fetched += len(ids)
+
+	pagination := meta.Pagenation
+	if len(ids) == 0 || pagination == nil {
+		x.done = true
+		return ids, nil
+	}
+
+	switch x.mode {
+	case OffsetPagination:
+		x.page.Offset = page.Offset + len(ids)
+		if x.page.Offset >= pagination.Total {
+			x.done = true
+		} else if x.page.Offset >= x.maxOffset() && !x.done {
+			x.err = ErrOffsetLimit
+		}
+
+	case AfterPagination, ScrollPagination:
+		x.page.After = pagination.After
+		if x.page.After == "" || x.fetched >= pagination.Total {
+			x.done = true
+		}
+	}
+
+	return ids, nil
+}
+
+// All retrieves IDs of all pages. If ErrOffsetLimit is returned, retrieved IDs are also returned.
+func (x *Paginator) All(ctx context.Context) ([]string, error) {
+	var results []string
+	for x.HasNext() {
+		ids, err := x.Next(ctx)
+		if err == ErrOffsetLimit {
+			return results, err
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, ids...)
+	}
+	return results, nil
+}
+
+// NewRequestPaginator creates Paginator for any "queries" endpoint. offset, limit and after query parameters of req are set by Paginator. Resources of the response must be string.
+func NewRequestPaginator(client *Client, mode PaginationMode, req Request) *Paginator {
+	return NewPaginator(mode, func(ctx context.Context, page Page) ([]string, MetaData, error) {
+		qs := url.Values{}
+		for key, values := range req.QueryString {
+			qs[key] = values
+		}
+		page.setQuery(qs, mode)
+
+		pageReq := req
+		pageReq.QueryString = qs
+
+		var resp Response
+		if err := client.SendRequestWithContext(ctx, pageReq, &resp); err != nil {
+			return nil, MetaData{}, err
+		}
+
+		ids := make([]string, len(resp.Resources))
+		for i, resource := range resp.Resources {
+			id, ok := resource.(string)
+			if !ok {
+				return nil, MetaData{}, fmt.Errorf("Resource is not string ID: %v", resource)
+			}
+			ids[i] = id
+		}
+		return ids, resp.Meta, nil
+	})
+}
+
+func (x Page) setQuery(qs url.Values, mode PaginationMode) {
+	if x.Limit > 0 {
+		qs.Set("limit", fmt.Sprintf("%d", x.Limit))
+	}
+	switch mode {
+	case OffsetPagination:
+		qs.Set("offset", fmt.Sprintf("%d", x.Offset))
+	case AfterPagination:
+		if x.After != "" {
+			qs.Set("after", x.After)
+		}
+	case ScrollPagination:
+		if x.After != "" {
+			qs.Set("offset", x.After)
+		}
+	}
+}
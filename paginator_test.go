@@ -0,0 +1,144 @@
+package gofalcon_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPaginationServer responds IDs "id-0" to "id-{total-1}". Offset based if scroll is false, otherwise continuation token is returned as string offset.
+func newPaginationServer(t *testing.T, total int, scroll bool, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.RawQuery)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit == 0 {
+			limit = 100
+		}
+
+		ids := []string{}
+		for i := offset; i < offset+limit && i < total; i++ {
+			ids = append(ids, fmt.Sprintf("id-%d", i))
+		}
+
+		pagination := map[string]interface{}{"limit": limit, "total": total, "offset": offset}
+		if scroll {
+			next := ""
+			if offset+limit < total {
+				next = strconv.Itoa(offset + limit)
+			}
+			pagination["offset"] = next
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"meta":      map[string]interface{}{"pagination": pagination},
+			"resources": ids,
+		}))
+	}))
+}
+
+func TestPaginator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Walk all pages of typed API", func(t *testing.T) {
+		var requests []string
+		server := newPaginationServer(t, 25, false, &requests)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		p := client.Detection.QueriesDetectsPaginator(&gofalcon.QueriesDetectsInput{
+			Filter: gofalcon.String("status:'new'"),
+		})
+		p.Limit = 10
+		ids, err := p.All(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 25, len(ids))
+		assert.Equal(t, "id-24", ids[24])
+		assert.Equal(t, 3, len(requests))
+	})
+
+	t.Run("Stop at Max", func(t *testing.T) {
+		var requests []string
+		server := newPaginationServer(t, 25, false, &requests)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		p := client.Device.QueryDevicesPaginator(&gofalcon.QueryDevicesInput{})
+		p.Limit = 10
+		p.Max = 12
+		ids, err := p.All(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 12, len(ids))
+		assert.Equal(t, 2, len(requests))
+	})
+
+	t.Run("Offset limit", func(t *testing.T) {
+		var requests []string
+		server := newPaginationServer(t, 12000, false, &requests)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		p := gofalcon.NewRequestPaginator(client, gofalcon.OffsetPagination, gofalcon.Request{
+			Method: "GET",
+			Path:   "detects/queries/detects/v1",
+		})
+		p.Limit = 3000
+		ids, err := p.All(ctx)
+		assert.Equal(t, gofalcon.ErrOffsetLimit, err)
+		assert.Equal(t, gofalcon.MaxOffset, len(ids))
+		assert.Equal(t, "limit=1000&offset=9000", requests[len(requests)-1])
+	})
+
+	t.Run("Custom offset limit", func(t *testing.T) {
+		var requests []string
+		server := newPaginationServer(t, 30, false, &requests)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		p := gofalcon.NewRequestPaginator(client, gofalcon.OffsetPagination, gofalcon.Request{
+			Method: "GET",
+			Path:   "detects/queries/detects/v1",
+		})
+		p.Limit = 8
+		p.MaxOffset = 20
+		ids, err := p.All(ctx)
+		assert.Equal(t, gofalcon.ErrOffsetLimit, err)
+		assert.Equal(t, 20, len(ids))
+		assert.Equal(t, "limit=4&offset=16", requests[len(requests)-1])
+	})
+
+	t.Run("Scroll pagination", func(t *testing.T) {
+		var requests []string
+		server := newPaginationServer(t, 25, true, &requests)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		p := gofalcon.NewRequestPaginator(client, gofalcon.ScrollPagination, gofalcon.Request{
+			Method: "GET",
+			Path:   "devices/queries/devices-scroll/v1",
+		})
+		p.Limit = 10
+		ids, err := p.All(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 25, len(ids))
+		assert.Equal(t, []string{"limit=10", "limit=10&offset=10", "limit=10&offset=20"}, requests)
+	})
+}
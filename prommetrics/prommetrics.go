@@ -0,0 +1,206 @@
+// Package prommetrics exports metrics of gofalcon.Client to Prometheus. Metrics are registered to the given registry and wired by gofalcon.WithMetrics.
+//
+//	metrics, err := prommetrics.New(prometheus.DefaultRegisterer)
+//	client := gofalcon.NewClient(gofalcon.WithMetrics(metrics))
+//
+// Rate of event stream (events/sec) is calculated by rate() of gofalcon_stream_events_total in PromQL.
+package prommetrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace is prefix of metric names.
+const DefaultNamespace = "gofalcon"
+
+// Option configures Metrics
+type Option func(x *config)
+
+type config struct {
+	namespace   string
+	constLabels prometheus.Labels
+	buckets     []float64
+}
+
+// WithNamespace replaces prefix of metric names. Default is DefaultNamespace.
+func WithNamespace(namespace string) Option {
+	return func(x *config) {
+		x.namespace = namespace
+	}
+}
+
+// WithConstLabels adds constant labels (e.g. tenant name) to all metrics. It's required to register metrics of multiple clients to one registry.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(x *config) {
+		x.constLabels = labels
+	}
+}
+
+// WithBuckets replaces buckets of request duration histogram. Default is prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(x *config) {
+		x.buckets = buckets
+	}
+}
+
+// Metrics implements gofalcon.Metrics with Prometheus collectors.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	tokenRefreshes  *prometheus.CounterVec
+	rateLimit       prometheus.Gauge
+	rateRemaining   prometheus.Gauge
+
+	streamEvents     *prometheus.CounterVec
+	streamOffset     *prometheus.GaugeVec
+	streamLag        *prometheus.GaugeVec
+	streamReconnects *prometheus.CounterVec
+	streamRefreshes  *prometheus.CounterVec
+}
+
+var _ gofalcon.Metrics = (*Metrics)(nil)
+
+// New creates Metrics and registers its collectors to registerer.
+func New(registerer prometheus.Registerer, options ...Option) (*Metrics, error) {
+	cfg := &config{
+		namespace: DefaultNamespace,
+		buckets:   prometheus.DefBuckets,
+	}
+	for _, opt := range options {
+		opt(cfg)
+	}
+
+	streamLabels := []string{"app_id", "partition"}
+	x := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "requests_total",
+			Help:        "Number of API requests by method, path and status. status is 0 if no response is received.",
+			ConstLabels: cfg.constLabels,
+		}, []string{"method", "path", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        "request_duration_seconds",
+			Help:        "Latency of API requests by method and path.",
+			ConstLabels: cfg.constLabels,
+			Buckets:     cfg.buckets,
+		}, []string{"method", "path"}),
+		tokenRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "token_refreshes_total",
+			Help:        "Number of OAuth2 token retrievals by result (success or failure).",
+			ConstLabels: cfg.constLabels,
+		}, []string{"result"}),
+		rateLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        "rate_limit",
+			Help:        "X-RateLimit-Limit of the last API response.",
+			ConstLabels: cfg.constLabels,
+		}),
+		rateRemaining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        "rate_limit_remaining",
+			Help:        "X-RateLimit-Remaining of the last API response.",
+			ConstLabels: cfg.constLabels,
+		}),
+		streamEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "stream_events_total",
+			Help:        "Number of events received from event stream by partition.",
+			ConstLabels: cfg.constLabels,
+		}, streamLabels),
+		streamOffset: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        "stream_last_offset",
+			Help:        "Offset of the last event received from event stream by partition.",
+			ConstLabels: cfg.constLabels,
+		}, streamLabels),
+		streamLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        "stream_lag_seconds",
+			Help:        "Elapsed time from eventCreationTime to receipt of the last event by partition.",
+			ConstLabels: cfg.constLabels,
+		}, streamLabels),
+		streamReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "stream_reconnects_total",
+			Help:        "Number of reconnections of event stream by partition.",
+			ConstLabels: cfg.constLabels,
+		}, streamLabels),
+		streamRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "stream_refreshes_total",
+			Help:        "Number of attempts to refresh active stream session by partition and result (success or failure).",
+			ConstLabels: cfg.constLabels,
+		}, append(streamLabels, "result")),
+	}
+
+	for _, c := range []prometheus.Collector{
+		x.requests,
+		x.requestDuration,
+		x.tokenRefreshes,
+		x.rateLimit,
+		x.rateRemaining,
+		x.streamEvents,
+		x.streamOffset,
+		x.streamLag,
+		x.streamReconnects,
+		x.streamRefreshes,
+	} {
+		if err := registerer.Register(c); err != nil {
+			return nil, errors.Wrap(err, "Fail to register collector of gofalcon")
+		}
+	}
+
+	return x, nil
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveRequest implements gofalcon.Metrics.
+func (x *Metrics) ObserveRequest(method, path string, status int, elapsed time.Duration) {
+	x.requests.WithLabelValues(method, path, strconv.Itoa(status)).Inc()
+	x.requestDuration.WithLabelValues(method, path).Observe(elapsed.Seconds())
+}
+
+// ObserveTokenRefresh implements gofalcon.Metrics.
+func (x *Metrics) ObserveTokenRefresh(err error) {
+	x.tokenRefreshes.WithLabelValues(result(err)).Inc()
+}
+
+// ObserveRateLimit implements gofalcon.Metrics.
+func (x *Metrics) ObserveRateLimit(rl gofalcon.RateLimit) {
+	x.rateLimit.Set(float64(rl.Limit))
+	x.rateRemaining.Set(float64(rl.Remaining))
+}
+
+// ObserveStreamEvent implements gofalcon.Metrics.
+func (x *Metrics) ObserveStreamEvent(appID string, partition int, meta *gofalcon.StreamEventMetaData) {
+	p := strconv.Itoa(partition)
+	x.streamEvents.WithLabelValues(appID, p).Inc()
+	x.streamOffset.WithLabelValues(appID, p).Set(float64(meta.Offset))
+	if meta.EventCreationTime > 0 {
+		created := time.Unix(0, meta.EventCreationTime*int64(time.Millisecond))
+		x.streamLag.WithLabelValues(appID, p).Set(time.Since(created).Seconds())
+	}
+}
+
+// ObserveStreamReconnect implements gofalcon.Metrics.
+func (x *Metrics) ObserveStreamReconnect(appID string, partition int) {
+	x.streamReconnects.WithLabelValues(appID, strconv.Itoa(partition)).Inc()
+}
+
+// ObserveStreamRefresh implements gofalcon.Metrics.
+func (x *Metrics) ObserveStreamRefresh(appID string, partition int, err error) {
+	x.streamRefreshes.WithLabelValues(appID, strconv.Itoa(partition), result(err)).Inc()
+}
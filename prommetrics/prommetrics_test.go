@@ -0,0 +1,159 @@
+package prommetrics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/m-mizutani/gofalcon/prommetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// value returns value of counter or gauge that has all of labels. It returns -1 if not found.
+func value(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	MetricLoop:
+		for _, m := range family.GetMetric() {
+			for key, v := range labels {
+				found := false
+				for _, pair := range m.GetLabel() {
+					if pair.GetName() == key && pair.GetValue() == v {
+						found = true
+					}
+				}
+				if !found {
+					continue MetricLoop
+				}
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return -1
+}
+
+func TestMetrics(t *testing.T) {
+	server := falcontest.NewServer()
+	defer server.Close()
+	server.SeedDevices(1)
+	server.SeedEvents(0, 3)
+	server.DropStream(1, 1)
+
+	reg := prometheus.NewRegistry()
+	metrics, err := prommetrics.New(reg)
+	require.NoError(t, err)
+
+	client, err := server.NewClient(
+		gofalcon.WithMetrics(metrics),
+		gofalcon.WithRetryPolicy(gofalcon.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, value(t, reg, "gofalcon_token_refreshes_total", map[string]string{"result": "success"}))
+
+	t.Run("API requests", func(t *testing.T) {
+		server.InjectRateLimit("/devices/queries/", 1, time.Second)
+		_, err := client.Device.QueryDevices(&gofalcon.QueryDevicesInput{})
+		require.NoError(t, err)
+
+		path := "/devices/queries/devices/v1"
+		assert.Equal(t, 1.0, value(t, reg, "gofalcon_requests_total", map[string]string{"method": "GET", "path": path, "status": "429"}))
+		assert.Equal(t, 1.0, value(t, reg, "gofalcon_requests_total", map[string]string{"method": "GET", "path": path, "status": "200"}))
+		assert.Equal(t, 2.0, value(t, reg, "gofalcon_request_duration_seconds", map[string]string{"method": "GET", "path": path}))
+		assert.Equal(t, 6000.0, value(t, reg, "gofalcon_rate_limit", nil))
+		assert.Equal(t, 0.0, value(t, reg, "gofalcon_rate_limit_remaining", nil))
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+			AppID:     gofalcon.String("metrics"),
+			Reconnect: &gofalcon.ReconnectPolicy{MinBackoff: time.Millisecond},
+		})
+
+		received := 0
+		for received < 3 {
+			select {
+			case q := <-ch:
+				require.NoError(t, q.Error)
+				if q.Meta != nil {
+					received++
+				}
+			case <-time.After(time.Second * 5):
+				require.Fail(t, "timeout")
+			}
+		}
+
+		labels := map[string]string{"app_id": "metrics", "partition": "0"}
+		assert.Equal(t, 3.0, value(t, reg, "gofalcon_stream_events_total", labels))
+		assert.Equal(t, 2.0, value(t, reg, "gofalcon_stream_last_offset", labels))
+		assert.Equal(t, 1.0, value(t, reg, "gofalcon_stream_reconnects_total", labels))
+		lag := value(t, reg, "gofalcon_stream_lag_seconds", labels)
+		assert.True(t, lag >= 0 && lag < 60, "lag = %f", lag)
+	})
+}
+
+func TestMetricsRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := prommetrics.New(reg)
+	require.NoError(t, err)
+
+	// Same metrics can not be registered twice
+	_, err = prommetrics.New(reg)
+	assert.Error(t, err)
+
+	_, err = prommetrics.New(reg, prommetrics.WithNamespace("other"))
+	require.NoError(t, err)
+
+	// Const labels distinguish metrics of clients
+	multi := prometheus.NewRegistry()
+	_, err = prommetrics.New(multi, prommetrics.WithConstLabels(prometheus.Labels{"tenant": "a"}))
+	require.NoError(t, err)
+	_, err = prommetrics.New(multi, prommetrics.WithConstLabels(prometheus.Labels{"tenant": "b"}))
+	require.NoError(t, err)
+}
+
+func TestStreamRefreshMetrics(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithRefreshInterval(time.Second))
+	defer server.Close()
+	server.SeedEvents(0, 1)
+
+	reg := prometheus.NewRegistry()
+	metrics, err := prommetrics.New(reg)
+	require.NoError(t, err)
+	client, err := server.NewClient(gofalcon.WithMetrics(metrics))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		AppID:         gofalcon.String("refresh"),
+		RefreshMargin: time.Millisecond * 900,
+	})
+	go func() {
+		for range ch {
+		}
+	}()
+
+	labels := map[string]string{"app_id": "refresh", "partition": "0", "result": "success"}
+	assert.Eventually(t, func() bool {
+		return value(t, reg, "gofalcon_stream_refreshes_total", labels) >= 1
+	}, time.Second*5, time.Millisecond*50)
+}
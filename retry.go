@@ -0,0 +1,159 @@
+package gofalcon
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy configures retry of API request. A request is retried when Falcon API responds HTTP 429 (rate limited). HTTP 5xx (server error) and transient transport error (timeout, connection reset and unexpected EOF) are retried only for GET, HEAD and Request with Idempotent because the server may have applied the request already.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one. 1 or less disables retry.
+	MaxAttempts int
+	// MinBackoff is wait time before the first retry. It's doubled by each retry.
+	MinBackoff time.Duration
+	// MaxBackoff is upper limit of wait time. 0 means no limit.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used if WithRetryPolicy is not given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  time.Second * 30,
+}
+
+// WithRetryPolicy replaces retry policy of API request.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(client *Client) {
+		client.retryPolicy = policy
+	}
+}
+
+// backoff returns wait time before next attempt. Exponential backoff with jitter is applied: the result is between a half and full of exponential backoff.
+func (x RetryPolicy) backoff(attempt int) time.Duration {
+	wait := x.MinBackoff
+	for i := 1; i < attempt && wait < math.MaxInt64/2; i++ {
+		if x.MaxBackoff > 0 && wait >= x.MaxBackoff {
+			break
+		}
+		wait *= 2
+	}
+	if x.MaxBackoff > 0 && wait > x.MaxBackoff {
+		wait = x.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+// retryable returns true if req can be sent again after err. HTTP 429 means the request was not processed, then it's always retryable. Other retryable status (5xx) and transient transport error are retried only if req is idempotent.
+func (x Request) retryable(err error) bool {
+	if IsRateLimited(err) {
+		return true
+	}
+	if !IsRetryable(err) && !isTransientError(err) {
+		return false
+	}
+
+	switch x.Method {
+	case "", http.MethodGet, http.MethodHead:
+		return true
+	}
+	return x.Idempotent
+}
+
+// isTransientError returns true if err is transport error that may be recovered by retry: timeout, unexpected EOF of response and connection reset by peer.
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RateLimit is the last seen rate limit state reported by X-RateLimit-* headers of Falcon API.
+type RateLimit struct {
+	Limit      int       // X-RateLimit-Limit
+	Remaining  int       // X-RateLimit-Remaining
+	RetryAfter time.Time // X-RateLimit-RetryAfter
+	UpdatedAt  time.Time
+}
+
+// RateLimit returns the last seen rate limit state. UpdatedAt is zero if no response has rate limit headers yet.
+func (x *Client) RateLimit() RateLimit {
+	x.rateLimitMutex.Lock()
+	defer x.rateLimitMutex.Unlock()
+	return x.rateLimit
+}
+
+func (x *Client) updateRateLimit(hdr http.Header) {
+	remaining := hdr.Get("X-RateLimit-Remaining")
+	if remaining == "" {
+		return
+	}
+
+	var rl RateLimit
+	rl.Remaining, _ = strconv.Atoi(remaining)
+	rl.Limit, _ = strconv.Atoi(hdr.Get("X-RateLimit-Limit"))
+	if retryAfter, err := strconv.ParseInt(hdr.Get("X-RateLimit-RetryAfter"), 10, 64); err == nil {
+		rl.RetryAfter = time.Unix(retryAfter, 0)
+	}
+	rl.UpdatedAt = time.Now()
+
+	x.rateLimitMutex.Lock()
+	x.rateLimit = rl
+	x.rateLimitMutex.Unlock()
+
+	x.metrics.ObserveRateLimit(rl)
+}
+
+// rateLimitWait returns wait time until rate limit is reset. It returns 0 if remaining quota exists.
+func (x *Client) rateLimitWait() time.Duration {
+	rl := x.RateLimit()
+	if rl.UpdatedAt.IsZero() || rl.Remaining > 0 {
+		return 0
+	}
+	if wait := time.Until(rl.RetryAfter); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,447 @@
+package gofalcon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// OverflowPolicy decides behavior of EventStream when the buffer is full because the consumer is slower than the stream.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock stops reading the stream until the consumer receives an event. Falcon may close the stream if it's blocked for a long time.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered event to store the new one.
+	OverflowDropOldest
+	// OverflowDropNewest discards the new event.
+	OverflowDropNewest
+)
+
+// EventStreamStats has counters of the event stream buffer. Set a pointer of EventStreamStats to EventStreamInput.Stats and read counters while the stream is running. All methods are safe for concurrent use.
+type EventStreamStats struct {
+	// int64 fields are accessed atomically, then they are placed at first for alignment of 32-bit platforms.
+	received  int64
+	delivered int64
+	dropped   int64
+	buffered  int64
+	spilled   int64
+}
+
+// Received returns number of events read from the stream and then buffered or dropped.
+func (x *EventStreamStats) Received() int64 { return atomic.LoadInt64(&x.received) }
+
+// Delivered returns number of events sent to the consumer.
+func (x *EventStreamStats) Delivered() int64 { return atomic.LoadInt64(&x.delivered) }
+
+// Dropped returns number of events discarded by OverflowDropOldest or OverflowDropNewest.
+func (x *EventStreamStats) Dropped() int64 { return atomic.LoadInt64(&x.dropped) }
+
+// Buffered returns number of events waiting in memory.
+func (x *EventStreamStats) Buffered() int64 { return atomic.LoadInt64(&x.buffered) }
+
+// Spilled returns number of events waiting in spill file on disk.
+func (x *EventStreamStats) Spilled() int64 { return atomic.LoadInt64(&x.spilled) }
+
+// streamBuffer is placed between partitions and channel of EventStream. Partitions push events without blocking unless policy is OverflowBlock, and forward() sends them to the consumer.
+type streamBuffer struct {
+	size   int
+	policy OverflowPolicy
+	spill  *spillQueue
+	stats  *EventStreamStats
+	log    logger
+
+	mutex  sync.Mutex
+	queue  []*StreamQueue
+	closed bool
+	// spilled is number of events in spill file that are ready to be read
+	spilled int
+
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func newStreamBuffer(input *EventStreamInput, log logger) (*streamBuffer, error) {
+	// Dropped event is never acknowledged, but Ack() of following event commits offset beyond it
+	if input.Checkpoint != nil && input.Overflow != OverflowBlock {
+		return nil, fmt.Errorf("Overflow policy dropping events can not be used with Checkpoint")
+	}
+
+	buf := &streamBuffer{
+		size:     input.QueueSize,
+		policy:   input.Overflow,
+		stats:    input.Stats,
+		log:      log,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+	if buf.size <= 0 {
+		buf.size = StreamEventQueueSize
+	}
+	if buf.stats == nil {
+		buf.stats = &EventStreamStats{}
+	}
+
+	if input.SpillDir != "" {
+		spill, err := newSpillQueue(input.SpillDir, log)
+		if err != nil {
+			return nil, err
+		}
+		buf.spill = spill
+	}
+
+	return buf, nil
+}
+
+func signalChan(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push stores q into the buffer. It returns false if ctx is cancelled while waiting space of the buffer.
+func (x *streamBuffer) push(ctx context.Context, q *StreamQueue) (ok bool) {
+	isEvent := q.Meta != nil
+	defer func() {
+		// Counted after the event is buffered or dropped
+		if isEvent && ok {
+			atomic.AddInt64(&x.stats.received, 1)
+		}
+	}()
+
+	for {
+		x.mutex.Lock()
+
+		// Error and reconnect notice are never dropped nor spilled. Events of a partition are pushed one by one, then they are kept in order even if spill file is written without lock.
+		if !isEvent || (len(x.queue) < x.size && x.spilled == 0) {
+			x.append(q)
+			x.mutex.Unlock()
+			return true
+		}
+		spill := x.spill
+		x.mutex.Unlock()
+
+		// Writing spill file must not block forward() and other partitions
+		if spill != nil {
+			err := spill.push(q)
+			if err == nil {
+				x.mutex.Lock()
+				x.spilled++
+				x.mutex.Unlock()
+				atomic.AddInt64(&x.stats.spilled, 1)
+				signalChan(x.notEmpty)
+				return true
+			}
+			x.log.Warn("Fail to spill event stream, then apply overflow policy", LogFields{LogKeyError: err})
+		}
+
+		x.mutex.Lock()
+		switch x.policy {
+		case OverflowDropNewest:
+			x.mutex.Unlock()
+			atomic.AddInt64(&x.stats.dropped, 1)
+			return true
+
+		case OverflowDropOldest:
+			if x.dropOldest() {
+				x.append(q)
+				x.mutex.Unlock()
+				return true
+			}
+		}
+		x.mutex.Unlock()
+
+		// OverflowBlock, or no event to drop
+		select {
+		case <-x.notFull:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// append must be called with lock
+func (x *streamBuffer) append(q *StreamQueue) {
+	x.queue = append(x.queue, q)
+	if q.Meta != nil {
+		atomic.AddInt64(&x.stats.buffered, 1)
+	}
+	signalChan(x.notEmpty)
+}
+
+// dropOldest removes the oldest event in memory. It must be called with lock.
+func (x *streamBuffer) dropOldest() bool {
+	for i, q := range x.queue {
+		if q.Meta != nil {
+			x.queue = append(x.queue[:i], x.queue[i+1:]...)
+			atomic.AddInt64(&x.stats.buffered, -1)
+			atomic.AddInt64(&x.stats.dropped, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// pop refills memory from spill file and returns the oldest queue. It returns nil if the buffer is empty.
+func (x *streamBuffer) pop() *StreamQueue {
+	x.refill()
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if len(x.queue) == 0 {
+		return nil
+	}
+
+	q := x.queue[0]
+	x.queue[0] = nil
+	x.queue = x.queue[1:]
+	if q.Meta != nil {
+		atomic.AddInt64(&x.stats.buffered, -1)
+	}
+
+	signalChan(x.notFull)
+	return q
+}
+
+// refill moves spilled events to memory while memory has space. Spill file is read without lock, but the event is counted in x.spilled until it's appended to memory. Then push() keeps spilling new events meanwhile and order of events is preserved. It must be called only from forward().
+func (x *streamBuffer) refill() {
+	for {
+		x.mutex.Lock()
+		spill := x.spill
+		ready := x.spilled > 0 && len(x.queue) < x.size
+		x.mutex.Unlock()
+		if !ready {
+			return
+		}
+
+		spilled, err := spill.pop()
+
+		x.mutex.Lock()
+		if err != nil {
+			// Events in broken spill file can not be recovered
+			x.log.Error("Fail to read spilled event stream", LogFields{LogKeyError: err})
+			atomic.AddInt64(&x.stats.dropped, int64(x.spilled))
+			atomic.AddInt64(&x.stats.spilled, -int64(x.spilled))
+			x.spilled = 0
+			spill.reset()
+			x.mutex.Unlock()
+			return
+		}
+		x.spilled--
+		atomic.AddInt64(&x.stats.spilled, -1)
+		x.queue = append(x.queue, spilled)
+		atomic.AddInt64(&x.stats.buffered, 1)
+		x.mutex.Unlock()
+	}
+}
+
+// close notifies forward() that no more queue is pushed.
+func (x *streamBuffer) close() {
+	x.mutex.Lock()
+	x.closed = true
+	x.mutex.Unlock()
+	signalChan(x.notEmpty)
+}
+
+func (x *streamBuffer) isClosed() bool {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.closed && len(x.queue) == 0 && x.spilled == 0
+}
+
+// forward sends buffered queues to ch until the buffer is closed and drained, or ctx is cancelled.
+func (x *streamBuffer) forward(ctx context.Context, ch chan *StreamQueue) {
+	defer func() {
+		x.mutex.Lock()
+		spill := x.spill
+		x.spill = nil
+		x.mutex.Unlock()
+		spill.close()
+	}()
+
+	for {
+		q := x.pop()
+		if q == nil {
+			if x.isClosed() {
+				return
+			}
+			select {
+			case <-x.notEmpty:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !sendStreamQueue(ctx, ch, q) {
+			return
+		}
+		if q.Meta != nil {
+			atomic.AddInt64(&x.stats.delivered, 1)
+		}
+	}
+}
+
+// spillQueue is FIFO queue of events on a temporary file. It has own lock for file I/O apart from streamBuffer. A nil spillQueue is empty queue. The file is only to save memory and deleted by close, it's never used to recover events.
+type spillQueue struct {
+	mutex    sync.Mutex
+	closed   bool
+	file     *os.File
+	writer   *bufio.Writer
+	readFile *os.File
+	reader   *bufio.Reader
+	count    int
+
+	log logger
+
+	// ack can not be written to file, then it's kept in memory
+	acks []func() error
+}
+
+type spillRecord struct {
+	Partition int                 `json:"partition"`
+	Meta      StreamEventMetaData `json:"meta"`
+	Event     json.RawMessage     `json:"event"`
+}
+
+func newSpillQueue(dir string, log logger) (*spillQueue, error) {
+	file, err := ioutil.TempFile(dir, "gofalcon-spill-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Fail to create spill file in %s", dir)
+	}
+
+	log.Debug("Created spill file of event stream", LogFields{LogKeyPath: file.Name()})
+	return &spillQueue{
+		log:    log,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+func (x *spillQueue) push(q *StreamQueue) error {
+	event, err := json.Marshal(q.Event)
+	if err != nil {
+		return errors.Wrap(err, "Fail to marshal event to spill")
+	}
+	raw, err := json.Marshal(spillRecord{Partition: q.Partition, Meta: *q.Meta, Event: event})
+	if err != nil {
+		return errors.Wrap(err, "Fail to marshal event to spill")
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.closed {
+		return errors.New("Spill file is already closed")
+	}
+
+	if _, err := x.file.Seek(0, io.SeekEnd); err != nil {
+		return errors.Wrap(err, "Fail to seek spill file")
+	}
+	if _, err := x.writer.Write(append(raw, '\n')); err != nil {
+		return errors.Wrap(err, "Fail to write spill file")
+	}
+	if err := x.writer.Flush(); err != nil {
+		return errors.Wrap(err, "Fail to write spill file")
+	}
+
+	x.count++
+	x.acks = append(x.acks, q.ack)
+	return nil
+}
+
+func (x *spillQueue) pop() (*StreamQueue, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.reader == nil {
+		readFile, err := os.Open(x.file.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, "Fail to open spill file")
+		}
+		x.readFile = readFile
+		x.reader = bufio.NewReader(readFile)
+	}
+
+	line, err := x.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to read spill file")
+	}
+
+	var record spillRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, errors.Wrap(err, "Fail to unmarshal spilled event")
+	}
+
+	q := &StreamQueue{
+		Partition: record.Partition,
+		Meta:      &record.Meta,
+		Payload:   DecodeStreamEvent(record.Meta.EventType, record.Event),
+		ack:       x.acks[0],
+	}
+	if err := json.Unmarshal(record.Event, &q.Event); err != nil {
+		return nil, errors.Wrap(err, "Fail to unmarshal spilled event")
+	}
+
+	x.acks[0] = nil
+	x.acks = x.acks[1:]
+	x.count--
+	if x.count == 0 {
+		x.truncate()
+	}
+	return q, nil
+}
+
+// reset discards all events and truncates the spill file to reuse it from the beginning.
+func (x *spillQueue) reset() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.truncate()
+}
+
+// truncate must be called with lock
+func (x *spillQueue) truncate() {
+	x.count = 0
+	x.acks = nil
+	if x.readFile != nil {
+		x.readFile.Close()
+		x.readFile = nil
+		x.reader = nil
+	}
+	x.writer.Reset(x.file)
+	if err := x.file.Truncate(0); err != nil {
+		x.log.Warn("Fail to truncate spill file", LogFields{
+			LogKeyPath:  x.file.Name(),
+			LogKeyError: err,
+		})
+	}
+}
+
+func (x *spillQueue) close() {
+	if x == nil {
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.closed = true
+	if x.readFile != nil {
+		x.readFile.Close()
+	}
+	x.file.Close()
+	if err := os.Remove(x.file.Name()); err != nil {
+		x.log.Warn("Fail to remove spill file", LogFields{
+			LogKeyPath:  x.file.Name(),
+			LogKeyError: err,
+		})
+	}
+}
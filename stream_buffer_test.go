@@ -0,0 +1,233 @@
+package gofalcon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSlowConsumer starts event stream and waits until all events of server have been received before reading the channel.
+func startSlowConsumer(t *testing.T, server *eventStreamServer, input *gofalcon.EventStreamInput) []*gofalcon.StreamQueue {
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := client.Sensor.EventStreamWithContext(ctx, input)
+
+	require.True(t, assert.Eventually(t, func() bool {
+		return input.Stats.Received() == int64(server.total)
+	}, time.Second*5, time.Millisecond*10))
+
+	var queues []*gofalcon.StreamQueue
+	for q := range ch {
+		require.NoError(t, q.Error)
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+func offsetsOf(queues []*gofalcon.StreamQueue) []int {
+	var offsets []int
+	for _, q := range queues {
+		offsets = append(offsets, q.Meta.Offset)
+	}
+	return offsets
+}
+
+func TestEventStreamOverflow(t *testing.T) {
+	t.Run("DropNewest", func(t *testing.T) {
+		server := newEventStreamServer(t, 5, 0)
+		defer server.Close()
+
+		stats := &gofalcon.EventStreamStats{}
+		queues := startSlowConsumer(t, server, &gofalcon.EventStreamInput{
+			QueueSize: 2,
+			Overflow:  gofalcon.OverflowDropNewest,
+			Stats:     stats,
+		})
+		// One event can be held by the forwarder in addition to the buffer
+		offsets := offsetsOf(queues)
+		require.True(t, len(offsets) == 2 || len(offsets) == 3)
+		assert.Equal(t, []int{0, 1}, offsets[:2])
+		assert.True(t, sort.IntsAreSorted(offsets))
+		assert.Equal(t, int64(5-len(offsets)), stats.Dropped())
+		assert.Equal(t, int64(len(offsets)), stats.Delivered())
+		assert.Equal(t, int64(0), stats.Buffered())
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		server := newEventStreamServer(t, 5, 0)
+		defer server.Close()
+
+		stats := &gofalcon.EventStreamStats{}
+		queues := startSlowConsumer(t, server, &gofalcon.EventStreamInput{
+			QueueSize: 2,
+			Overflow:  gofalcon.OverflowDropOldest,
+			Stats:     stats,
+		})
+		offsets := offsetsOf(queues)
+		require.True(t, len(offsets) == 2 || len(offsets) == 3)
+		assert.Equal(t, 4, offsets[len(offsets)-1])
+		assert.True(t, sort.IntsAreSorted(offsets))
+		assert.Equal(t, int64(5-len(offsets)), stats.Dropped())
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		server := newEventStreamServer(t, 5, 0)
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+
+		stats := &gofalcon.EventStreamStats{}
+		ch := client.Sensor.EventStream(&gofalcon.EventStreamInput{
+			QueueSize: 2,
+			Stats:     stats,
+		})
+
+		time.Sleep(time.Millisecond * 100)
+		// Buffer + an event held by the forwarder
+		assert.True(t, stats.Received() <= 3)
+		assert.True(t, stats.Buffered() <= 2)
+
+		var queues []*gofalcon.StreamQueue
+		for q := range ch {
+			require.NoError(t, q.Error)
+			queues = append(queues, q)
+		}
+		assert.Equal(t, []int{0, 1, 2, 3, 4}, offsetsOf(queues))
+		assert.Equal(t, int64(0), stats.Dropped())
+	})
+}
+
+func TestEventStreamSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofalcon-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := newEventStreamServer(t, 5, 0)
+	defer server.Close()
+
+	stats := &gofalcon.EventStreamStats{}
+	store := gofalcon.NewMemoryCheckpointStore()
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ch := client.Sensor.EventStream(&gofalcon.EventStreamInput{
+		AppID:      gofalcon.String("myapp"),
+		Checkpoint: store,
+		QueueSize:  2,
+		SpillDir:   dir,
+		Stats:      stats,
+	})
+
+	require.True(t, assert.Eventually(t, func() bool {
+		return stats.Received() == 5
+	}, time.Second*5, time.Millisecond*10))
+	assert.True(t, stats.Spilled() >= 2)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	var queues []*gofalcon.StreamQueue
+	for q := range ch {
+		require.NoError(t, q.Error)
+		queues = append(queues, q)
+
+		detection, ok := q.Payload.(*gofalcon.DetectionSummaryEvent)
+		require.True(t, ok)
+		assert.Equal(t, q.Event["DetectId"], detection.DetectID)
+		require.NoError(t, q.Ack())
+	}
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, offsetsOf(queues))
+	assert.Equal(t, int64(0), stats.Dropped())
+	assert.Equal(t, int64(0), stats.Spilled())
+	assert.Equal(t, int64(5), stats.Delivered())
+
+	offset, found, err := store.Load(context.Background(), "myapp", 0)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 4, offset)
+
+	// Spill file is removed after the stream exits
+	files, err = ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestEventStreamSpillPartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofalcon-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := falcontest.NewServer(falcontest.WithPartitions(4))
+	defer server.Close()
+	for p := 0; p < 4; p++ {
+		server.SeedEvents(p, 50)
+	}
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stats := &gofalcon.EventStreamStats{}
+	ch := client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{
+		QueueSize: 4,
+		SpillDir:  dir,
+		Stats:     stats,
+	})
+
+	// Events of each partition are delivered in order while partitions spill concurrently
+	offsets := map[int][]int{}
+	for n := 0; n < 200; n++ {
+		select {
+		case q := <-ch:
+			require.NoError(t, q.Error)
+			offsets[q.Partition] = append(offsets[q.Partition], q.Meta.Offset)
+			if n%20 == 0 {
+				time.Sleep(time.Millisecond * 10)
+			}
+		case <-time.After(time.Second * 5):
+			require.Fail(t, "events are not received")
+		}
+	}
+
+	for p := 0; p < 4; p++ {
+		require.Equal(t, 50, len(offsets[p]))
+		assert.True(t, sort.IntsAreSorted(offsets[p]), "partition %d: %v", p, offsets[p])
+	}
+	assert.True(t, stats.Spilled() == 0)
+	assert.Equal(t, int64(0), stats.Dropped())
+}
+
+func TestEventStreamOverflowWithCheckpoint(t *testing.T) {
+	server := newEventStreamServer(t, 5, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	for _, policy := range []gofalcon.OverflowPolicy{gofalcon.OverflowDropOldest, gofalcon.OverflowDropNewest} {
+		var queues []*gofalcon.StreamQueue
+		for q := range client.Sensor.EventStream(&gofalcon.EventStreamInput{
+			Checkpoint: gofalcon.NewMemoryCheckpointStore(),
+			Overflow:   policy,
+		}) {
+			queues = append(queues, q)
+		}
+		require.Equal(t, 1, len(queues))
+		assert.Error(t, queues[0].Error)
+	}
+	assert.Equal(t, 0, len(server.requestedOffsets()))
+}
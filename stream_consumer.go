@@ -0,0 +1,255 @@
+package gofalcon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// StreamHandler processes an event of event stream. Returning nil acknowledges the event, and then the offset is committed to CheckpointStore of EventStreamInput.
+type StreamHandler func(ctx context.Context, q *StreamQueue) error
+
+// HandlerError is passed to StreamConsumer.OnError when StreamHandler returned error or panicked.
+type HandlerError struct {
+	Partition int
+	Offset    int
+	EventType string
+	Err       error
+}
+
+func (x *HandlerError) Error() string {
+	return fmt.Sprintf("Fail to handle %s (partition %d, offset %d): %v", x.EventType, x.Partition, x.Offset, x.Err)
+}
+
+// Cause returns original error of the handler.
+func (x *HandlerError) Cause() error { return x.Err }
+
+// Unwrap returns original error of the handler.
+func (x *HandlerError) Unwrap() error { return x.Err }
+
+// StreamConsumer reads event stream and dispatches events to handlers registered by event type.
+//
+// Events of a partition are dispatched in order of offset. If Concurrency is more than 1, handlers of the partition run in parallel, but results are still acknowledged in order of offset. Then an offset is committed only after all older events of the partition have been handled.
+//
+// Partitions are consumed independently. Events are dispatched to a buffered lane of each partition, then slow handlers of a partition do not delay other partitions until LaneSize events are backlogged in the partition.
+type StreamConsumer struct {
+	// Concurrency is number of handlers running in parallel in each partition. Default is 1 that handles events one by one.
+	Concurrency int
+	// LaneSize is number of events buffered for each partition to wait for its handlers. 0 means StreamEventQueueSize. It's raised to Concurrency if smaller.
+	LaneSize int
+
+	// OnError is called with *HandlerError when a handler fails, and with error of event stream. If OnError returns nil, the failed event is acknowledged (skipped) and the consumer continues. If OnError returns error, Run stops and returns it. If OnError is nil, Run stops with the first error.
+	OnError func(err error) error
+	// OnReconnect is called with StreamQueue.Reconnect when the stream of partition is disconnected and reconnecting (see EventStreamInput.Reconnect). It's called from goroutine of Run, then it should return quickly. Reconnection is also logged by the client even if OnReconnect is nil.
+	OnReconnect func(partition int, reconnect *StreamReconnect)
+
+	sensor         *SensorAPI
+	handlers       map[string]StreamHandler
+	defaultHandler StreamHandler
+}
+
+// NewStreamConsumer is constructor of StreamConsumer.
+func NewStreamConsumer(client *Client) *StreamConsumer {
+	return &StreamConsumer{
+		Concurrency: 1,
+		sensor:      client.Sensor,
+		handlers:    make(map[string]StreamHandler),
+	}
+}
+
+// Handle registers handler for eventType (e.g. EventTypeDetectionSummary). It must be called before Run.
+func (x *StreamConsumer) Handle(eventType string, handler StreamHandler) *StreamConsumer {
+	x.handlers[eventType] = handler
+	return x
+}
+
+// HandleDefault registers handler for events that have no handler of the event type. If default handler is not set, such events are acknowledged without processing.
+func (x *StreamConsumer) HandleDefault(handler StreamHandler) *StreamConsumer {
+	x.defaultHandler = handler
+	return x
+}
+
+func (x *StreamConsumer) handler(q *StreamQueue) StreamHandler {
+	if q.Meta != nil {
+		if h, ok := x.handlers[q.Meta.EventType]; ok {
+			return h
+		}
+	}
+	return x.defaultHandler
+}
+
+// Run consumes event stream until ctx is cancelled, all partitions are closed, or OnError returns error. It returns nil if the consumer is stopped by ctx.
+func (x *StreamConsumer) Run(ctx context.Context, input *EventStreamInput) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var failure error
+	var failureMutex sync.Mutex
+	fail := func(err error) {
+		failureMutex.Lock()
+		defer failureMutex.Unlock()
+		if failure == nil {
+			failure = err
+			cancel()
+		}
+	}
+
+	lanes := make(map[int]chan *StreamQueue)
+	var wg sync.WaitGroup
+
+	for q := range x.sensor.EventStreamWithContext(ctx, input) {
+		if q.Reconnect != nil {
+			if x.OnReconnect != nil {
+				x.OnReconnect(q.Partition, q.Reconnect)
+			}
+			continue
+		}
+
+		if q.Error != nil {
+			if err := x.handleError(q.Error); err != nil {
+				fail(err)
+			}
+			continue
+		}
+
+		lane, ok := lanes[q.Partition]
+		if !ok {
+			lane = make(chan *StreamQueue, x.laneSize())
+			lanes[q.Partition] = lane
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				x.consumePartition(ctx, lane, fail)
+			}()
+		}
+
+		select {
+		case lane <- q:
+		case <-ctx.Done():
+		}
+	}
+
+	for _, lane := range lanes {
+		close(lane)
+	}
+	wg.Wait()
+
+	failureMutex.Lock()
+	defer failureMutex.Unlock()
+	return failure
+}
+
+func (x *StreamConsumer) laneSize() int {
+	size := x.LaneSize
+	if size <= 0 {
+		size = StreamEventQueueSize
+	}
+	if size < x.Concurrency {
+		size = x.Concurrency
+	}
+	return size
+}
+
+func (x *StreamConsumer) handleError(err error) error {
+	if x.OnError == nil {
+		return err
+	}
+	return x.OnError(err)
+}
+
+type handlerResult struct {
+	q    *StreamQueue
+	done chan error
+}
+
+// consumePartition runs handlers of a partition. Results are received in order of events to acknowledge them in order.
+func (x *StreamConsumer) consumePartition(ctx context.Context, lane chan *StreamQueue, fail func(err error)) {
+	concurrency := x.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// sem is released after the result has been acknowledged. Then number of running handlers never exceeds concurrency.
+	sem := make(chan struct{}, concurrency)
+	results := make(chan *handlerResult, concurrency)
+
+	var ackWG sync.WaitGroup
+	ackWG.Add(1)
+	go func() {
+		defer ackWG.Done()
+		// Once an event is left unacknowledged, following events must not be acknowledged to avoid committing offset beyond the event.
+		stopped := false
+		for r := range results {
+			err := <-r.done
+			if !stopped && err != nil {
+				if ctx.Err() != nil {
+					stopped = true // Handler may be aborted by the cancellation
+				} else if err := x.handleError(err); err != nil {
+					fail(err)
+					stopped = true
+				}
+			}
+
+			if !stopped {
+				if err := r.q.Ack(); err != nil {
+					if err := x.handleError(errors.Wrap(err, "Fail to acknowledge event")); err != nil {
+						fail(err)
+						stopped = true
+					}
+				}
+			}
+			<-sem
+		}
+	}()
+
+	for q := range lane {
+		if ctx.Err() != nil {
+			continue // Drain lane
+		}
+
+		sem <- struct{}{}
+		r := &handlerResult{q: q, done: make(chan error, 1)}
+		results <- r
+		go func() {
+			r.done <- x.invoke(ctx, r.q)
+		}()
+	}
+
+	close(results)
+	ackWG.Wait()
+}
+
+// invoke calls handler of q. Panic in the handler is recovered and returned as error.
+func (x *StreamConsumer) invoke(ctx context.Context, q *StreamQueue) (err error) {
+	handler := x.handler(q)
+	if handler == nil {
+		return nil
+	}
+
+	hErr := &HandlerError{
+		Partition: q.Partition,
+		Offset:    q.Meta.Offset,
+		EventType: q.Meta.EventType,
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			x.sensor.client.log.Error("Recovered panic in StreamHandler", LogFields{
+				LogKeyPartition: q.Partition,
+				LogKeyOffset:    q.Meta.Offset,
+				"panic":         r,
+			})
+
+			hErr.Err = errors.Errorf("panic: %v", r)
+			err = hErr
+		}
+	}()
+
+	if err := handler(ctx, q); err != nil {
+		hErr.Err = err
+		return hErr
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package gofalcon_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/falcontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamConsumerRouting(t *testing.T) {
+	server := newEventStreamServer(t, 4, 0)
+	server.eventTypes = []string{gofalcon.EventTypeDetectionSummary, gofalcon.EventTypeAuthActivityAudit}
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	var detections, others []int
+	consumer := gofalcon.NewStreamConsumer(client).
+		Handle(gofalcon.EventTypeDetectionSummary, func(ctx context.Context, q *gofalcon.StreamQueue) error {
+			_, ok := q.Payload.(*gofalcon.DetectionSummaryEvent)
+			assert.True(t, ok)
+			detections = append(detections, q.Meta.Offset)
+			return nil
+		}).
+		HandleDefault(func(ctx context.Context, q *gofalcon.StreamQueue) error {
+			assert.Equal(t, gofalcon.EventTypeAuthActivityAudit, q.Meta.EventType)
+			others = append(others, q.Meta.Offset)
+			return nil
+		})
+
+	// Run returns when the stream is closed by server
+	require.NoError(t, consumer.Run(context.Background(), &gofalcon.EventStreamInput{}))
+	assert.Equal(t, []int{0, 2}, detections)
+	assert.Equal(t, []int{1, 3}, others)
+}
+
+func TestStreamConsumerReconnect(t *testing.T) {
+	server := newEventStreamServer(t, 0, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	var attempts []int
+	var errs []error
+	consumer := gofalcon.NewStreamConsumer(client)
+	consumer.OnReconnect = func(partition int, reconnect *gofalcon.StreamReconnect) {
+		assert.Equal(t, 0, partition)
+		attempts = append(attempts, reconnect.Attempt)
+	}
+	consumer.OnError = func(err error) error {
+		errs = append(errs, err)
+		return nil
+	}
+
+	require.NoError(t, consumer.Run(context.Background(), &gofalcon.EventStreamInput{
+		Reconnect: &gofalcon.ReconnectPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond},
+	}))
+	assert.Equal(t, []int{1, 2}, attempts)
+	assert.Equal(t, 1, len(errs))
+}
+
+func TestStreamConsumerConcurrency(t *testing.T) {
+	server := newEventStreamServer(t, 10, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+	store := gofalcon.NewMemoryCheckpointStore()
+
+	var running, maxRunning int32
+	var mutex sync.Mutex
+	var started []int
+
+	consumer := gofalcon.NewStreamConsumer(client)
+	consumer.Concurrency = 3
+	consumer.HandleDefault(func(ctx context.Context, q *gofalcon.StreamQueue) error {
+		mutex.Lock()
+		started = append(started, q.Meta.Offset)
+		mutex.Unlock()
+
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+
+		// Older events take longer
+		time.Sleep(time.Millisecond * time.Duration(30-q.Meta.Offset*3))
+		return nil
+	})
+
+	require.NoError(t, consumer.Run(context.Background(), &gofalcon.EventStreamInput{
+		AppID:      gofalcon.String("myapp"),
+		Checkpoint: store,
+	}))
+
+	sort.Ints(started)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, started)
+	assert.True(t, maxRunning > 1)
+	assert.True(t, maxRunning <= 3)
+
+	offset, found, err := store.Load(context.Background(), "myapp", 0)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 9, offset)
+}
+
+func TestStreamConsumerError(t *testing.T) {
+	server := newEventStreamServer(t, 5, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+	store := gofalcon.NewMemoryCheckpointStore()
+
+	errHandler := errors.New("handler failed")
+	consumer := gofalcon.NewStreamConsumer(client).
+		HandleDefault(func(ctx context.Context, q *gofalcon.StreamQueue) error {
+			if q.Meta.Offset == 2 {
+				return errHandler
+			}
+			return nil
+		})
+
+	err := consumer.Run(context.Background(), &gofalcon.EventStreamInput{
+		AppID:      gofalcon.String("myapp"),
+		Checkpoint: store,
+	})
+	require.Error(t, err)
+	var hErr *gofalcon.HandlerError
+	require.True(t, errors.As(err, &hErr))
+	assert.Equal(t, 2, hErr.Offset)
+	assert.Equal(t, 0, hErr.Partition)
+	assert.Equal(t, gofalcon.EventTypeDetectionSummary, hErr.EventType)
+	assert.True(t, errors.Is(err, errHandler))
+
+	// Checkpoint stays before the failed event
+	offset, found, err := store.Load(context.Background(), "myapp", 0)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, offset)
+}
+
+func TestStreamConsumerPanic(t *testing.T) {
+	server := newEventStreamServer(t, 3, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+	store := gofalcon.NewMemoryCheckpointStore()
+
+	var handled []int
+	var failures []error
+	consumer := gofalcon.NewStreamConsumer(client).
+		HandleDefault(func(ctx context.Context, q *gofalcon.StreamQueue) error {
+			if q.Meta.Offset == 1 {
+				panic("boom")
+			}
+			handled = append(handled, q.Meta.Offset)
+			return nil
+		})
+	consumer.OnError = func(err error) error {
+		failures = append(failures, err)
+		return nil // Skip the event
+	}
+
+	require.NoError(t, consumer.Run(context.Background(), &gofalcon.EventStreamInput{
+		AppID:      gofalcon.String("myapp"),
+		Checkpoint: store,
+	}))
+	assert.Equal(t, []int{0, 2}, handled)
+	require.Equal(t, 1, len(failures))
+	assert.Contains(t, failures[0].Error(), "panic: boom")
+
+	offset, _, err := store.Load(context.Background(), "myapp", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, offset)
+}
+
+func TestStreamConsumerBlockedPartition(t *testing.T) {
+	server := falcontest.NewServer(falcontest.WithPartitions(2))
+	defer server.Close()
+	client, err := server.NewClient()
+	require.NoError(t, err)
+
+	server.SeedEvents(0, 10)
+
+	blocked := make(chan struct{}, 10)
+	release := make(chan struct{})
+	live := make(chan int, 5)
+	consumer := gofalcon.NewStreamConsumer(client).
+		HandleDefault(func(ctx context.Context, q *gofalcon.StreamQueue) error {
+			if q.Partition == 0 {
+				blocked <- struct{}{}
+				select {
+				case <-release:
+				case <-ctx.Done():
+				}
+				return nil
+			}
+			live <- q.Meta.Offset
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx, &gofalcon.EventStreamInput{}) }()
+
+	// Events of partition 1 arrive after handler of partition 0 has been blocked
+	<-blocked
+	server.SeedEvents(1, 5)
+
+	// Partition 1 is consumed while handler of partition 0 is blocked
+	for i := 0; i < 5; i++ {
+		select {
+		case offset := <-live:
+			assert.Equal(t, i, offset)
+		case <-time.After(time.Second * 5):
+			require.Fail(t, "partition 1 is blocked by partition 0")
+		}
+	}
+
+	close(release)
+	cancel()
+	assert.NoError(t, <-done)
+}
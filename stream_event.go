@@ -0,0 +1,370 @@
+package gofalcon
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Event types of Event Stream API. They are set to StreamEventMetaData.EventType.
+const (
+	EventTypeDetectionSummary            = "DetectionSummaryEvent"
+	EventTypeIncidentSummary             = "IncidentSummaryEvent"
+	EventTypeAuthActivityAudit           = "AuthActivityAuditEvent"
+	EventTypeUserActivityAudit           = "UserActivityAuditEvent"
+	EventTypeRemoteResponseSessionStart  = "RemoteResponseSessionStartEvent"
+	EventTypeRemoteResponseSessionEnd    = "RemoteResponseSessionEndEvent"
+	EventTypeCustomerIOC                 = "CustomerIOCEvent"
+	EventTypeFirewallMatch               = "FirewallMatchEvent"
+	EventTypeIdpDetectionSummary         = "IdpDetectionSummaryEvent"
+	EventTypeXdrDetectionSummary         = "XdrDetectionSummaryEvent"
+	EventTypeMobileDetectionSummary      = "MobileDetectionSummaryEvent"
+	EventTypeScheduledReportNotification = "ScheduledReportNotificationEvent"
+)
+
+// StreamEvent is typed payload of event stream. Type assertion to pointer of the struct (e.g. *DetectionSummaryEvent) is available in StreamQueue.Payload.
+type StreamEvent interface {
+	StreamEventType() string
+}
+
+// StreamEventFactory returns pointer of new empty StreamEvent to be decoded.
+type StreamEventFactory func() StreamEvent
+
+var (
+	streamEventRegistry = map[string]StreamEventFactory{
+		EventTypeDetectionSummary:            func() StreamEvent { return &DetectionSummaryEvent{} },
+		EventTypeIncidentSummary:             func() StreamEvent { return &IncidentSummaryEvent{} },
+		EventTypeAuthActivityAudit:           func() StreamEvent { return &AuthActivityAuditEvent{} },
+		EventTypeUserActivityAudit:           func() StreamEvent { return &UserActivityAuditEvent{} },
+		EventTypeRemoteResponseSessionStart:  func() StreamEvent { return &RemoteResponseSessionStartEvent{} },
+		EventTypeRemoteResponseSessionEnd:    func() StreamEvent { return &RemoteResponseSessionEndEvent{} },
+		EventTypeCustomerIOC:                 func() StreamEvent { return &CustomerIOCEvent{} },
+		EventTypeFirewallMatch:               func() StreamEvent { return &FirewallMatchEvent{} },
+		EventTypeIdpDetectionSummary:         func() StreamEvent { return &IdpDetectionSummaryEvent{} },
+		EventTypeXdrDetectionSummary:         func() StreamEvent { return &XdrDetectionSummaryEvent{} },
+		EventTypeMobileDetectionSummary:      func() StreamEvent { return &MobileDetectionSummaryEvent{} },
+		EventTypeScheduledReportNotification: func() StreamEvent { return &ScheduledReportNotificationEvent{} },
+	}
+	streamEventRegistryMutex sync.RWMutex
+)
+
+// RegisterStreamEvent adds or replaces decoder of eventType. It can be used for event types that are not supported by gofalcon yet.
+func RegisterStreamEvent(eventType string, factory StreamEventFactory) {
+	streamEventRegistryMutex.Lock()
+	defer streamEventRegistryMutex.Unlock()
+	streamEventRegistry[eventType] = factory
+}
+
+// DecodeStreamEvent decodes raw event of eventType into registered StreamEvent. It returns *UnknownEvent if eventType is not registered, or the raw event does not fit to the registered struct. Error of the latter case is set to UnknownEvent.Error.
+func DecodeStreamEvent(eventType string, raw json.RawMessage) StreamEvent {
+	streamEventRegistryMutex.RLock()
+	factory, ok := streamEventRegistry[eventType]
+	streamEventRegistryMutex.RUnlock()
+
+	if !ok {
+		return &UnknownEvent{Type: eventType, Raw: raw}
+	}
+
+	ev := factory()
+	if err := json.Unmarshal(raw, ev); err != nil {
+		return &UnknownEvent{Type: eventType, Raw: raw, Error: err}
+	}
+	return ev
+}
+
+// UnknownEvent is fallback of StreamEvent. Raw is original JSON of "event" field.
+type UnknownEvent struct {
+	Type  string
+	Raw   json.RawMessage
+	Error error
+}
+
+// StreamEventType returns original event type.
+func (x UnknownEvent) StreamEventType() string { return x.Type }
+
+// DetectionSummaryEvent is issued when a detection is created on a host.
+type DetectionSummaryEvent struct {
+	ProcessStartTime              int64  `json:"ProcessStartTime"`
+	ProcessEndTime                int64  `json:"ProcessEndTime"`
+	ProcessID                     int64  `json:"ProcessId"`
+	ParentProcessID               int64  `json:"ParentProcessId"`
+	ComputerName                  string `json:"ComputerName"`
+	UserName                      string `json:"UserName"`
+	DetectName                    string `json:"DetectName"`
+	DetectDescription             string `json:"DetectDescription"`
+	Severity                      int    `json:"Severity"`
+	SeverityName                  string `json:"SeverityName"`
+	FileName                      string `json:"FileName"`
+	FilePath                      string `json:"FilePath"`
+	CommandLine                   string `json:"CommandLine"`
+	SHA256String                  string `json:"SHA256String"`
+	MD5String                     string `json:"MD5String"`
+	SHA1String                    string `json:"SHA1String"`
+	MachineDomain                 string `json:"MachineDomain"`
+	DetectID                      string `json:"DetectId"`
+	SensorID                      string `json:"SensorId"`
+	LocalIP                       string `json:"LocalIP"`
+	MACAddress                    string `json:"MACAddress"`
+	Tactic                        string `json:"Tactic"`
+	Technique                     string `json:"Technique"`
+	Objective                     string `json:"Objective"`
+	PatternDispositionDescription string `json:"PatternDispositionDescription"`
+	PatternDispositionValue       int    `json:"PatternDispositionValue"`
+	PatternDispositionFlags       struct {
+		Indicator                bool `json:"Indicator"`
+		Detect                   bool `json:"Detect"`
+		InddetMask               bool `json:"InddetMask"`
+		SensorOnly               bool `json:"SensorOnly"`
+		Rooting                  bool `json:"Rooting"`
+		KillProcess              bool `json:"KillProcess"`
+		KillSubProcess           bool `json:"KillSubProcess"`
+		QuarantineMachine        bool `json:"QuarantineMachine"`
+		QuarantineFile           bool `json:"QuarantineFile"`
+		PolicyDisabled           bool `json:"PolicyDisabled"`
+		KillParent               bool `json:"KillParent"`
+		OperationBlocked         bool `json:"OperationBlocked"`
+		ProcessBlocked           bool `json:"ProcessBlocked"`
+		RegistryOperationBlocked bool `json:"RegistryOperationBlocked"`
+	} `json:"PatternDispositionFlags"`
+	ParentImageFileName      string `json:"ParentImageFileName"`
+	ParentCommandLine        string `json:"ParentCommandLine"`
+	GrandparentImageFileName string `json:"GrandparentImageFileName"`
+	GrandparentCommandLine   string `json:"GrandparentCommandLine"`
+	IOCType                  string `json:"IOCType"`
+	IOCValue                 string `json:"IOCValue"`
+	FalconHostLink           string `json:"FalconHostLink"`
+}
+
+// StreamEventType returns EventTypeDetectionSummary
+func (x DetectionSummaryEvent) StreamEventType() string { return EventTypeDetectionSummary }
+
+// IncidentSummaryEvent is issued when an incident is created or updated.
+type IncidentSummaryEvent struct {
+	IncidentStartTime int64    `json:"IncidentStartTime"`
+	IncidentEndTime   int64    `json:"IncidentEndTime"`
+	FalconHostLink    string   `json:"FalconHostLink"`
+	State             string   `json:"State"`
+	FineScore         int      `json:"FineScore"`
+	LateralMovement   int      `json:"LateralMovement"`
+	IncidentType      int      `json:"IncidentType"`
+	IncidentID        string   `json:"IncidentID"`
+	HostID            string   `json:"HostID"`
+	LMHostIDs         []string `json:"LMHostIDs"`
+	UserID            string   `json:"UserId"`
+}
+
+// StreamEventType returns EventTypeIncidentSummary
+func (x IncidentSummaryEvent) StreamEventType() string { return EventTypeIncidentSummary }
+
+// AuditKeyValue is additional attribute of audit events.
+type AuditKeyValue struct {
+	Key         string `json:"Key"`
+	ValueString string `json:"ValueString"`
+}
+
+// AuthActivityAuditEvent is issued for authentication activity of Falcon console and API.
+type AuthActivityAuditEvent struct {
+	UserID         string          `json:"UserId"`
+	UserIP         string          `json:"UserIp"`
+	OperationName  string          `json:"OperationName"`
+	ServiceName    string          `json:"ServiceName"`
+	Success        bool            `json:"Success"`
+	UTCTimestamp   int64           `json:"UTCTimestamp"`
+	AuditKeyValues []AuditKeyValue `json:"AuditKeyValues"`
+}
+
+// StreamEventType returns EventTypeAuthActivityAudit
+func (x AuthActivityAuditEvent) StreamEventType() string { return EventTypeAuthActivityAudit }
+
+// UserActivityAuditEvent is issued for user activity of Falcon console and API, e.g. update of detection status.
+type UserActivityAuditEvent struct {
+	UserID         string          `json:"UserId"`
+	UserIP         string          `json:"UserIp"`
+	OperationName  string          `json:"OperationName"`
+	ServiceName    string          `json:"ServiceName"`
+	Success        bool            `json:"Success"`
+	UTCTimestamp   int64           `json:"UTCTimestamp"`
+	AuditKeyValues []AuditKeyValue `json:"AuditKeyValues"`
+}
+
+// StreamEventType returns EventTypeUserActivityAudit
+func (x UserActivityAuditEvent) StreamEventType() string { return EventTypeUserActivityAudit }
+
+// RemoteResponseSessionStartEvent is issued when a Real Time Response session is started.
+type RemoteResponseSessionStartEvent struct {
+	SessionID      string `json:"SessionId"`
+	HostnameField  string `json:"HostnameField"`
+	UserName       string `json:"UserName"`
+	StartTimestamp int64  `json:"StartTimestamp"`
+}
+
+// StreamEventType returns EventTypeRemoteResponseSessionStart
+func (x RemoteResponseSessionStartEvent) StreamEventType() string {
+	return EventTypeRemoteResponseSessionStart
+}
+
+// RemoteResponseSessionEndEvent is issued when a Real Time Response session is ended.
+type RemoteResponseSessionEndEvent struct {
+	SessionID     string   `json:"SessionId"`
+	HostnameField string   `json:"HostnameField"`
+	UserName      string   `json:"UserName"`
+	EndTimestamp  int64    `json:"EndTimestamp"`
+	Commands      []string `json:"Commands"`
+}
+
+// StreamEventType returns EventTypeRemoteResponseSessionEnd
+func (x RemoteResponseSessionEndEvent) StreamEventType() string {
+	return EventTypeRemoteResponseSessionEnd
+}
+
+// CustomerIOCEvent is issued when a custom IOC is matched on a host.
+type CustomerIOCEvent struct {
+	DetectID        string `json:"DetectId"`
+	DetectName      string `json:"DetectName"`
+	ComputerName    string `json:"ComputerName"`
+	UserName        string `json:"UserName"`
+	SensorID        string `json:"SensorId"`
+	MachineDomain   string `json:"MachineDomain"`
+	ProcessID       int64  `json:"ProcessId"`
+	ParentProcessID int64  `json:"ParentProcessId"`
+	FileName        string `json:"FileName"`
+	FilePath        string `json:"FilePath"`
+	CommandLine     string `json:"CommandLine"`
+	SHA256String    string `json:"SHA256String"`
+	MD5String       string `json:"MD5String"`
+	IOCType         string `json:"IOCType"`
+	IOCValue        string `json:"IOCValue"`
+	Severity        int    `json:"Severity"`
+	SeverityName    string `json:"SeverityName"`
+	FalconHostLink  string `json:"FalconHostLink"`
+}
+
+// StreamEventType returns EventTypeCustomerIOC
+func (x CustomerIOCEvent) StreamEventType() string { return EventTypeCustomerIOC }
+
+// FirewallMatchEvent is issued when network traffic matches a rule of Falcon Firewall Management.
+type FirewallMatchEvent struct {
+	DeviceID                  string `json:"DeviceId"`
+	HostName                  string `json:"HostName"`
+	UserName                  string `json:"UserName"`
+	PID                       string `json:"PID"`
+	ImageFileName             string `json:"ImageFileName"`
+	CommandLine               string `json:"CommandLine"`
+	Ipv                       string `json:"Ipv"`
+	Protocol                  string `json:"Protocol"`
+	ConnectionDirection       string `json:"ConnectionDirection"`
+	LocalAddress              string `json:"LocalAddress"`
+	LocalPort                 string `json:"LocalPort"`
+	RemoteAddress             string `json:"RemoteAddress"`
+	RemotePort                string `json:"RemotePort"`
+	ICMPCode                  string `json:"ICMPCode"`
+	ICMPType                  string `json:"ICMPType"`
+	NetworkProfile            string `json:"NetworkProfile"`
+	PolicyName                string `json:"PolicyName"`
+	PolicyID                  string `json:"PolicyID"`
+	RuleGroupName             string `json:"RuleGroupName"`
+	RuleFamilyID              string `json:"RuleFamilyID"`
+	RuleName                  string `json:"RuleName"`
+	RuleDescription           string `json:"RuleDescription"`
+	RuleID                    string `json:"RuleId"`
+	RuleAction                string `json:"RuleAction"`
+	Status                    string `json:"Status"`
+	MatchCount                int    `json:"MatchCount"`
+	MatchCountSinceLastReport int    `json:"MatchCountSinceLastReport"`
+	Timestamp                 string `json:"Timestamp"`
+	EventType                 string `json:"EventType"`
+	TreeID                    string `json:"TreeID"`
+}
+
+// StreamEventType returns EventTypeFirewallMatch
+func (x FirewallMatchEvent) StreamEventType() string { return EventTypeFirewallMatch }
+
+// IdpDetectionSummaryEvent is issued when a detection of Falcon Identity Protection is created.
+type IdpDetectionSummaryEvent struct {
+	DetectID                   string `json:"DetectId"`
+	DetectName                 string `json:"DetectName"`
+	DetectDescription          string `json:"DetectDescription"`
+	Severity                   int    `json:"Severity"`
+	SeverityName               string `json:"SeverityName"`
+	Tactic                     string `json:"Tactic"`
+	Technique                  string `json:"Technique"`
+	Objective                  string `json:"Objective"`
+	FalconHostLink             string `json:"FalconHostLink"`
+	ContextTimeStamp           int64  `json:"ContextTimeStamp"`
+	StartTime                  int64  `json:"StartTime"`
+	EndTime                    int64  `json:"EndTime"`
+	PrecedingActivityTimeStamp int64  `json:"PrecedingActivityTimeStamp"`
+	SourceAccountName          string `json:"SourceAccountName"`
+	SourceAccountDomain        string `json:"SourceAccountDomain"`
+	SourceEndpointHostName     string `json:"SourceEndpointHostName"`
+	SourceEndpointIPAddress    string `json:"SourceEndpointIpAddress"`
+	TargetAccountName          string `json:"TargetAccountName"`
+	TargetEndpointHostName     string `json:"TargetEndpointHostName"`
+}
+
+// StreamEventType returns EventTypeIdpDetectionSummary
+func (x IdpDetectionSummaryEvent) StreamEventType() string { return EventTypeIdpDetectionSummary }
+
+// XdrDetectionSummaryEvent is issued when a detection of Falcon XDR is created.
+type XdrDetectionSummaryEvent struct {
+	DetectID       string   `json:"DetectId"`
+	Name           string   `json:"Name"`
+	Description    string   `json:"Description"`
+	Severity       int      `json:"Severity"`
+	Tactic         string   `json:"Tactic"`
+	Technique      string   `json:"Technique"`
+	Author         string   `json:"Author"`
+	DataDomains    []string `json:"DataDomains"`
+	SourceVendors  []string `json:"SourceVendors"`
+	SourceProducts []string `json:"SourceProducts"`
+	FalconHostLink string   `json:"FalconHostLink"`
+	StartTimestamp int64    `json:"StartTimestamp"`
+	EndTimestamp   int64    `json:"EndTimestamp"`
+}
+
+// StreamEventType returns EventTypeXdrDetectionSummary
+func (x XdrDetectionSummaryEvent) StreamEventType() string { return EventTypeXdrDetectionSummary }
+
+// MobileDetectionSummaryEvent is issued when a detection of Falcon for Mobile is created.
+type MobileDetectionSummaryEvent struct {
+	DetectID          string `json:"DetectId"`
+	DetectName        string `json:"DetectName"`
+	DetectDescription string `json:"DetectDescription"`
+	ComputerName      string `json:"ComputerName"`
+	UserName          string `json:"UserName"`
+	SensorID          string `json:"SensorId"`
+	MobileDetectionID int64  `json:"MobileDetectionId"`
+	Severity          int    `json:"Severity"`
+	SeverityName      string `json:"SeverityName"`
+	Tactic            string `json:"Tactic"`
+	Technique         string `json:"Technique"`
+	Objective         string `json:"Objective"`
+	ContextTimeStamp  int64  `json:"ContextTimeStamp"`
+	FalconHostLink    string `json:"FalconHostLink"`
+}
+
+// StreamEventType returns EventTypeMobileDetectionSummary
+func (x MobileDetectionSummaryEvent) StreamEventType() string {
+	return EventTypeMobileDetectionSummary
+}
+
+// ScheduledReportNotificationEvent is issued when a scheduled report has been executed.
+type ScheduledReportNotificationEvent struct {
+	UserUUID               string `json:"UserUUID"`
+	UserID                 string `json:"UserID"`
+	ExecutionID            string `json:"ExecutionID"`
+	ReportID               string `json:"ReportID"`
+	ReportName             string `json:"ReportName"`
+	ReportType             string `json:"ReportType"`
+	ReportCreatedTimestamp int64  `json:"ReportCreatedTimestamp"`
+	ExecutionMetadata      struct {
+		ExecutionStart  int64  `json:"ExecutionStart"`
+		ExecutionFinish int64  `json:"ExecutionFinish"`
+		ReportFileName  string `json:"ReportFileName"`
+	} `json:"ExecutionMetadata"`
+	Status string `json:"Status"`
+}
+
+// StreamEventType returns EventTypeScheduledReportNotification
+func (x ScheduledReportNotificationEvent) StreamEventType() string {
+	return EventTypeScheduledReportNotification
+}
@@ -0,0 +1,91 @@
+package gofalcon_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeStreamEvent(t *testing.T) {
+	t.Run("DetectionSummaryEvent", func(t *testing.T) {
+		raw := json.RawMessage(`{"DetectId":"ldt:xxx:1","ComputerName":"host1","Severity":4,"ProcessId":1234,"PatternDispositionFlags":{"KillProcess":true}}`)
+		ev := gofalcon.DecodeStreamEvent(gofalcon.EventTypeDetectionSummary, raw)
+		detection, ok := ev.(*gofalcon.DetectionSummaryEvent)
+		require.True(t, ok)
+		assert.Equal(t, "ldt:xxx:1", detection.DetectID)
+		assert.Equal(t, "host1", detection.ComputerName)
+		assert.Equal(t, 4, detection.Severity)
+		assert.Equal(t, int64(1234), detection.ProcessID)
+		assert.True(t, detection.PatternDispositionFlags.KillProcess)
+		assert.Equal(t, gofalcon.EventTypeDetectionSummary, ev.StreamEventType())
+	})
+
+	t.Run("AuthActivityAuditEvent", func(t *testing.T) {
+		raw := json.RawMessage(`{"UserId":"blue@example.com","OperationName":"twoFactorAuthenticate","Success":true,"AuditKeyValues":[{"Key":"target_name","ValueString":"blue"}]}`)
+		ev := gofalcon.DecodeStreamEvent(gofalcon.EventTypeAuthActivityAudit, raw)
+		audit, ok := ev.(*gofalcon.AuthActivityAuditEvent)
+		require.True(t, ok)
+		assert.Equal(t, "blue@example.com", audit.UserID)
+		assert.True(t, audit.Success)
+		assert.Equal(t, []gofalcon.AuditKeyValue{{Key: "target_name", ValueString: "blue"}}, audit.AuditKeyValues)
+	})
+
+	t.Run("unknown event type", func(t *testing.T) {
+		raw := json.RawMessage(`{"Foo":"bar"}`)
+		ev := gofalcon.DecodeStreamEvent("NewFancyEvent", raw)
+		unknown, ok := ev.(*gofalcon.UnknownEvent)
+		require.True(t, ok)
+		assert.Equal(t, "NewFancyEvent", unknown.StreamEventType())
+		assert.Equal(t, raw, unknown.Raw)
+		assert.NoError(t, unknown.Error)
+	})
+
+	t.Run("mismatched event falls back to unknown", func(t *testing.T) {
+		raw := json.RawMessage(`{"DetectId":1}`)
+		ev := gofalcon.DecodeStreamEvent(gofalcon.EventTypeDetectionSummary, raw)
+		unknown, ok := ev.(*gofalcon.UnknownEvent)
+		require.True(t, ok)
+		assert.Equal(t, gofalcon.EventTypeDetectionSummary, unknown.Type)
+		assert.Equal(t, raw, unknown.Raw)
+		assert.Error(t, unknown.Error)
+	})
+}
+
+type customStreamEvent struct {
+	Name string `json:"Name"`
+}
+
+func (x customStreamEvent) StreamEventType() string { return "CustomStreamEvent" }
+
+func TestRegisterStreamEvent(t *testing.T) {
+	gofalcon.RegisterStreamEvent("CustomStreamEvent", func() gofalcon.StreamEvent { return &customStreamEvent{} })
+
+	ev := gofalcon.DecodeStreamEvent("CustomStreamEvent", json.RawMessage(`{"Name":"blue"}`))
+	custom, ok := ev.(*customStreamEvent)
+	require.True(t, ok)
+	assert.Equal(t, "blue", custom.Name)
+}
+
+func TestEventStreamPayload(t *testing.T) {
+	server := newEventStreamServer(t, 1, 0)
+	defer server.Close()
+
+	client := gofalcon.NewClient()
+	client.Endpoint = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := <-client.Sensor.EventStreamWithContext(ctx, &gofalcon.EventStreamInput{})
+	require.NoError(t, q.Error)
+
+	// Raw map is still available
+	assert.Equal(t, "ldt:0", q.Event["DetectId"])
+
+	detection, ok := q.Payload.(*gofalcon.DetectionSummaryEvent)
+	require.True(t, ok)
+	assert.Equal(t, "ldt:0", detection.DetectID)
+}
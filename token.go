@@ -0,0 +1,177 @@
+package gofalcon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AccessToken is a token to access Falcon API.
+type AccessToken struct {
+	Token     string
+	TokenType string
+	// Expiry is expiration time of the token. Zero means the token never expires.
+	Expiry time.Time
+}
+
+// expired returns true if the token will be expired within margin.
+func (x *AccessToken) expired(margin time.Duration) bool {
+	if x.Expiry.IsZero() {
+		return false
+	}
+	return !time.Now().Add(margin).Before(x.Expiry)
+}
+
+// TokenSource provides AccessToken to Client. It must be safe for concurrent use because a Client calls Token() from every API request. Implement it to get a token from your vault or shared cache.
+type TokenSource interface {
+	Token(ctx context.Context) (*AccessToken, error)
+}
+
+// TokenInvalidator can be implemented by TokenSource optionally. Client calls Invalidate with the rejected token when API responds authentication error, and then retries the request with a token from Token().
+type TokenInvalidator interface {
+	Invalidate(token *AccessToken)
+}
+
+// WithTokenSource sets TokenSource to the client.
+func WithTokenSource(ts TokenSource) Option {
+	return func(client *Client) {
+		client.tokenSource = ts
+	}
+}
+
+type staticTokenSource struct {
+	token *AccessToken
+}
+
+// StaticTokenSource returns TokenSource that always returns same token.
+func StaticTokenSource(token, tokenType string) TokenSource {
+	return &staticTokenSource{token: &AccessToken{Token: token, TokenType: tokenType}}
+}
+
+func (x *staticTokenSource) Token(ctx context.Context) (*AccessToken, error) {
+	return x.token, nil
+}
+
+const (
+	// DefaultTokenRefreshMargin is default value of OAuth2TokenSource.RefreshMargin
+	DefaultTokenRefreshMargin = time.Minute * 5
+	// DefaultTokenRefreshTimeout is default value of OAuth2TokenSource.RefreshTimeout
+	DefaultTokenRefreshTimeout = time.Second * 30
+)
+
+// OAuth2TokenSource retrieves OAuth2 access token by OAuth2API.Token and caches it until expiration. The token is refreshed RefreshMargin before expiration. Concurrent refreshes are deduplicated into one Token request, that is not bound to context of any caller and is limited by RefreshTimeout.
+type OAuth2TokenSource struct {
+	ClientID     string
+	ClientSecret string
+	// MemberCID is CID of child tenant for MSSP (Flight Control). Empty means the tenant of the API client itself.
+	MemberCID     string
+	RefreshMargin time.Duration
+	// RefreshTimeout is timeout of a Token request. Zero means DefaultTokenRefreshTimeout.
+	RefreshTimeout time.Duration
+
+	api   *OAuth2API
+	mutex sync.Mutex
+	token *AccessToken
+	call  *tokenCall
+}
+
+// tokenCall is in-flight token request shared by concurrent callers.
+type tokenCall struct {
+	done  chan struct{}
+	token *AccessToken
+	err   error
+}
+
+// NewOAuth2TokenSource is constructor of OAuth2TokenSource.
+func NewOAuth2TokenSource(api *OAuth2API, clientID, secret string) *OAuth2TokenSource {
+	return &OAuth2TokenSource{
+		ClientID:       clientID,
+		ClientSecret:   secret,
+		RefreshMargin:  DefaultTokenRefreshMargin,
+		RefreshTimeout: DefaultTokenRefreshTimeout,
+		api:            api,
+	}
+}
+
+// Token returns cached token or retrieves new one if the cached token is (about to be) expired.
+func (x *OAuth2TokenSource) Token(ctx context.Context) (*AccessToken, error) {
+	x.mutex.Lock()
+	if x.token != nil && !x.token.expired(x.RefreshMargin) {
+		token := x.token
+		x.mutex.Unlock()
+		return token, nil
+	}
+
+	call := x.call
+	if call == nil {
+		call = &tokenCall{done: make(chan struct{})}
+		x.call = call
+		go x.refresh(call)
+	}
+	x.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh retrieves new token and notifies it to callers waiting call. The request is detached from callers, then cancellation of a caller does not fail others.
+func (x *OAuth2TokenSource) refresh(call *tokenCall) {
+	timeout := x.RefreshTimeout
+	if timeout <= 0 {
+		timeout = DefaultTokenRefreshTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	call.token, call.err = x.retrieve(ctx)
+
+	x.mutex.Lock()
+	if call.err == nil {
+		x.token = call.token
+	}
+	x.call = nil
+	x.mutex.Unlock()
+	close(call.done)
+}
+
+// Invalidate discards cached token if it's same with token.
+func (x *OAuth2TokenSource) Invalidate(token *AccessToken) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.token != nil && token != nil && x.token.Token == token.Token {
+		x.token = nil
+	}
+}
+
+func (x *OAuth2TokenSource) retrieve(ctx context.Context) (*AccessToken, error) {
+	issuedAt := time.Now()
+	input := &TokenInput{
+		ClientID:     &x.ClientID,
+		ClientSecret: &x.ClientSecret,
+	}
+	if x.MemberCID != "" {
+		input.MemberCID = &x.MemberCID
+	}
+
+	resp, err := x.api.TokenWithContext(ctx, input)
+	x.api.client.metrics.ObserveTokenRefresh(err)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to OAuth2 authentication")
+	}
+
+	token := &AccessToken{
+		Token:     resp.AccessToken,
+		TokenType: resp.TokenType,
+	}
+	if resp.ExpiresIn > 0 {
+		token.Expiry = issuedAt.Add(time.Second * time.Duration(resp.ExpiresIn))
+	}
+
+	return token, nil
+}
@@ -0,0 +1,198 @@
+package gofalcon_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTokenTestServer(tokenCount *int32, rejected string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2/token":
+			n := atomic.AddInt32(tokenCount, 1)
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":1799}`, n)
+
+		default:
+			if r.Header.Get("Authorization") == "bearer "+rejected {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"errors":[{"code":401,"message":"access denied, invalid bearer token"}]}`))
+				return
+			}
+			w.Write([]byte(`{"resources":["xxx"]}`))
+		}
+	}))
+}
+
+func TestOAuth2TokenSource(t *testing.T) {
+	t.Run("Concurrent requests share one token", func(t *testing.T) {
+		var tokenCount int32
+		server := newTokenTestServer(&tokenCount, "")
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		client.SetTokenSource(gofalcon.NewOAuth2TokenSource(client.OAuth2, "id", "secret"))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var resp gofalcon.Response
+				assert.NoError(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCount))
+	})
+
+	t.Run("Refresh token ahead of expiration", func(t *testing.T) {
+		var tokenCount int32
+		server := newTokenTestServer(&tokenCount, "")
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		ts := gofalcon.NewOAuth2TokenSource(client.OAuth2, "id", "secret")
+		ts.RefreshMargin = time.Hour // longer than expires_in
+		client.SetTokenSource(ts)
+
+		for i := 0; i < 3; i++ {
+			var resp gofalcon.Response
+			require.NoError(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+		}
+		assert.Equal(t, int32(3), atomic.LoadInt32(&tokenCount))
+	})
+
+	t.Run("Rejected token is refreshed", func(t *testing.T) {
+		var tokenCount int32
+		server := newTokenTestServer(&tokenCount, "token-1")
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		require.NoError(t, client.EnableOAuth2("id", "secret"))
+
+		var resp gofalcon.Response
+		require.NoError(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&tokenCount))
+	})
+
+	t.Run("Static token is not refreshed", func(t *testing.T) {
+		var tokenCount int32
+		server := newTokenTestServer(&tokenCount, "token-x")
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		client.SetOAuth2Token("token-x", "bearer")
+
+		var resp gofalcon.Response
+		err := client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp)
+		assert.True(t, gofalcon.IsAuthError(err))
+		assert.Equal(t, int32(0), atomic.LoadInt32(&tokenCount))
+	})
+
+	t.Run("Cancelled caller does not fail other callers", func(t *testing.T) {
+		var tokenCount int32
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			n := atomic.AddInt32(&tokenCount, 1)
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":1799}`, n)
+		}))
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		ts := gofalcon.NewOAuth2TokenSource(client.OAuth2, "id", "secret")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		firstErr := make(chan error, 1)
+		go func() {
+			_, err := ts.Token(ctx)
+			firstErr <- err
+		}()
+
+		second := make(chan *gofalcon.AccessToken, 1)
+		go func() {
+			token, err := ts.Token(context.Background())
+			assert.NoError(t, err)
+			second <- token
+		}()
+
+		cancel()
+		assert.Equal(t, context.Canceled, <-firstErr)
+		close(release)
+
+		token := <-second
+		require.NotNil(t, token)
+		assert.Equal(t, "token-1", token.Token)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCount))
+	})
+
+	t.Run("Deprecated OAuth2Token field is used", func(t *testing.T) {
+		var tokenCount int32
+		server := newTokenTestServer(&tokenCount, "")
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		client.SetOAuth2Token("token-x", "bearer")
+		assert.Equal(t, "token-x", client.OAuth2Token)
+		assert.Equal(t, "bearer", client.OAuth2Type)
+
+		var auth string
+		legacyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			w.Write([]byte(`{"resources":["xxx"]}`))
+		}))
+		defer legacyServer.Close()
+
+		legacy := gofalcon.NewClient()
+		legacy.Endpoint = legacyServer.URL
+		legacy.OAuth2Token = "token-y"
+		legacy.OAuth2Type = "bearer"
+
+		var resp gofalcon.Response
+		require.NoError(t, legacy.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+		assert.Equal(t, "bearer token-y", auth)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&tokenCount))
+	})
+
+	t.Run("SetOAuth2Token while sending requests", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"resources":["xxx"]}`))
+		}))
+		defer server.Close()
+
+		client := gofalcon.NewClient()
+		client.Endpoint = server.URL
+		client.SetOAuth2Token("token-0", "bearer")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var resp gofalcon.Response
+				assert.NoError(t, client.SendRequest(gofalcon.Request{Path: "devices/queries/devices/v1"}, &resp))
+			}()
+		}
+		for i := 1; i <= 4; i++ {
+			client.SetOAuth2Token(fmt.Sprintf("token-%d", i), "bearer")
+		}
+		wg.Wait()
+	})
+}
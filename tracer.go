@@ -0,0 +1,102 @@
+package gofalcon
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer starts spans of API requests and event stream operations. oteltrace package provides implementation for OpenTelemetry.
+type Tracer interface {
+	// Start starts a span as a child of span in ctx, and returns ctx with the new span. The returned ctx is used for the traced operation and bound to HTTP request in process. Trace context is sent to the server only if Tracer implements TracePropagator.
+	Start(ctx context.Context, name string, attrs SpanAttributes) (context.Context, Span)
+}
+
+// TracePropagator can be implemented by Tracer optionally. Inject is called with ctx returned by Start and header of HTTP request before BeforeRequest of Middleware, to propagate trace context (e.g. traceparent header) to Falcon API.
+type TracePropagator interface {
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a traced operation started by Tracer.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs SpanAttributes)
+	// End finishes the span. err is recorded if not nil.
+	End(err error)
+}
+
+// SpanAttributes is attributes of span. Values are string, int, int64, float64 or bool.
+type SpanAttributes map[string]interface{}
+
+// Span names
+const (
+	SpanNameRequest         = "falcon.request"
+	SpanNameDatafeedConnect = "falcon.datafeed.connect"
+	SpanNameDatafeedRefresh = "falcon.datafeed.refresh"
+)
+
+// Keys of SpanAttributes
+const (
+	SpanKeyMethod           = "http.method"
+	SpanKeyStatusCode       = "http.status_code"
+	SpanKeyPath             = "falcon.path"
+	SpanKeyTraceID          = "falcon.trace_id"
+	SpanKeyQueryTime        = "falcon.query_time"
+	SpanKeyAttempts         = "falcon.attempts"
+	SpanKeyPaginationOffset = "falcon.pagination.offset"
+	SpanKeyPaginationLimit  = "falcon.pagination.limit"
+	SpanKeyPaginationTotal  = "falcon.pagination.total"
+	SpanKeyAppID            = "falcon.app_id"
+	SpanKeyPartition        = "falcon.partition"
+)
+
+// WithTracer sets Tracer to the client. nil disables tracing.
+func WithTracer(tracer Tracer) Option {
+	return func(client *Client) {
+		if tracer == nil {
+			tracer = nopTracer{}
+		}
+		client.tracer = tracer
+	}
+}
+
+type nopTracer struct{}
+
+func (x nopTracer) Start(ctx context.Context, name string, attrs SpanAttributes) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (x nopSpan) SetAttributes(attrs SpanAttributes) {}
+func (x nopSpan) End(err error)                      {}
+
+// injectTraceContext sets trace context of ctx to header of req if tracer implements TracePropagator.
+func injectTraceContext(tracer Tracer, ctx context.Context, req *http.Request) {
+	if propagator, ok := tracer.(TracePropagator); ok {
+		propagator.Inject(ctx, req.Header)
+	}
+}
+
+// responseAttributes converts status code and meta data of API response to span attributes.
+func responseAttributes(statusCode int, meta *MetaData) SpanAttributes {
+	attrs := SpanAttributes{}
+	if statusCode > 0 {
+		attrs[SpanKeyStatusCode] = statusCode
+	}
+	if meta == nil {
+		return attrs
+	}
+
+	if meta.TraceID != "" {
+		attrs[SpanKeyTraceID] = meta.TraceID
+	}
+	if meta.QueryTime > 0 {
+		attrs[SpanKeyQueryTime] = meta.QueryTime
+	}
+	if p := meta.Pagenation; p != nil {
+		attrs[SpanKeyPaginationOffset] = p.Offset
+		attrs[SpanKeyPaginationLimit] = p.Limit
+		attrs[SpanKeyPaginationTotal] = p.Total
+	}
+	return attrs
+}
@@ -0,0 +1,12 @@
+module github.com/m-mizutani/gofalcon/zaplog
+
+go 1.13
+
+require (
+	github.com/m-mizutani/gofalcon v0.0.0-20261017174609-02bfd1c7a67a
+	github.com/stretchr/testify v1.8.2
+	go.uber.org/zap v1.16.0
+)
+
+// Development in this repository builds with the working tree. Modules depending on this module ignore it and use required version above.
+replace github.com/m-mizutani/gofalcon => ../
@@ -0,0 +1,52 @@
+// Package zaplog provides adapter of go.uber.org/zap for gofalcon.WithLogger. It's separated from gofalcon package not to import zap by default.
+//
+//	client := gofalcon.NewClient(gofalcon.WithLogger(zaplog.New(zapLogger)))
+package zaplog
+
+import (
+	"sort"
+
+	"github.com/m-mizutani/gofalcon"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New creates gofalcon.StructuredLogger writing logs to zap.
+func New(logger *zap.Logger) gofalcon.StructuredLogger {
+	return &zapLogger{logger: logger}
+}
+
+type zapLogger struct {
+	logger *zap.Logger
+}
+
+func (x *zapLogger) Log(level gofalcon.LogLevel, msg string, fields gofalcon.LogFields) {
+	var zapLevel zapcore.Level
+	switch level {
+	case gofalcon.LogLevelDebug:
+		zapLevel = zapcore.DebugLevel
+	case gofalcon.LogLevelInfo:
+		zapLevel = zapcore.InfoLevel
+	case gofalcon.LogLevelWarn:
+		zapLevel = zapcore.WarnLevel
+	default:
+		zapLevel = zapcore.ErrorLevel
+	}
+
+	ce := x.logger.Check(zapLevel, msg)
+	if ce == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	zapFields := make([]zap.Field, 0, len(keys))
+	for _, key := range keys {
+		zapFields = append(zapFields, zap.Any(key, fields[key]))
+	}
+	ce.Write(zapFields...)
+}
@@ -0,0 +1,33 @@
+package zaplog_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/gofalcon"
+	"github.com/m-mizutani/gofalcon/zaplog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zaplog.New(zap.New(core))
+
+	logger.Log(gofalcon.LogLevelDebug, "ignored", nil)
+	logger.Log(gofalcon.LogLevelWarn, "Reconnect DataFeedURL", gofalcon.LogFields{
+		gofalcon.LogKeyPartition: 1,
+		gofalcon.LogKeyAppID:     "app",
+	})
+
+	entries := logs.All()
+	require.Equal(t, 1, len(entries))
+	assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+	assert.Equal(t, "Reconnect DataFeedURL", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{
+		"app_id":    "app",
+		"partition": int64(1),
+	}, entries[0].ContextMap())
+}